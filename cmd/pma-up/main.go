@@ -2,21 +2,259 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 
+	"github.com/jsas4coding/pma-up/internal/fs"
 	"github.com/jsas4coding/pma-up/internal/updater"
 )
 
+const (
+	usage         = "Usage: pma-up [flags] <destination_path> <config_file_path>\n       pma-up -inventory <inventory.toml> [flags] <destination_path>\n       pma-up -install [flags] <destination_path> [config_file_path]\n       pma-up cleanup [flags] <destination_path> [config_file_path]\n       pma-up status [flags] <destination_path>\n       pma-up rollback [flags] <destination_path>"
+	cleanupUsage  = "Usage: pma-up cleanup [flags] <destination_path> [config_file_path]"
+	statusUsage   = "Usage: pma-up status [flags] <destination_path>"
+	rollbackUsage = "Usage: pma-up rollback [flags] <destination_path>"
+)
+
+const configModeUsage = "how a config file outside the installation reaches new releases: copy, symlink or include (a stub requiring it)"
+
+const orphanAgeUsage = "how long a temporary or staging directory left by an earlier run must be untouched before it is removed"
+
 func main() {
-	if len(os.Args) != 3 {
-		log.Fatal("Usage: pma-up <destination_path> <config_file_path>")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cleanup":
+			cleanup(os.Args[2:])
+			return
+		case "status":
+			status(os.Args[2:])
+			return
+		case "rollback":
+			rollback(os.Args[2:])
+			return
+		}
+	}
+
+	layout := flag.String("layout", string(updater.LayoutInPlace),
+		"installation layout: in-place (backup and move) or releases (versioned directories and a symlink)")
+	configMode := flag.String("config-mode", string(updater.ConfigCopy), configModeUsage)
+	inventory := flag.String("inventory", "",
+		"TOML description of servers, control user, storage and settings to render <destination_path>/config.inc.php from, instead of restoring a config file")
+	install := flag.Bool("install", false,
+		"install into a missing or empty <destination_path> instead of updating it, creating config_file_path (default <destination_path>/config.inc.php) and tmp/")
+	pinnedVersion := flag.String("version", "",
+		"phpMyAdmin release to install, e.g. 5.2.2 (default the latest release)")
+	configTemplate := flag.String("config-template", "",
+		"with -install, file to create a missing config file from (default the release's config.sample.inc.php)")
+	tmpOwner := flag.String("tmp-owner", "",
+		"with -install, user[:group] to own tmp/, usually the web server's")
+	releasesDir := flag.String("releases-dir", "",
+		"directory holding release directories for the releases layout (default <destination_path>-releases)")
+	keepReleases := flag.Int("keep-releases", updater.DefaultKeepReleases,
+		"number of releases retained by the releases layout, negative to keep all")
+	keepBackups := flag.Int("keep-backups", 0,
+		"number of backups of the installation retained, oldest removed first; 0 keeps all")
+	stagingDir := flag.String("staging-dir", "",
+		"directory to extract new releases into; keep it on the destination filesystem (default next to the installation)")
+	durable := flag.Bool("durable", false,
+		"fsync downloaded, extracted and copied files and their directories before switching to the new release")
+	hardlinks := flag.Bool("hardlinks", false,
+		"hard link instead of copying the files a release ships when its tree is copied (e.g. moved across filesystems); backups, config and user files are always copied")
+	preserve := flag.String("preserve", strings.Join(updater.DefaultPreserve, ","),
+		"comma-separated globs of user files and directories, relative to the installation, carried into new releases")
+	patchesDir := flag.String("patches-dir", "",
+		"directory of unified diffs (*.patch, *.diff) applied in name order to every new release before the swap")
+	fixBlowfishSecret := flag.Bool("fix-blowfish-secret", false,
+		"replace a missing $cfg['blowfish_secret'], or one not exactly 32 bytes long, with a generated one, backing up the config file first")
+	phpBinary := flag.String("php-binary", "",
+		"PHP binary whose version and extensions (php -v, php -m) new releases must be compatible with (default php in PATH, not checked if missing)")
+	skipPHPCheck := flag.Bool("skip-php-check", false,
+		"install new releases without checking the PHP version and extensions they require")
+	lintPHP := flag.Bool("lint-php", false,
+		"run php -l (see -php-binary) on the restored config file and preserved PHP files of the new release, and abort the update if one does not parse")
+	modified := flag.String("modified", string(updater.ModifiedWarn),
+		"what to do with locally modified core files: warn (discard them), refuse (abort the update), carry (reapply them) or ignore (skip the check)")
+	copyWorkers := flag.Int("copy-workers", fs.DefaultWorkers,
+		"number of files copied concurrently when a tree has to be copied (e.g. across filesystems)")
+	lockTimeout := flag.Duration("lock-timeout", 0,
+		"how long to wait for another run on the same destination to finish, negative to wait indefinitely")
+	orphanAge := flag.Duration("orphan-age", updater.DefaultOrphanAge, orphanAgeUsage+", negative to keep them")
+
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 && ((*inventory == "" && !*install) || flag.NArg() != 1) {
+		log.Fatal(usage)
 	}
 
-	destinationPath := os.Args[1]
-	configFilePath := os.Args[2]
+	opts := updater.Options{
+		DestinationPath:   flag.Arg(0),
+		ConfigFilePath:    flag.Arg(1),
+		ConfigMode:        updater.ConfigMode(*configMode),
+		Inventory:         *inventory,
+		Install:           *install,
+		Version:           *pinnedVersion,
+		ConfigTemplate:    *configTemplate,
+		TmpOwner:          *tmpOwner,
+		Layout:            updater.Layout(*layout),
+		ReleasesDir:       *releasesDir,
+		KeepReleases:      *keepReleases,
+		KeepBackups:       *keepBackups,
+		StagingDir:        *stagingDir,
+		Durable:           *durable,
+		Hardlinks:         *hardlinks,
+		Preserve:          splitList(*preserve),
+		PatchesDir:        *patchesDir,
+		ModifiedFiles:     updater.ModifiedPolicy(*modified),
+		FixBlowfishSecret: *fixBlowfishSecret,
+		PHPBinary:         *phpBinary,
+		SkipPHPCheck:      *skipPHPCheck,
+		LintPHP:           *lintPHP,
+		CopyWorkers:       *copyWorkers,
+		LockTimeout:       *lockTimeout,
+		OrphanAge:         *orphanAge,
+	}
 
-	if err := updater.RunUpdate(destinationPath, configFilePath); err != nil {
+	if err := updater.Run(interruptContext(), opts); err != nil {
 		log.Fatalf("Update failed: %v", err)
 	}
 }
+
+// cleanup runs the cleanup subcommand with the given arguments.
+func cleanup(args []string) {
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	configMode := flags.String("config-mode", string(updater.ConfigCopy), configModeUsage)
+	releasesDir := flags.String("releases-dir", "",
+		"directory holding release directories for the releases layout (default <destination_path>-releases)")
+	stagingDir := flags.String("staging-dir", "",
+		"directory new releases are extracted into, if set for updates")
+	lockTimeout := flags.Duration("lock-timeout", 0,
+		"how long to wait for a run on the same destination to finish, negative to wait indefinitely")
+	orphanAge := flags.Duration("orphan-age", updater.DefaultOrphanAge, orphanAgeUsage)
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), cleanupUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // ExitOnError
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		log.Fatal(cleanupUsage)
+	}
+	configFilePath := filepath.Join(flags.Arg(0), "config.inc.php")
+	if flags.NArg() == 2 {
+		configFilePath = flags.Arg(1)
+	}
+
+	opts := updater.Options{
+		DestinationPath: flags.Arg(0),
+		ConfigFilePath:  configFilePath,
+		ConfigMode:      updater.ConfigMode(*configMode),
+		ReleasesDir:     *releasesDir,
+		StagingDir:      *stagingDir,
+		LockTimeout:     *lockTimeout,
+		OrphanAge:       *orphanAge,
+	}
+
+	if err := updater.Cleanup(interruptContext(), opts); err != nil {
+		log.Fatalf("Cleanup failed: %v", err)
+	}
+}
+
+// status runs the status subcommand with the given arguments.
+func status(args []string) {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	releasesDir := flags.String("releases-dir", "",
+		"directory holding release directories for the releases layout (default <destination_path>-releases)")
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), statusUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // ExitOnError
+
+	if flags.NArg() != 1 {
+		log.Fatal(statusUsage)
+	}
+
+	opts := updater.Options{
+		DestinationPath: flags.Arg(0),
+		ConfigFilePath:  filepath.Join(flags.Arg(0), "config.inc.php"),
+		ReleasesDir:     *releasesDir,
+	}
+
+	if err := updater.Status(opts, os.Stdout); err != nil {
+		log.Fatalf("Status failed: %v", err)
+	}
+}
+
+// rollback runs the rollback subcommand with the given arguments.
+func rollback(args []string) {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	pinnedVersion := flags.String("version", "",
+		"phpMyAdmin version of the backup to restore, e.g. 5.2.1 (default the newest backup)")
+	releasesDir := flags.String("releases-dir", "",
+		"directory holding release directories for the releases layout (default <destination_path>-releases)")
+	lockTimeout := flags.Duration("lock-timeout", 0,
+		"how long to wait for a run on the same destination to finish, negative to wait indefinitely")
+
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), rollbackUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args) // ExitOnError
+
+	if flags.NArg() != 1 {
+		log.Fatal(rollbackUsage)
+	}
+
+	opts := updater.Options{
+		DestinationPath: flags.Arg(0),
+		ConfigFilePath:  filepath.Join(flags.Arg(0), "config.inc.php"),
+		Version:         *pinnedVersion,
+		ReleasesDir:     *releasesDir,
+		LockTimeout:     *lockTimeout,
+	}
+
+	if err := updater.Rollback(interruptContext(), opts); err != nil {
+		log.Fatalf("Rollback failed: %v", err)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty elements. An
+// empty value yields an empty, non-nil list.
+func splitList(value string) []string {
+	list := []string{}
+	for _, elem := range strings.Split(value, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			list = append(list, elem)
+		}
+	}
+	return list
+}
+
+// interruptContext returns a context cancelled on the first SIGINT or SIGTERM.
+// The update then stops before the swap, or completes a swap already under
+// way; a second signal terminates the process immediately.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		fmt.Printf("Received %s, stopping the update (signal again to force)...\n", sig)
+		cancel()
+	}()
+	return ctx
+}
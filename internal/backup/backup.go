@@ -0,0 +1,204 @@
+// Package backup maintains the machine-readable index of phpMyAdmin backups.
+//
+// Every backup taken by the updater is recorded in a JSON index stored next to
+// the installation, together with the phpMyAdmin version it holds, when it was
+// taken, where it came from, its size and checksum, and the pma-up version
+// that produced it. Consumers read the index instead of parsing directory names.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// Entry describes a single backup directory.
+type Entry struct {
+	Path        string    `json:"path"`         // Absolute path of the backup directory
+	Version     string    `json:"version"`      // phpMyAdmin version held by the backup, empty if unknown
+	CreatedAt   time.Time `json:"created_at"`   // Time the backup was taken
+	SourcePath  string    `json:"source_path"`  // Installation path the backup was taken from
+	Size        int64     `json:"size"`         // Total size in bytes of regular files in the backup
+	Checksum    string    `json:"checksum"`     // Content checksum, see Describe
+	ToolVersion string    `json:"tool_version"` // pma-up version that produced the backup
+}
+
+// Index is the list of known backups, persisted as JSON.
+type Index struct {
+	Backups []Entry `json:"backups"`
+}
+
+// IndexPath returns the location of the backup index for the given installation path.
+//
+// The index is a hidden file placed next to the installation, e.g.
+// /var/www/.phpmyadmin.backups.json for /var/www/phpmyadmin.
+func IndexPath(destinationPath string) string {
+	dir, base := filepath.Split(filepath.Clean(destinationPath))
+	return filepath.Join(dir, "."+base+".backups.json")
+}
+
+// Load reads the index stored at path on fsys.
+//
+// A missing index file is not an error and yields an empty index.
+func Load(fsys fs.FS, path string) (*Index, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Index{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup index: %w", err)
+	}
+
+	idx := &Index{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to parse backup index: %w", err)
+	}
+	return idx, nil
+}
+
+// Save writes the index to path on fsys.
+//
+// The index is written to a temporary file first and renamed into place, so
+// readers never observe a partially written index.
+func (idx *Index) Save(fsys fs.FS, path string) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup index: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := fs.WriteFile(fsys, tmpPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write backup index: %w", err)
+	}
+	if err := fsys.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace backup index: %w", err)
+	}
+	return nil
+}
+
+// Add records a new backup entry.
+func (idx *Index) Add(entry Entry) {
+	idx.Backups = append(idx.Backups, entry)
+}
+
+// Has reports whether the backup stored at path is recorded.
+func (idx *Index) Has(path string) bool {
+	for _, entry := range idx.Backups {
+		if entry.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove drops the entry for the backup stored at path and reports whether it was present.
+func (idx *Index) Remove(path string) bool {
+	for i, entry := range idx.Backups {
+		if entry.Path == path {
+			idx.Backups = append(idx.Backups[:i], idx.Backups[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ForSource returns the backups taken from sourcePath, newest first.
+func (idx *Index) ForSource(sourcePath string) []Entry {
+	entries := []Entry{}
+	for _, entry := range idx.Backups {
+		if entry.SourcePath == sourcePath {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries
+}
+
+// Prune drops the entries of backups taken from sourcePath whose directory no
+// longer exists, then removes the oldest backups beyond the keep newest ones,
+// directory and entry. A keep value of zero or less keeps every backup. The
+// backup at active, such as the target the installation symlink points at, is
+// never removed. It returns the removed backups, and the index is left
+// reflecting what was removed when it fails halfway.
+func (idx *Index) Prune(fsys fs.FS, sourcePath string, keep int, active string) ([]Entry, error) {
+	removed := []Entry{}
+	kept := 0
+	for _, entry := range idx.ForSource(sourcePath) {
+		if _, err := fsys.Lstat(entry.Path); errors.Is(err, os.ErrNotExist) {
+			idx.Remove(entry.Path)
+			continue
+		} else if err != nil {
+			return removed, fmt.Errorf("failed to stat backup %s: %w", entry.Path, err)
+		}
+
+		if kept++; keep <= 0 || kept <= keep || entry.Path == active {
+			continue
+		}
+		if err := fsys.RemoveAll(entry.Path); err != nil {
+			return removed, fmt.Errorf("failed to remove backup %s: %w", entry.Path, err)
+		}
+		idx.Remove(entry.Path)
+		removed = append(removed, entry)
+	}
+	return removed, nil
+}
+
+// Describe computes the total size and content checksum of the backup directory dir on fsys.
+//
+// The checksum is a SHA-256 over every entry's relative path, and for regular
+// files their content, visited in lexical order. It is reported as "sha256:<hex>".
+func Describe(fsys fs.FS, dir string) (int64, string, error) {
+	var size int64
+	sum := sha256.New()
+
+	err := fs.Walk(fsys, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(sum, "%s\x00%s\x00", filepath.ToSlash(relPath), info.Mode().Type()); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := fsys.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := file.Close(); closeErr != nil {
+				fmt.Printf("warning: failed to close %s: %v\n", path, closeErr)
+			}
+		}()
+
+		written, err := io.Copy(sum, file)
+		if err != nil {
+			return err
+		}
+		size += written
+		return nil
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to describe backup: %w", err)
+	}
+
+	return size, "sha256:" + hex.EncodeToString(sum.Sum(nil)), nil
+}
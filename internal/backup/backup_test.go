@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+func TestIndexPath(t *testing.T) {
+	got := IndexPath("/var/www/phpmyadmin/")
+	if got != "/var/www/.phpmyadmin.backups.json" {
+		t.Errorf("unexpected index path: %s", got)
+	}
+}
+
+func TestIndex_SaveLoad(t *testing.T) {
+	tempDir := t.TempDir()
+	indexPath := filepath.Join(tempDir, "index.json")
+
+	idx, err := Load(fs.OS{}, indexPath)
+	if err != nil {
+		t.Fatalf("Load on missing index failed: %v", err)
+	}
+	if len(idx.Backups) != 0 {
+		t.Fatalf("expected empty index, got %d entries", len(idx.Backups))
+	}
+
+	older := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	idx.Add(Entry{Path: "/srv/pma_backup_1", Version: "5.2.1", CreatedAt: older, SourcePath: "/srv/pma"})
+	idx.Add(Entry{Path: "/srv/pma_backup_2", Version: "5.2.2", CreatedAt: newer, SourcePath: "/srv/pma"})
+	idx.Add(Entry{Path: "/srv/other_backup_1", CreatedAt: newer, SourcePath: "/srv/other"})
+
+	if err := idx.Save(fs.OS{}, indexPath); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load(fs.OS{}, indexPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	entries := loaded.ForSource("/srv/pma")
+	if len(entries) != 2 || entries[0].Path != "/srv/pma_backup_2" || entries[0].Version != "5.2.2" {
+		t.Errorf("expected 2 entries for source, newest first, got %+v", entries)
+	}
+
+	if !loaded.Has("/srv/pma_backup_2") || loaded.Has("/srv/pma_backup_3") {
+		t.Errorf("unexpected Has result")
+	}
+	if !loaded.Remove("/srv/pma_backup_2") {
+		t.Errorf("expected entry to be removed")
+	}
+	if loaded.Remove("/srv/pma_backup_2") {
+		t.Errorf("expected second removal to report absence")
+	}
+
+	if entries := loaded.ForSource("/srv/pma"); len(entries) != 1 || entries[0].Path != "/srv/pma_backup_1" {
+		t.Errorf("unexpected entries after removal: %+v", entries)
+	}
+}
+
+func TestIndex_Prune(t *testing.T) {
+	mem := fs.NewMem()
+	idx := &Index{}
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"b1", "b2", "b3", "b4", "b5"} {
+		path := "/srv/pma_backup_" + name
+		// b2 was deleted by hand.
+		if name != "b2" {
+			if err := mem.MkdirAll(path, 0755); err != nil {
+				t.Fatalf("failed to create backup: %v", err)
+			}
+		}
+		idx.Add(Entry{Path: path, CreatedAt: start.Add(time.Duration(i) * time.Hour), SourcePath: "/srv/pma"})
+	}
+	if err := mem.MkdirAll("/srv/other_backup_1", 0755); err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+	idx.Add(Entry{Path: "/srv/other_backup_1", CreatedAt: start, SourcePath: "/srv/other"})
+
+	// b1 is the oldest but the active tree.
+	removed, err := idx.Prune(mem, "/srv/pma", 2, "/srv/pma_backup_b1")
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 1 || removed[0].Path != "/srv/pma_backup_b3" {
+		t.Errorf("expected b3 removed, got %+v", removed)
+	}
+	var remaining []string
+	for _, entry := range idx.Backups {
+		remaining = append(remaining, filepath.Base(entry.Path))
+	}
+	if want := []string{"pma_backup_b1", "pma_backup_b4", "pma_backup_b5", "other_backup_1"}; !slices.Equal(remaining, want) {
+		t.Errorf("expected entries %v, got %v", want, remaining)
+	}
+	if _, err := mem.Stat("/srv/pma_backup_b3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected pruned backup removed, got %v", err)
+	}
+
+	// Without a limit, only entries of deleted backups are dropped.
+	if err := mem.RemoveAll("/srv/pma_backup_b4"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := idx.Prune(mem, "/srv/pma", 0, ""); err != nil || len(removed) != 0 {
+		t.Errorf("expected nothing removed, got %+v (%v)", removed, err)
+	}
+	if idx.Has("/srv/pma_backup_b4") || !idx.Has("/srv/pma_backup_b5") {
+		t.Errorf("expected only the deleted backup dropped, got %+v", idx.Backups)
+	}
+}
+
+func TestLoad_Corrupted(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "index.json")
+	if err := os.WriteFile(indexPath, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+
+	_, err := Load(fs.OS{}, indexPath)
+	if err == nil || !strings.Contains(err.Error(), "failed to parse backup index") {
+		t.Errorf("expected parse error, got %v", err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tempDir := t.TempDir()
+	dir := filepath.Join(tempDir, "backup")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("world!"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	size, checksum, err := Describe(fs.OS{}, dir)
+	if err != nil {
+		t.Fatalf("Describe failed: %v", err)
+	}
+	if size != 11 {
+		t.Errorf("expected size 11, got %d", size)
+	}
+	if !strings.HasPrefix(checksum, "sha256:") {
+		t.Errorf("unexpected checksum format: %s", checksum)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("World!"), 0644); err != nil {
+		t.Fatalf("failed to rewrite file: %v", err)
+	}
+	_, changed, err := Describe(fs.OS{}, dir)
+	if err != nil {
+		t.Fatalf("Describe failed: %v", err)
+	}
+	if changed == checksum {
+		t.Errorf("expected checksum to change with content")
+	}
+
+	if _, _, err := Describe(fs.OS{}, filepath.Join(tempDir, "missing")); err == nil {
+		t.Errorf("expected error for missing directory")
+	}
+}
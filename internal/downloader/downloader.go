@@ -3,6 +3,7 @@
 package downloader
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
 )
 
 // DownloadPhpMyAdmin downloads the phpMyAdmin zip file from the provided URL,
@@ -17,14 +20,17 @@ import (
 // and returns the full path to the downloaded file.
 //
 // Parameters:
+//   - ctx: cancels the download.
+//   - fsys: filesystem the file is written to.
 //   - downloadURL: complete URL to download the phpMyAdmin zip file.
 //   - destinationDir: directory where the file should be stored.
 //   - version: version string, used for naming the output file.
 //
 // Returns:
 //   - string: full path to the downloaded zip file.
-//   - error: non-nil if the download or file creation fails.
-func DownloadPhpMyAdmin(downloadURL, destinationDir, version string) (string, error) {
+//   - error: non-nil if the download or file creation fails, or if the
+//     archive size announced by the server exceeds the free space.
+func DownloadPhpMyAdmin(ctx context.Context, fsys fs.FS, downloadURL, destinationDir, version string) (string, error) {
 	if downloadURL == "" {
 		return "", errors.New("empty download URL")
 	}
@@ -44,7 +50,7 @@ func DownloadPhpMyAdmin(downloadURL, destinationDir, version string) (string, er
 		Timeout: 60 * time.Second,
 	}
 
-	req, err := http.NewRequest("GET", downloadURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create HTTP request: %w", err)
 	}
@@ -63,7 +69,13 @@ func DownloadPhpMyAdmin(downloadURL, destinationDir, version string) (string, er
 		return "", fmt.Errorf("unexpected HTTP status: %d", resp.StatusCode)
 	}
 
-	outFile, err := os.Create(filePath)
+	if resp.ContentLength > 0 {
+		if err := fs.CheckSpace(fsys, destinationDir, uint64(resp.ContentLength)); err != nil {
+			return "", fmt.Errorf("cannot store the archive: %w", err)
+		}
+	}
+
+	outFile, err := fsys.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file: %w", err)
 	}
@@ -78,5 +90,12 @@ func DownloadPhpMyAdmin(downloadURL, destinationDir, version string) (string, er
 		return "", fmt.Errorf("failed to write to file: %w", err)
 	}
 
+	if err := outFile.Sync(); err != nil {
+		return "", fmt.Errorf("failed to sync file: %w", err)
+	}
+	if err := fs.SyncDir(fsys, destinationDir); err != nil {
+		return "", err
+	}
+
 	return filePath, nil
 }
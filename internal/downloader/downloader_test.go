@@ -1,12 +1,16 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
 )
 
 func TestDownloadPhpMyAdmin_Success(t *testing.T) {
@@ -24,7 +28,7 @@ func TestDownloadPhpMyAdmin_Success(t *testing.T) {
 	version := "5.2.2"
 	mockDownloadURL := fmt.Sprintf("%s/phpMyAdmin-%s-all-languages.zip", server.URL, version)
 
-	filePath, err := DownloadPhpMyAdmin(mockDownloadURL, tempDir, version)
+	filePath, err := DownloadPhpMyAdmin(context.Background(), fs.OS{}, mockDownloadURL, tempDir, version)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -58,7 +62,7 @@ func TestDownloadPhpMyAdmin_InputValidation(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := DownloadPhpMyAdmin(tc.url, tc.dest, tc.ver)
+			_, err := DownloadPhpMyAdmin(context.Background(), fs.OS{}, tc.url, tc.dest, tc.ver)
 			if err == nil || !strings.Contains(err.Error(), tc.experror) {
 				t.Errorf("expected error '%s', got '%v'", tc.experror, err)
 			}
@@ -68,7 +72,7 @@ func TestDownloadPhpMyAdmin_InputValidation(t *testing.T) {
 
 func TestDownloadPhpMyAdmin_RequestCreationFailure(t *testing.T) {
 	tempDir := t.TempDir()
-	_, err := DownloadPhpMyAdmin(":/invalid-url", tempDir, "5.2.2")
+	_, err := DownloadPhpMyAdmin(context.Background(), fs.OS{}, ":/invalid-url", tempDir, "5.2.2")
 	if err == nil || !strings.Contains(err.Error(), "failed to create HTTP request") {
 		t.Errorf("expected HTTP request creation error, got %v", err)
 	}
@@ -76,7 +80,7 @@ func TestDownloadPhpMyAdmin_RequestCreationFailure(t *testing.T) {
 
 func TestDownloadPhpMyAdmin_ClientFailure(t *testing.T) {
 	tempDir := t.TempDir()
-	_, err := DownloadPhpMyAdmin("http://nonexistent.invalid/file.zip", tempDir, "5.2.2")
+	_, err := DownloadPhpMyAdmin(context.Background(), fs.OS{}, "http://nonexistent.invalid/file.zip", tempDir, "5.2.2")
 	if err == nil || !strings.Contains(err.Error(), "failed to perform HTTP request") {
 		t.Errorf("expected client failure, got %v", err)
 	}
@@ -92,7 +96,7 @@ func TestDownloadPhpMyAdmin_ServerReturns500(t *testing.T) {
 	version := "5.2.2"
 	mockDownloadURL := fmt.Sprintf("%s/phpMyAdmin-%s-all-languages.zip", server.URL, version)
 
-	_, err := DownloadPhpMyAdmin(mockDownloadURL, tempDir, version)
+	_, err := DownloadPhpMyAdmin(context.Background(), fs.OS{}, mockDownloadURL, tempDir, version)
 	if err == nil || !strings.Contains(err.Error(), "unexpected HTTP status") {
 		t.Errorf("expected HTTP status error, got %v", err)
 	}
@@ -120,8 +124,95 @@ func TestDownloadPhpMyAdmin_DirectoryNotWritable(t *testing.T) {
 	version := "5.2.2"
 	mockDownloadURL := fmt.Sprintf("%s/phpMyAdmin-%s-all-languages.zip", server.URL, version)
 
-	_, err := DownloadPhpMyAdmin(mockDownloadURL, tempDir, version)
+	_, err := DownloadPhpMyAdmin(context.Background(), fs.OS{}, mockDownloadURL, tempDir, version)
 	if err == nil {
 		t.Errorf("expected permission error, got none")
 	}
 }
+
+func TestDownloadPhpMyAdmin_Durable(t *testing.T) {
+	synced := map[string]bool{}
+	fsys := &fs.FaultFS{FS: fs.OS{Durable: true}, Fail: func(op string, paths ...string) error {
+		if op == "Sync" {
+			synced[paths[0]] = true
+		}
+		return nil
+	}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, err := w.Write([]byte("PK\x03\x04 dummy zip content")); err != nil {
+			t.Fatalf("failed to write mock zip content: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	filePath, err := DownloadPhpMyAdmin(context.Background(), fsys, server.URL+"/phpMyAdmin-5.2.2-all-languages.zip", tempDir, "5.2.2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Errorf("expected downloaded file: %v", err)
+	}
+	if !synced[filePath] || !synced[tempDir] {
+		t.Errorf("expected file and directory to be synced, got %v", synced)
+	}
+}
+
+func TestDownloadPhpMyAdmin_InMemory(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, err := w.Write([]byte("PK\x03\x04 dummy zip content")); err != nil {
+			t.Fatalf("failed to write mock zip content: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/downloads", 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	filePath, err := DownloadPhpMyAdmin(context.Background(), mem, server.URL, "/downloads", "5.2.2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := fs.ReadFile(mem, filePath); err != nil || string(data) != "PK\x03\x04 dummy zip content" {
+		t.Errorf("unexpected downloaded content %q (%v)", data, err)
+	}
+
+	failing := &fs.FaultFS{FS: mem, Fail: func(op string, _ ...string) error {
+		if op == "Write" {
+			return errors.New("disk full")
+		}
+		return nil
+	}}
+	if _, err := DownloadPhpMyAdmin(context.Background(), failing, server.URL, "/downloads", "5.2.2"); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("expected write failure, got %v", err)
+	}
+}
+
+func TestDownloadPhpMyAdmin_NotEnoughSpace(t *testing.T) {
+	content := []byte("PK\x03\x04 dummy zip content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("failed to write mock zip content: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/downloads", 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := mem.SetCapacity("/downloads", uint64(len(content))-1); err != nil {
+		t.Fatalf("failed to set capacity: %v", err)
+	}
+
+	_, err := DownloadPhpMyAdmin(context.Background(), mem, server.URL, "/downloads", "5.2.2")
+	if !errors.Is(err, fs.ErrNoSpace) {
+		t.Fatalf("expected not enough space error, got %v", err)
+	}
+	if entries, _ := mem.ReadDir("/downloads"); len(entries) != 0 {
+		t.Errorf("expected nothing to be written, found %v", entries)
+	}
+}
@@ -4,23 +4,28 @@ package extractor
 
 import (
 	"archive/zip"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
 )
 
 // ExtractZip extracts the contents of a phpMyAdmin zip archive into the given destination directory.
 //
 // Parameters:
+//   - ctx: stops the extraction between files when cancelled.
+//   - fsys: filesystem holding the archive and the destination.
 //   - zipPath: full path to the zip archive to extract.
 //   - destination: target directory where the contents will be extracted.
 //
 // Returns:
 //   - error: non-nil if extraction fails.
-func ExtractZip(zipPath, destination string) error {
+func ExtractZip(ctx context.Context, fsys fs.FS, zipPath, destination string) error {
 	if zipPath == "" {
 		return errors.New("empty zip path")
 	}
@@ -28,17 +33,18 @@ func ExtractZip(zipPath, destination string) error {
 		return errors.New("empty destination path")
 	}
 
-	r, err := zip.OpenReader(zipPath)
+	r, closeZip, err := openZip(fsys, zipPath)
 	if err != nil {
-		return fmt.Errorf("failed to open zip file: %w", err)
+		return err
 	}
-	defer func() {
-		if closeErr := r.Close(); closeErr != nil {
-			fmt.Printf("warning: failed to close zip reader: %v\n", closeErr)
-		}
-	}()
+	defer closeZip()
+
+	dirs := map[string]bool{filepath.Clean(destination): true}
 
 	for _, file := range r.File {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("extraction cancelled: %w", err)
+		}
 		filePath := filepath.Join(destination, file.Name)
 
 		if !strings.HasPrefix(filePath, filepath.Clean(destination)+string(os.PathSeparator)) {
@@ -46,9 +52,10 @@ func ExtractZip(zipPath, destination string) error {
 		}
 
 		if file.FileInfo().IsDir() {
-			if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+			if err := fsys.MkdirAll(filePath, os.ModePerm); err != nil {
 				return fmt.Errorf("failed to create directory: %w", err)
 			}
+			dirs[filePath] = true
 			continue
 		}
 
@@ -62,15 +69,18 @@ func ExtractZip(zipPath, destination string) error {
 			}
 		}(srcFile)
 
-		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		if err := fsys.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
 			return fmt.Errorf("failed to create parent directories: %w", err)
 		}
+		for dir := filepath.Dir(filePath); !dirs[dir]; dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
 
-		dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
+		dstFile, err := fsys.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
 		if err != nil {
 			return fmt.Errorf("failed to create destination file: %w", err)
 		}
-		defer func(f *os.File) {
+		defer func(f fs.File) {
 			if closeErr := f.Close(); closeErr != nil {
 				fmt.Printf("warning: failed to close destination file: %v\n", closeErr)
 			}
@@ -79,7 +89,65 @@ func ExtractZip(zipPath, destination string) error {
 		if _, err := io.Copy(dstFile, srcFile); err != nil {
 			return fmt.Errorf("failed to copy file data: %w", err)
 		}
+
+		if err := dstFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync file: %w", err)
+		}
+	}
+
+	for dir := range dirs {
+		if err := fs.SyncDir(fsys, dir); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// UncompressedSize returns the total size of the files of a zip archive, as
+// recorded in its central directory, without extracting it.
+//
+// Parameters:
+//   - fsys: filesystem holding the archive.
+//   - zipPath: full path to the zip archive.
+//
+// Returns:
+//   - uint64: the size the extracted files will take.
+//   - error: non-nil if the archive cannot be read.
+func UncompressedSize(fsys fs.FS, zipPath string) (uint64, error) {
+	r, closeZip, err := openZip(fsys, zipPath)
+	if err != nil {
+		return 0, err
+	}
+	defer closeZip()
+
+	var size uint64
+	for _, file := range r.File {
+		size += file.UncompressedSize64
+	}
+	return size, nil
+}
+
+// openZip opens the zip archive at zipPath and returns a reader for it along
+// with the function closing it.
+func openZip(fsys fs.FS, zipPath string) (*zip.Reader, func(), error) {
+	zipFile, err := fsys.Open(zipPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open zip file: %w", err)
+	}
+	closeZip := func() {
+		if closeErr := zipFile.Close(); closeErr != nil {
+			fmt.Printf("warning: failed to close zip reader: %v\n", closeErr)
+		}
+	}
+
+	zipInfo, err := zipFile.Stat()
+	if err == nil {
+		var r *zip.Reader
+		if r, err = zip.NewReader(zipFile, zipInfo.Size()); err == nil {
+			return r, closeZip, nil
+		}
+	}
+	closeZip()
+	return nil, nil, fmt.Errorf("failed to open zip file: %w", err)
+}
@@ -2,10 +2,14 @@ package extractor
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
 )
 
 func TestExtractZip_Success(t *testing.T) {
@@ -21,7 +25,7 @@ func TestExtractZip_Success(t *testing.T) {
 	}
 
 	extractDir := filepath.Join(tempDir, "extracted")
-	if err := ExtractZip(zipPath, extractDir); err != nil {
+	if err := ExtractZip(context.Background(), fs.OS{}, zipPath, extractDir); err != nil {
 		t.Fatalf("ExtractZip failed: %v", err)
 	}
 
@@ -42,7 +46,7 @@ func TestExtractZip_FailureScenarios(t *testing.T) {
 
 	t.Run("file not found", func(t *testing.T) {
 		extractDir := filepath.Join(tempDir, "extracted1")
-		err := ExtractZip(filepath.Join(tempDir, "nonexistent.zip"), extractDir)
+		err := ExtractZip(context.Background(), fs.OS{}, filepath.Join(tempDir, "nonexistent.zip"), extractDir)
 		if err == nil {
 			t.Errorf("expected error for nonexistent file")
 		}
@@ -54,14 +58,14 @@ func TestExtractZip_FailureScenarios(t *testing.T) {
 			t.Fatalf("failed to write corrupted zip: %v", err)
 		}
 		extractDir := filepath.Join(tempDir, "extracted2")
-		if err := ExtractZip(badZipPath, extractDir); err == nil {
+		if err := ExtractZip(context.Background(), fs.OS{}, badZipPath, extractDir); err == nil {
 			t.Errorf("expected error for corrupted zip")
 		}
 	})
 
 	t.Run("empty zip path", func(t *testing.T) {
 		extractDir := filepath.Join(tempDir, "extracted3")
-		err := ExtractZip("", extractDir)
+		err := ExtractZip(context.Background(), fs.OS{}, "", extractDir)
 		if err == nil || !strings.Contains(err.Error(), "empty zip path") {
 			t.Errorf("expected empty zip path error")
 		}
@@ -73,7 +77,7 @@ func TestExtractZip_FailureScenarios(t *testing.T) {
 		if err := createTestZip(t, zipPath, testFiles); err != nil {
 			t.Fatalf("failed to create test zip: %v", err)
 		}
-		err := ExtractZip(zipPath, "")
+		err := ExtractZip(context.Background(), fs.OS{}, zipPath, "")
 		if err == nil || !strings.Contains(err.Error(), "empty destination path") {
 			t.Errorf("expected empty destination path error")
 		}
@@ -96,7 +100,7 @@ func TestExtractZip_FailureScenarios(t *testing.T) {
 			}
 		}()
 
-		if err := ExtractZip(zipPath, extractDir); err == nil {
+		if err := ExtractZip(context.Background(), fs.OS{}, zipPath, extractDir); err == nil {
 			t.Errorf("expected permission error")
 		}
 	})
@@ -107,7 +111,7 @@ func TestExtractZip_FailureScenarios(t *testing.T) {
 			t.Fatalf("failed to create evil zip: %v", err)
 		}
 		extractDir := filepath.Join(tempDir, "extracted4")
-		if err := ExtractZip(zipPath, extractDir); err == nil || !strings.Contains(err.Error(), "invalid file path detected") {
+		if err := ExtractZip(context.Background(), fs.OS{}, zipPath, extractDir); err == nil || !strings.Contains(err.Error(), "invalid file path detected") {
 			t.Errorf("expected invalid path detection")
 		}
 	})
@@ -142,3 +146,95 @@ func createTestZip(t *testing.T, zipPath string, files map[string]string) error
 	}
 	return nil
 }
+
+func TestExtractZip_Durable(t *testing.T) {
+	synced := map[string]bool{}
+	fsys := &fs.FaultFS{FS: fs.OS{Durable: true}, Fail: func(op string, paths ...string) error {
+		if op == "Sync" {
+			synced[paths[0]] = true
+		}
+		return nil
+	}}
+
+	tempDir := t.TempDir()
+	zipPath := filepath.Join(tempDir, "test.zip")
+	testFiles := map[string]string{
+		"phpMyAdmin/index.php":      "<?php",
+		"phpMyAdmin/libs/deep/a.js": "js",
+	}
+	if err := createTestZip(t, zipPath, testFiles); err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+
+	extractDir := filepath.Join(tempDir, "extracted")
+	if err := ExtractZip(context.Background(), fsys, zipPath, extractDir); err != nil {
+		t.Fatalf("ExtractZip failed: %v", err)
+	}
+	for name, content := range testFiles {
+		data, err := os.ReadFile(filepath.Join(extractDir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("unexpected content for %s: %q (%v)", name, data, err)
+		}
+		if !synced[filepath.Join(extractDir, name)] {
+			t.Errorf("expected %s to be synced", name)
+		}
+	}
+	for _, dir := range []string{".", "phpMyAdmin", "phpMyAdmin/libs", "phpMyAdmin/libs/deep"} {
+		if !synced[filepath.Join(extractDir, dir)] {
+			t.Errorf("expected directory %s to be synced", dir)
+		}
+	}
+}
+
+func TestExtractZip_InMemory(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "test.zip")
+	if err := createTestZip(t, zipPath, map[string]string{"phpMyAdmin/index.php": "<?php"}); err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+	archive, err := os.ReadFile(zipPath)
+	if err != nil {
+		t.Fatalf("failed to read test zip: %v", err)
+	}
+
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/tmp", 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := fs.WriteFile(mem, "/tmp/test.zip", archive, 0644); err != nil {
+		t.Fatalf("failed to write zip: %v", err)
+	}
+
+	if err := ExtractZip(context.Background(), mem, "/tmp/test.zip", "/srv/extracted"); err != nil {
+		t.Fatalf("ExtractZip failed: %v", err)
+	}
+	if data, err := fs.ReadFile(mem, "/srv/extracted/phpMyAdmin/index.php"); err != nil || string(data) != "<?php" {
+		t.Errorf("unexpected extracted content %q (%v)", data, err)
+	}
+
+	failing := &fs.FaultFS{FS: mem, Fail: func(op string, _ ...string) error {
+		if op == "OpenFile" {
+			return errors.New("read-only filesystem")
+		}
+		return nil
+	}}
+	if err := ExtractZip(context.Background(), failing, "/tmp/test.zip", "/srv/failed"); err == nil || !strings.Contains(err.Error(), "read-only filesystem") {
+		t.Errorf("expected create failure, got %v", err)
+	}
+}
+
+func TestUncompressedSize(t *testing.T) {
+	tempDir := t.TempDir()
+	zipPath := filepath.Join(tempDir, "test.zip")
+	if err := createTestZip(t, zipPath, map[string]string{"a/index.php": "12345", "b.txt": "123"}); err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+
+	size, err := UncompressedSize(fs.OS{}, zipPath)
+	if err != nil || size != 8 {
+		t.Errorf("expected 8 bytes, got %d (%v)", size, err)
+	}
+
+	if _, err := UncompressedSize(fs.OS{}, filepath.Join(tempDir, "missing.zip")); err == nil {
+		t.Errorf("expected error for missing archive")
+	}
+}
@@ -0,0 +1,30 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request, _IOW(0x94, 9, int).
+const ficlone = 0x40049409
+
+// Clone makes dst share src's data blocks through a copy-on-write clone.
+//
+// It only succeeds on filesystems with reflink support (Btrfs, XFS, bcachefs,
+// OCFS2...) when both files live on the same filesystem.
+func (OS) Clone(dst, src File) error {
+	dstFile, srcFile, ok := osFiles(dst, src)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return cloneFile(dstFile, srcFile)
+}
+
+func cloneFile(dst, src *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	if errno != 0 {
+		return &os.PathError{Op: "ficlone", Path: dst.Name(), Err: errno}
+	}
+	return nil
+}
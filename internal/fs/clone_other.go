@@ -0,0 +1,13 @@
+//go:build !linux
+
+package fs
+
+import "errors"
+
+// Clone makes dst share src's data blocks through a copy-on-write clone.
+//
+// Clones are only implemented on Linux; elsewhere it always fails so callers
+// fall back to a byte copy.
+func (OS) Clone(File, File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,214 @@
+package fs
+
+import (
+	"errors"
+	"os"
+)
+
+// FaultFS wraps an FS and fails the operations selected by Fail, so that
+// tests can exercise error paths deterministically and in parallel.
+type FaultFS struct {
+	FS
+
+	// Fail is called before every operation with the name of the FS or File
+	// method ("Rename", "Write", "Sync"...) and the paths it involves. A
+	// non-nil result is returned instead of performing the operation. Fail
+	// may be called concurrently.
+	Fail func(op string, paths ...string) error
+}
+
+func (f *FaultFS) fail(op string, paths ...string) error {
+	if f.Fail == nil {
+		return nil
+	}
+	return f.Fail(op, paths...)
+}
+
+func (f *FaultFS) wrapFile(file File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: file, fsys: f}, nil
+}
+
+func (f *FaultFS) Open(name string) (File, error) {
+	if err := f.fail("Open", name); err != nil {
+		return nil, err
+	}
+	return f.wrapFile(f.FS.Open(name))
+}
+
+func (f *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := f.fail("OpenFile", name); err != nil {
+		return nil, err
+	}
+	return f.wrapFile(f.FS.OpenFile(name, flag, perm))
+}
+
+func (f *FaultFS) Stat(name string) (os.FileInfo, error) {
+	if err := f.fail("Stat", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Stat(name)
+}
+
+func (f *FaultFS) Lstat(name string) (os.FileInfo, error) {
+	if err := f.fail("Lstat", name); err != nil {
+		return nil, err
+	}
+	return f.FS.Lstat(name)
+}
+
+func (f *FaultFS) ReadDir(name string) ([]os.DirEntry, error) {
+	if err := f.fail("ReadDir", name); err != nil {
+		return nil, err
+	}
+	return f.FS.ReadDir(name)
+}
+
+func (f *FaultFS) MkdirAll(path string, perm os.FileMode) error {
+	if err := f.fail("MkdirAll", path); err != nil {
+		return err
+	}
+	return f.FS.MkdirAll(path, perm)
+}
+
+func (f *FaultFS) MkdirTemp(dir, pattern string) (string, error) {
+	if err := f.fail("MkdirTemp", dir); err != nil {
+		return "", err
+	}
+	return f.FS.MkdirTemp(dir, pattern)
+}
+
+func (f *FaultFS) Rename(oldpath, newpath string) error {
+	if err := f.fail("Rename", oldpath, newpath); err != nil {
+		return err
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+func (f *FaultFS) RemoveAll(path string) error {
+	if err := f.fail("RemoveAll", path); err != nil {
+		return err
+	}
+	return f.FS.RemoveAll(path)
+}
+
+func (f *FaultFS) Symlink(oldname, newname string) error {
+	if err := f.fail("Symlink", oldname, newname); err != nil {
+		return err
+	}
+	return f.FS.Symlink(oldname, newname)
+}
+
+func (f *FaultFS) Readlink(name string) (string, error) {
+	if err := f.fail("Readlink", name); err != nil {
+		return "", err
+	}
+	return f.FS.Readlink(name)
+}
+
+func (f *FaultFS) Link(oldname, newname string) error {
+	if err := f.fail("Link", oldname, newname); err != nil {
+		return err
+	}
+	return f.FS.Link(oldname, newname)
+}
+
+func (f *FaultFS) Chmod(name string, mode os.FileMode) error {
+	if err := f.fail("Chmod", name); err != nil {
+		return err
+	}
+	return f.FS.Chmod(name, mode)
+}
+
+// Clone forwards to the wrapped FS, failing when it cannot clone.
+func (f *FaultFS) Clone(dst, src File) error {
+	if err := f.fail("Clone", dst.Name(), src.Name()); err != nil {
+		return err
+	}
+	c, ok := f.FS.(cloner)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return c.Clone(unwrapFault(dst), unwrapFault(src))
+}
+
+// CopyMetadata forwards to the wrapped FS, doing nothing when it cannot copy metadata.
+func (f *FaultFS) CopyMetadata(path, targetPath string, info os.FileInfo) error {
+	if err := f.fail("CopyMetadata", path, targetPath); err != nil {
+		return err
+	}
+	return copyMetadata(f.FS, path, targetPath, info)
+}
+
+// IsMountPoint forwards to the wrapped FS.
+func (f *FaultFS) IsMountPoint(path string) (bool, error) {
+	if err := f.fail("IsMountPoint", path); err != nil {
+		return false, err
+	}
+	return IsMountPoint(f.FS, path)
+}
+
+// DiskUsage forwards to the wrapped FS.
+func (f *FaultFS) DiskUsage(path string) (Usage, error) {
+	if err := f.fail("DiskUsage", path); err != nil {
+		return Usage{}, err
+	}
+	return DiskUsage(f.FS, path)
+}
+
+// TempDir forwards to the wrapped FS.
+func (f *FaultFS) TempDir() string {
+	return TempDir(f.FS)
+}
+
+// Chown forwards to the wrapped FS.
+func (f *FaultFS) Chown(name string, uid, gid int) error {
+	if err := f.fail("Chown", name); err != nil {
+		return err
+	}
+	return Chown(f.FS, name, uid, gid)
+}
+
+// TryLock forwards to the wrapped FS.
+func (f *FaultFS) TryLock(file File) error {
+	if err := f.fail("TryLock", file.Name()); err != nil {
+		return err
+	}
+	return TryLock(f.FS, unwrapFault(file))
+}
+
+// faultFile is a File opened through a FaultFS.
+type faultFile struct {
+	File
+	fsys *FaultFS
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.fsys.fail("Write", f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fsys.fail("Sync", f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Chmod(mode os.FileMode) error {
+	if err := f.fsys.fail("Chmod", f.Name()); err != nil {
+		return err
+	}
+	return f.File.Chmod(mode)
+}
+
+func unwrapFault(file File) File {
+	if f, ok := file.(*faultFile); ok {
+		return f.File
+	}
+	return file
+}
@@ -1,52 +1,159 @@
 // Package fs provides file system operations used in the update process.
 //
-// It offers utilities to move directories (handling cross-device moves),
-// copy entire directory trees, and copy individual files with full error handling.
+// Every operation goes through the FS interface, implemented by OS for the
+// host filesystem and by Mem in memory, so the whole update pipeline can be
+// exercised, including failures injected with FaultFS, without touching disk
+// or global state.
+//
+// On top of FS it offers utilities to move directories (handling cross-device moves),
+// copy and mirror entire directory trees, copy individual files, atomically
+// switch symlinks and detect mount points, with full error handling.
 //
 // The functions in this package ensure safe and reliable file system manipulations,
 // preserving file permissions and handling edge cases across different platforms.
 package fs
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 )
 
-// Injected functions for deterministic fault injection
-type injFunc struct {
-	Rename    func(string, string) error
-	RemoveAll func(string) error
-	MkdirAll  func(string, os.FileMode) error
-	Open      func(string) (*os.File, error)
-	OpenFile  func(string, int, os.FileMode) (*os.File, error)
-	Copy      func(io.Writer, io.Reader) (int64, error)
-	Rel       func(string, string) (string, error)
-	Walk      func(string, filepath.WalkFunc) error
-	Stat      func(string) (os.FileInfo, error)
-}
-
-var inj = injFunc{
-	Rename:    os.Rename,
-	RemoveAll: os.RemoveAll,
-	MkdirAll:  os.MkdirAll,
-	Open:      os.Open,
-	OpenFile:  os.OpenFile,
-	Copy:      io.Copy,
-	Rel:       filepath.Rel,
-	Walk:      filepath.Walk,
+// FS is the filesystem the update pipeline works on.
+//
+// OS implements it for the host filesystem and Mem in memory. Implementations
+// may also provide copy-on-write clones, metadata copies, mount point
+// detection, free space reporting, advisory locks and ownership changes by
+// implementing the Clone, CopyMetadata, IsMountPoint, DiskUsage, TryLock and
+// Chown methods of OS, and name their default temporary directory with the
+// TempDir method of Mem; the helpers of this package fall back gracefully
+// when they do not.
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	MkdirAll(path string, perm os.FileMode) error
+	MkdirTemp(dir, pattern string) (string, error)
+	Rename(oldpath, newpath string) error
+	RemoveAll(path string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Link(oldname, newname string) error
+	Chmod(name string, mode os.FileMode) error
+}
+
+// File is an open file or directory of an FS.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Chmod(mode os.FileMode) error
+}
+
+type cloner interface {
+	Clone(dst, src File) error
+}
+
+type metadataCopier interface {
+	CopyMetadata(path, targetPath string, info os.FileInfo) error
+}
+
+type mountChecker interface {
+	IsMountPoint(path string) (bool, error)
+}
+
+type usageReporter interface {
+	DiskUsage(path string) (Usage, error)
+}
+
+type locker interface {
+	TryLock(f File) error
+}
+
+type chowner interface {
+	Chown(name string, uid, gid int) error
+}
+
+type tempDirer interface {
+	TempDir() string
+}
+
+// Usage describes the filesystem holding a path.
+type Usage struct {
+	Device uint64 // Identifies the filesystem; paths on the same one share it
+	Free   uint64 // Bytes available for new files
+}
+
+// DefaultWorkers is the number of files copied concurrently when
+// CopyOptions.Workers is not set.
+const DefaultWorkers = 8
+
+// CopyOptions tunes how directory trees are copied.
+type CopyOptions struct {
+	// Hardlink, when set, reports whether the regular file at rel, its
+	// slash-separated path relative to the copied tree, is hard linked
+	// instead of duplicated. It suits release files that are only ever
+	// replaced, never modified in place; linking falls back to copying when
+	// it fails (e.g. across filesystems). Nothing is linked when nil.
+	Hardlink func(rel string) bool
+
+	// Workers bounds how many files are written concurrently. Higher values
+	// help on high-latency storage such as NFS; DefaultWorkers when below 1.
+	Workers int
+
+	// LockDir, when set, marks a staging or trash directory of a
+	// cross-device move (see MoveDir) as in use, right after creating it
+	// and for as long as the move needs it, so that cleanups of leftover
+	// directories leave it alone. It returns the function releasing the
+	// mark; anything it creates in dir is removed along with dir.
+	LockDir func(dir string) (unlock func(), err error)
+}
+
+// SyncDir flushes the entries of directory dir, making creations, renames and
+// removals inside it durable on filesystems that sync (see OS.Durable).
+func SyncDir(fsys FS, dir string) error {
+	dirFile, err := fsys.Open(dir)
+	if err != nil {
+		return wrap("failed to open directory for sync", err)
+	}
+	defer safeClose("directory", dirFile)
+
+	if err := dirFile.Sync(); err != nil {
+		return wrap("failed to sync directory "+dir, err)
+	}
+	return nil
 }
 
 // MoveDir moves a directory from source to destination.
 //
-// If the source and destination are on different devices, MoveDir transparently falls back
-// to a recursive copy followed by removal of the source directory.
-func MoveDir(source, dest string) error {
-	err := inj.Rename(source, dest)
+// If the source and destination are on different devices, MoveDir falls back
+// to copying source into a staging directory next to dest, on the destination
+// filesystem, and renaming the copy into place. The source is then renamed
+// into a trash directory and removed. Both directories are locked with
+// opts.LockDir while in use. A failed attempt removes the staging directory,
+// so callers observe either the complete source or the complete destination,
+// never a partial copy. A move that cannot be made durable is undone and
+// reported as failed. Cancelling ctx stops such a copy; a rename is not
+// interrupted.
+func MoveDir(ctx context.Context, fsys FS, source, dest string, opts CopyOptions) error {
+	err := fsys.Rename(source, dest)
 	if err == nil {
+		if err := syncParents(fsys, source, dest); err != nil {
+			return undoMove(fsys, dest, source, err)
+		}
 		return nil
 	}
 
@@ -55,20 +162,138 @@ func MoveDir(source, dest string) error {
 		return wrap("rename failed", err)
 	}
 
-	if err := copyDir(source, dest); err != nil {
+	stagingDir, unlockStaging, err := makeLockedDir(fsys, filepath.Dir(dest), StagingPattern(dest), opts)
+	if err != nil {
+		return wrap("failed to create staging directory", err)
+	}
+	// The copy is staged one level down, so that the lock stays behind.
+	staged := filepath.Join(stagingDir, filepath.Base(dest))
+	discardStaging := func() {
+		unlockStaging()
+		discard(fsys, stagingDir)
+	}
+
+	if err := copyDir(ctx, fsys, source, staged, opts); err != nil {
+		discardStaging()
 		return wrap("copyDir failed", err)
 	}
 
-	if err := inj.RemoveAll(source); err != nil {
+	if err := matchMode(fsys, source, staged); err != nil {
+		discardStaging()
+		return wrap("failed to apply source permissions", err)
+	}
+
+	if err := fsys.Rename(staged, dest); err != nil {
+		discardStaging()
+		return wrap("failed to move staging directory into place", err)
+	}
+	discardStaging()
+
+	trashDir, unlockTrash, err := makeLockedDir(fsys, filepath.Dir(source), TrashPattern(source), opts)
+	if err != nil {
+		// Undo the move so that only the complete source remains.
+		discard(fsys, dest)
+		return wrap("failed to create trash directory", err)
+	}
+	trashed := filepath.Join(trashDir, filepath.Base(source))
+	discardTrash := func() {
+		unlockTrash()
+		discard(fsys, trashDir)
+	}
+
+	if err := fsys.Rename(source, trashed); err != nil {
+		discardTrash()
+		discard(fsys, dest)
 		return wrap("failed to cleanup source after copy", err)
 	}
 
+	if err := syncParents(fsys, source, dest); err != nil {
+		if undoErr := fsys.Rename(trashed, source); undoErr != nil {
+			unlockTrash()
+			return wrap("failed to undo move of "+source, errors.Join(err, undoErr))
+		}
+		discardTrash()
+		discard(fsys, dest)
+		return err
+	}
+
+	unlockTrash()
+	if err := fsys.RemoveAll(trashDir); err != nil {
+		log.Printf("warning: failed to remove %s after copy: %v", trashDir, err)
+	}
+
 	return nil
 }
 
+// makeLockedDir creates a directory in parent named after pattern, as
+// MkdirTemp does, and locks it with opts.LockDir. It returns the directory
+// and the function releasing its lock.
+func makeLockedDir(fsys FS, parent, pattern string, opts CopyOptions) (string, func(), error) {
+	dir, err := fsys.MkdirTemp(parent, pattern)
+	if err != nil {
+		return "", nil, err
+	}
+	if opts.LockDir == nil {
+		return dir, func() {}, nil
+	}
+	unlock, err := opts.LockDir(dir)
+	if err != nil {
+		discard(fsys, dir)
+		return "", nil, err
+	}
+	return dir, unlock, nil
+}
+
+// syncParents makes a rename of source to dest durable by syncing both parent directories.
+func syncParents(fsys FS, source, dest string) error {
+	if err := SyncDir(fsys, filepath.Dir(dest)); err != nil {
+		return err
+	}
+	if filepath.Dir(source) == filepath.Dir(dest) {
+		return nil
+	}
+	return SyncDir(fsys, filepath.Dir(source))
+}
+
+// undoMove renames moved back to source after the move failed with cause.
+func undoMove(fsys FS, moved, source string, cause error) error {
+	if err := fsys.Rename(moved, source); err != nil {
+		return wrap("failed to undo move of "+source, errors.Join(cause, err))
+	}
+	return cause
+}
+
+// StagingPattern returns the MkdirTemp pattern for staging directories
+// created next to dest, e.g. ".phpmyadmin.pma-up-staging-*".
+func StagingPattern(dest string) string {
+	return "." + filepath.Base(filepath.Clean(dest)) + ".pma-up-staging-*"
+}
+
+// TrashPattern returns the MkdirTemp pattern for the directories a moved
+// source is removed from, created next to it, e.g. ".phpmyadmin.pma-up-trash-*".
+func TrashPattern(source string) string {
+	return "." + filepath.Base(filepath.Clean(source)) + ".pma-up-trash-*"
+}
+
+// matchMode gives dest the permission bits of source.
+func matchMode(fsys FS, source, dest string) error {
+	info, err := fsys.Stat(source)
+	if err != nil {
+		return err
+	}
+	return fsys.Chmod(dest, info.Mode().Perm())
+}
+
+// discard removes a partially created tree, logging failures.
+func discard(fsys FS, path string) {
+	if err := fsys.RemoveAll(path); err != nil {
+		log.Printf("warning: failed to remove %s: %v", path, err)
+	}
+}
+
 // CopyFile copies a single file from src to dst.
-func CopyFile(src, dst string) error {
-	sourceFileStat, err := os.Stat(src)
+func CopyFile(fsys FS, src, dst string) error {
+	sourceFileStat, err := fsys.Stat(src)
 	if err != nil {
 		return wrap("failed to stat source file", err)
 	}
@@ -77,57 +302,417 @@ func CopyFile(src, dst string) error {
 		return wrap("source file is not regular", nil)
 	}
 
-	source, err := inj.Open(src)
+	source, err := fsys.Open(src)
 	if err != nil {
 		return wrap("failed to open source file", err)
 	}
 	defer safeClose("source", source)
 
-	destination, err := inj.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, sourceFileStat.Mode())
+	destination, err := fsys.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, sourceFileStat.Mode())
 	if err != nil {
 		return wrap("failed to create destination file", err)
 	}
 	defer safeClose("destination", destination)
 
-	_, err = inj.Copy(destination, source)
-	if err != nil {
+	if err := copyContent(fsys, destination, source); err != nil {
 		return wrap("failed to copy file content", err)
 	}
 
+	if err := destination.Sync(); err != nil {
+		return wrap("failed to sync "+dst, err)
+	}
+
+	return SyncDir(fsys, filepath.Dir(dst))
+}
+
+// ReplaceSymlink atomically points link at target.
+//
+// A temporary symlink is created next to link and renamed over it, so readers
+// observe either the previous target or the new one, never a missing link.
+// link must not be an existing directory.
+func ReplaceSymlink(fsys FS, target, link string) error {
+	tmpLink := fmt.Sprintf("%s.tmp-%d", link, os.Getpid())
+
+	if err := fsys.RemoveAll(tmpLink); err != nil {
+		return wrap("failed to remove leftover temporary symlink", err)
+	}
+
+	if err := fsys.Symlink(target, tmpLink); err != nil {
+		return wrap("failed to create temporary symlink", err)
+	}
+
+	if err := fsys.Rename(tmpLink, link); err != nil {
+		if removeErr := fsys.RemoveAll(tmpLink); removeErr != nil {
+			log.Printf("warning: failed to remove temporary symlink: %v", removeErr)
+		}
+		return wrap("failed to switch symlink", err)
+	}
+
+	return SyncDir(fsys, filepath.Dir(link))
+}
+
+// CopyDir recursively copies the directory tree at source to dest. Cancelling
+// ctx stops the copy, leaving dest partially copied.
+func CopyDir(ctx context.Context, fsys FS, source, dest string, opts CopyOptions) error {
+	if err := copyDir(ctx, fsys, source, dest, opts); err != nil {
+		return wrap("copyDir failed", err)
+	}
 	return nil
 }
 
-func copyDir(source, dest string) error {
-	return inj.Walk(source, func(path string, info os.FileInfo, err error) error {
+// MirrorDir makes the existing directory dest an exact copy of source, in place.
+//
+// It is meant for destinations that cannot be renamed, such as mount points:
+// dest itself is never replaced. Files whose content or mode differs are
+// rewritten through a temporary file and a rename, unchanged files are left
+// untouched, and entries missing from source are removed. Cancelling ctx stops
+// between entries, leaving dest partially synchronized.
+func MirrorDir(ctx context.Context, fsys FS, source, dest string) error {
+	err := Walk(fsys, source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		relPath, err := inj.Rel(source, path)
+		relPath, err := filepath.Rel(source, path)
 		if err != nil {
 			return err
 		}
 		targetPath := filepath.Join(dest, relPath)
 
 		if info.IsDir() {
-			return inj.MkdirAll(targetPath, info.Mode())
+			if relPath == "." {
+				return nil
+			}
+			if targetInfo, statErr := fsys.Lstat(targetPath); statErr == nil && !targetInfo.IsDir() {
+				if err := fsys.RemoveAll(targetPath); err != nil {
+					return err
+				}
+			}
+			return fsys.MkdirAll(targetPath, info.Mode())
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return mirrorSymlink(fsys, path, targetPath)
+		}
+
+		same, err := sameFile(fsys, path, info, targetPath)
+		if err != nil || same {
+			return err
+		}
+
+		if targetInfo, statErr := fsys.Lstat(targetPath); statErr == nil && targetInfo.IsDir() {
+			if err := fsys.RemoveAll(targetPath); err != nil {
+				return err
+			}
 		}
+		return replaceFile(fsys, path, targetPath, info.Mode())
+	})
+	if err != nil {
+		return wrap("failed to mirror directory", err)
+	}
 
-		srcFile, err := inj.Open(path)
+	err = Walk(fsys, dest, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		defer safeClose("source file", srcFile)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-		destFile, err := inj.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+		relPath, err := filepath.Rel(dest, path)
 		if err != nil {
 			return err
 		}
-		defer safeClose("dest file", destFile)
+		if relPath == "." {
+			return nil
+		}
+
+		if _, statErr := fsys.Lstat(filepath.Join(source, relPath)); !errors.Is(statErr, os.ErrNotExist) {
+			return statErr
+		}
 
-		_, err = inj.Copy(destFile, srcFile)
+		if err := fsys.RemoveAll(path); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return wrap("failed to remove stale entries", err)
+	}
+
+	return SyncDir(fsys, dest)
+}
+
+// mirrorSymlink recreates the symlink at path as targetPath unless targetPath
+// is already a symlink with the same target.
+func mirrorSymlink(fsys FS, path, targetPath string) error {
+	linkTarget, err := fsys.Readlink(path)
+	if err != nil {
+		return err
+	}
+	if existing, err := fsys.Readlink(targetPath); err == nil && existing == linkTarget {
+		return nil
+	}
+	if err := fsys.RemoveAll(targetPath); err != nil {
 		return err
+	}
+	return fsys.Symlink(linkTarget, targetPath)
+}
+
+// sameFile reports whether the regular file at targetPath has the same mode
+// and content as the file at path described by info.
+func sameFile(fsys FS, path string, info os.FileInfo, targetPath string) (bool, error) {
+	targetInfo, err := fsys.Lstat(targetPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !targetInfo.Mode().IsRegular() || targetInfo.Mode() != info.Mode() || targetInfo.Size() != info.Size() {
+		return false, nil
+	}
+
+	srcData, err := ReadFile(fsys, path)
+	if err != nil {
+		return false, err
+	}
+	dstData, err := ReadFile(fsys, targetPath)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcData, dstData), nil
+}
+
+// replaceFile copies path to a temporary file next to targetPath and renames
+// it over targetPath, so readers never observe a partially written file.
+func replaceFile(fsys FS, path, targetPath string, mode os.FileMode) error {
+	tmpPath := targetPath + ".pma-up-tmp"
+
+	srcFile, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer safeClose("source file", srcFile)
+
+	tmpFile, err := fsys.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if err := copyContent(fsys, tmpFile, srcFile); err != nil {
+		safeClose("temporary file", tmpFile)
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	// Apply the mode explicitly so the umask does not make the file look changed next time.
+	if err := tmpFile.Chmod(mode); err != nil {
+		safeClose("temporary file", tmpFile)
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		safeClose("temporary file", tmpFile)
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+
+	if err := fsys.Rename(tmpPath, targetPath); err != nil {
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	return SyncDir(fsys, filepath.Dir(targetPath))
+}
+
+// copyDir recursively copies source to dest. Symlinks are recreated as
+// symlinks rather than followed, and every entry's ownership and extended
+// attributes are carried over where the platform allows it (see copyMetadata).
+// Regular files are hard linked when opts.Hardlink selects them, and otherwise
+// cloned or, failing that, copied byte by byte.
+//
+// The tree is walked in order, creating directories and symlinks as they are
+// visited, so a directory always exists before its children. Regular files are
+// handed to up to opts.Workers goroutines. The first failure, or the cancellation
+// of ctx, stops the walk and is returned once in-flight copies have finished.
+func copyDir(ctx context.Context, fsys FS, source, dest string, opts CopyOptions) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+
+	jobs := make(chan copyJob)
+	failure := &firstError{}
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if failure.get() != nil || ctx.Err() != nil {
+					continue
+				}
+				if err := copyFileEntry(fsys, job.path, job.targetPath, job.info, job.hardlink); err != nil {
+					failure.set(err)
+				}
+			}
+		}()
+	}
+
+	dirs := []string{}
+	walkErr := Walk(fsys, source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := failure.get(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(dest, relPath)
+
+		switch {
+		case info.IsDir():
+			err = fsys.MkdirAll(targetPath, info.Mode())
+			dirs = append(dirs, targetPath)
+		case info.Mode()&os.ModeSymlink != 0:
+			err = copySymlink(fsys, path, targetPath)
+		default:
+			hardlink := opts.Hardlink != nil && opts.Hardlink(filepath.ToSlash(relPath))
+			jobs <- copyJob{path: path, targetPath: targetPath, info: info, hardlink: hardlink}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return copyMetadata(fsys, path, targetPath, info)
 	})
+
+	close(jobs)
+	wg.Wait()
+
+	if walkErr != nil {
+		return walkErr
+	}
+	if err := failure.get(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if err := SyncDir(fsys, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyJob is a regular file queued for copying by copyDir.
+type copyJob struct {
+	path       string
+	targetPath string
+	info       os.FileInfo
+	hardlink   bool
+}
+
+// copyFileEntry links or copies the regular file at path to targetPath,
+// along with its metadata.
+func copyFileEntry(fsys FS, path, targetPath string, info os.FileInfo, hardlink bool) error {
+	// A hard link shares the inode, and with it ownership and attributes.
+	if hardlink && fsys.Link(path, targetPath) == nil {
+		return nil
+	}
+	if err := copyRegular(fsys, path, targetPath, info.Mode()); err != nil {
+		return err
+	}
+	return copyMetadata(fsys, path, targetPath, info)
+}
+
+// firstError records the first error reported by concurrent workers.
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *firstError) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *firstError) get() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// copySymlink recreates the symlink at path as targetPath, pointing at the same target.
+func copySymlink(fsys FS, path, targetPath string) error {
+	linkTarget, err := fsys.Readlink(path)
+	if err != nil {
+		return err
+	}
+	return fsys.Symlink(linkTarget, targetPath)
+}
+
+// copyRegular copies the content of the regular file at path into targetPath.
+func copyRegular(fsys FS, path, targetPath string, mode os.FileMode) error {
+	srcFile, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer safeClose("source file", srcFile)
+
+	destFile, err := fsys.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer safeClose("dest file", destFile)
+
+	if err := copyContent(fsys, destFile, srcFile); err != nil {
+		return err
+	}
+	return destFile.Sync()
+}
+
+// copyContent fills the empty file dst with the content of src, preferring a
+// copy-on-write clone and falling back to a byte copy where clones are unsupported.
+func copyContent(fsys FS, dst, src File) error {
+	if c, ok := fsys.(cloner); ok && c.Clone(dst, src) == nil {
+		return nil
+	}
+	_, err := io.Copy(dst, src)
+	return err
+}
+
+// copyMetadata carries ownership and extended attributes of path over to
+// targetPath on filesystems that support it (see OS.CopyMetadata).
+func copyMetadata(fsys FS, path, targetPath string, info os.FileInfo) error {
+	if m, ok := fsys.(metadataCopier); ok {
+		return m.CopyMetadata(path, targetPath, info)
+	}
+	return nil
 }
 
 func safeClose(label string, c io.Closer) {
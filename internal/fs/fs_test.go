@@ -1,31 +1,48 @@
 package fs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
+	"time"
 )
 
-func resetInjection() {
-	inj = injFunc{
-		Rename:    os.Rename,
-		RemoveAll: os.RemoveAll,
-		MkdirAll:  os.MkdirAll,
-		Open:      os.Open,
-		OpenFile:  os.OpenFile,
-		Copy:      io.Copy,
-		Rel:       filepath.Rel,
-		Walk:      filepath.Walk,
-		Stat:      os.Stat,
+// faultyOS returns the host filesystem, failing operations as fail decides.
+// Clones are refused so that failures injected into writes stay deterministic
+// on reflink-capable filesystems; TestCopyDir_Clone covers the real path.
+func faultyOS(fail func(op string, paths ...string) error) *FaultFS {
+	return &FaultFS{FS: OS{}, Fail: func(op string, paths ...string) error {
+		if op == "Clone" {
+			return errors.ErrUnsupported
+		}
+		if fail == nil {
+			return nil
+		}
+		return fail(op, paths...)
+	}}
+}
+
+// failOp fails every op operation with err.
+func failOp(op string, err error) func(string, ...string) error {
+	return func(got string, _ ...string) error {
+		if got == op {
+			return err
+		}
+		return nil
 	}
 }
 
 func TestCopyFile_Success(t *testing.T) {
-	resetInjection()
 	tempDir := t.TempDir()
 	srcFile := filepath.Join(tempDir, "source.txt")
 	dstFile := filepath.Join(tempDir, "dest.txt")
@@ -35,7 +52,7 @@ func TestCopyFile_Success(t *testing.T) {
 		t.Fatalf("failed to write source file: %v", err)
 	}
 
-	if err := CopyFile(srcFile, dstFile); err != nil {
+	if err := CopyFile(OS{}, srcFile, dstFile); err != nil {
 		t.Fatalf("CopyFile failed: %v", err)
 	}
 
@@ -50,12 +67,11 @@ func TestCopyFile_Success(t *testing.T) {
 }
 
 func TestCopyFile_Errors(t *testing.T) {
-	resetInjection()
 	tempDir := t.TempDir()
 
 	t.Run("stat fails", func(t *testing.T) {
-		inj.Stat = func(string) (os.FileInfo, error) { return nil, fmt.Errorf("stat failed") }
-		err := CopyFile("nonexistent", "out")
+		fsys := faultyOS(failOp("Stat", fmt.Errorf("stat failed")))
+		err := CopyFile(fsys, "nonexistent", "out")
 		if err == nil {
 			t.Errorf("expected error, got nil")
 		}
@@ -69,7 +85,7 @@ func TestCopyFile_Errors(t *testing.T) {
 		if err := os.Mkdir(dirPath, 0755); err != nil {
 			t.Fatalf("failed to create dir: %v", err)
 		}
-		err := CopyFile(dirPath, "out")
+		err := CopyFile(OS{}, dirPath, "out")
 		if err == nil {
 			t.Errorf("expected non-regular file error")
 		}
@@ -77,7 +93,6 @@ func TestCopyFile_Errors(t *testing.T) {
 }
 
 func TestMoveDir_Success(t *testing.T) {
-	resetInjection()
 	tempDir := t.TempDir()
 	sourceDir := filepath.Join(tempDir, "source")
 	destDir := filepath.Join(tempDir, "dest")
@@ -88,13 +103,12 @@ func TestMoveDir_Success(t *testing.T) {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	if err := MoveDir(sourceDir, destDir); err != nil {
+	if err := MoveDir(context.Background(), OS{}, sourceDir, destDir, CopyOptions{}); err != nil {
 		t.Fatalf("MoveDir failed: %v", err)
 	}
 }
 
 func TestMoveDir_EXDEV(t *testing.T) {
-	resetInjection()
 	tempDir := t.TempDir()
 	sourceDir := filepath.Join(tempDir, "source")
 	destDir := filepath.Join(tempDir, "dest")
@@ -105,22 +119,170 @@ func TestMoveDir_EXDEV(t *testing.T) {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	inj.Rename = func(_, _ string) error {
-		return &os.LinkError{
-			Op:  "rename",
-			Old: sourceDir,
-			New: destDir,
-			Err: syscall.EXDEV,
+	fsys := faultyOS(crossDeviceRename(sourceDir, destDir))
+
+	if err := MoveDir(context.Background(), fsys, sourceDir, destDir, CopyOptions{}); err != nil {
+		t.Fatalf("MoveDir EXDEV failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "file.txt"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("unexpected moved content %q (%v)", data, err)
+	}
+	if _, err := os.Stat(sourceDir); !os.IsNotExist(err) {
+		t.Errorf("expected source to be removed, got %v", err)
+	}
+	assertNoLeftovers(t, tempDir)
+}
+
+func TestMoveDir_EXDEVPartialCopy(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	destDir := filepath.Join(tempDir, "dest")
+	writeTree(t, sourceDir, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"})
+
+	t.Run("copy fails halfway", func(t *testing.T) {
+		crossDevice := crossDeviceRename(sourceDir, destDir)
+		var writes atomic.Int32
+		fsys := faultyOS(func(op string, paths ...string) error {
+			if op == "Write" && writes.Add(1) == 2 {
+				return fmt.Errorf("disk full")
+			}
+			return crossDevice(op, paths...)
+		})
+
+		if err := MoveDir(context.Background(), fsys, sourceDir, destDir, CopyOptions{}); err == nil {
+			t.Fatalf("expected copy failure")
+		}
+		if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+			t.Errorf("expected no destination after failed copy, got %v", err)
+		}
+		if data, err := os.ReadFile(filepath.Join(sourceDir, "c.txt")); err != nil || string(data) != "c" {
+			t.Errorf("expected source intact, got %q (%v)", data, err)
 		}
+		assertNoLeftovers(t, tempDir)
+	})
+
+	t.Run("staging rename fails", func(t *testing.T) {
+		fsys := faultyOS(func(op string, paths ...string) error {
+			if op == "Rename" && paths[1] == destDir {
+				return &os.LinkError{Op: "rename", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+			}
+			return nil
+		})
+
+		if err := MoveDir(context.Background(), fsys, sourceDir, destDir, CopyOptions{}); err == nil {
+			t.Fatalf("expected rename failure")
+		}
+		if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+			t.Errorf("expected no destination, got %v", err)
+		}
+		assertNoLeftovers(t, tempDir)
+	})
+
+	t.Run("source cannot be moved aside", func(t *testing.T) {
+		fsys := faultyOS(func(op string, paths ...string) error {
+			if op == "Rename" && paths[0] == sourceDir {
+				return &os.LinkError{Op: "rename", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+			}
+			return nil
+		})
+
+		if err := MoveDir(context.Background(), fsys, sourceDir, destDir, CopyOptions{}); err == nil {
+			t.Fatalf("expected cleanup failure")
+		}
+		if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+			t.Errorf("expected destination to be rolled back, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(sourceDir, "a.txt")); err != nil {
+			t.Errorf("expected source intact: %v", err)
+		}
+	})
+}
+
+func TestMoveDir_EXDEVLocksDirs(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceDir := filepath.Join(tempDir, "source")
+	destDir := filepath.Join(tempDir, "dest")
+	writeTree(t, sourceDir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+
+	// The lock is a file in the directory, released by removing it.
+	var locked []string
+	held := map[string]bool{}
+	opts := CopyOptions{LockDir: func(dir string) (func(), error) {
+		locked = append(locked, filepath.Base(dir))
+		held[dir] = true
+		if err := os.WriteFile(filepath.Join(dir, ".lock"), nil, 0644); err != nil {
+			return nil, err
+		}
+		return func() { held[dir] = false }, nil
+	}}
+	// Every file is copied into a locked staging directory.
+	fsys := faultyOS(func(op string, paths ...string) error {
+		if op == "OpenFile" {
+			rel, _ := filepath.Rel(tempDir, paths[0])
+			top, _, _ := strings.Cut(rel, string(filepath.Separator))
+			if strings.HasPrefix(top, ".") && !held[filepath.Join(tempDir, top)] {
+				return fmt.Errorf("write outside a locked directory: %s", paths[0])
+			}
+		}
+		return crossDeviceRename(sourceDir, destDir)(op, paths...)
+	})
+
+	if err := MoveDir(context.Background(), fsys, sourceDir, destDir, opts); err != nil {
+		t.Fatalf("MoveDir failed: %v", err)
 	}
+	if len(locked) != 2 || !strings.HasPrefix(locked[0], ".dest.pma-up-staging-") || !strings.HasPrefix(locked[1], ".source.pma-up-trash-") {
+		t.Errorf("expected staging and trash directories locked, got %v", locked)
+	}
+	for dir, stillHeld := range held {
+		if stillHeld {
+			t.Errorf("expected %s unlocked", dir)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(destDir, ".lock")); !os.IsNotExist(err) {
+		t.Errorf("expected lock left out of the destination, got %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "sub", "b.txt")); err != nil || string(data) != "b" {
+		t.Errorf("unexpected moved content %q (%v)", data, err)
+	}
+	assertNoLeftovers(t, tempDir)
 
-	if err := MoveDir(sourceDir, destDir); err != nil {
-		t.Fatalf("MoveDir EXDEV failed: %v", err)
+	lockErr := errors.New("locked by another process")
+	opts.LockDir = func(string) (func(), error) { return nil, lockErr }
+	if err := MoveDir(context.Background(), faultyOS(crossDeviceRename(destDir, sourceDir)), destDir, sourceDir, opts); !errors.Is(err, lockErr) {
+		t.Errorf("expected lock failure, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "a.txt")); err != nil {
+		t.Errorf("expected source intact: %v", err)
+	}
+	assertNoLeftovers(t, tempDir)
+}
+
+// crossDeviceRename fails the rename of source to dest with EXDEV, like a move
+// across filesystems, and lets every other operation through.
+func crossDeviceRename(source, dest string) func(string, ...string) error {
+	return func(op string, paths ...string) error {
+		if op == "Rename" && paths[0] == source && paths[1] == dest {
+			return &os.LinkError{Op: "rename", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+		}
+		return nil
+	}
+}
+
+// assertNoLeftovers fails if staging or trash directories remain in dir.
+func assertNoLeftovers(t *testing.T, dir string) {
+	t.Helper()
+	leftovers, err := filepath.Glob(filepath.Join(dir, ".*.pma-up-*"))
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("unexpected leftovers: %v", leftovers)
 	}
 }
 
 func TestFaultInjection_CopyDirFailures(t *testing.T) {
-	resetInjection()
 	tempDir := t.TempDir()
 	sourceDir := filepath.Join(tempDir, "source")
 	destDir := filepath.Join(tempDir, "dest")
@@ -131,62 +293,628 @@ func TestFaultInjection_CopyDirFailures(t *testing.T) {
 		t.Fatalf("failed to write file: %v", err)
 	}
 
-	t.Run("Walk fails", func(t *testing.T) {
-		inj.Walk = func(string, filepath.WalkFunc) error { return fmt.Errorf("walk failed") }
-		err := copyDir(sourceDir, destDir)
+	for _, op := range []string{"Lstat", "ReadDir", "MkdirAll", "Open", "OpenFile", "Write", "CopyMetadata"} {
+		t.Run(op+" fails", func(t *testing.T) {
+			fsys := faultyOS(failOp(op, fmt.Errorf("%s failed", op)))
+			err := copyDir(context.Background(), fsys, sourceDir, filepath.Join(tempDir, "dest-"+op), CopyOptions{})
+			if err == nil || !strings.Contains(err.Error(), op+" failed") {
+				t.Errorf("expected %s failure, got %v", op, err)
+			}
+		})
+	}
+
+	t.Run("RemoveAll fails", func(t *testing.T) {
+		fsys := faultyOS(func(op string, paths ...string) error {
+			switch op {
+			case "RemoveAll":
+				return fmt.Errorf("removeall failed")
+			case "Rename":
+				return &os.LinkError{Op: "rename", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+			}
+			return nil
+		})
+		err := MoveDir(context.Background(), fsys, sourceDir, destDir, CopyOptions{})
 		if err == nil {
-			t.Errorf("expected walk failure")
+			t.Errorf("expected removeall failure")
 		}
 	})
+}
 
-	t.Run("Rel fails", func(t *testing.T) {
-		inj.Rel = func(string, string) (string, error) { return "", fmt.Errorf("rel failed") }
-		err := copyDir(sourceDir, destDir)
-		if err == nil {
-			t.Errorf("expected rel failure")
+func TestReplaceSymlink(t *testing.T) {
+	tempDir := t.TempDir()
+	first := filepath.Join(tempDir, "release-1")
+	second := filepath.Join(tempDir, "release-2")
+	link := filepath.Join(tempDir, "current")
+	for _, dir := range []string{first, second} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	if err := ReplaceSymlink(OS{}, first, link); err != nil {
+		t.Fatalf("ReplaceSymlink failed on missing link: %v", err)
+	}
+	if err := ReplaceSymlink(OS{}, second, link); err != nil {
+		t.Fatalf("ReplaceSymlink failed on existing link: %v", err)
+	}
+
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("failed to read link: %v", err)
+	}
+	if target != second {
+		t.Errorf("expected link to point at %s, got %s", second, target)
+	}
+
+	leftovers, err := filepath.Glob(link + ".tmp-*")
+	if err != nil {
+		t.Fatalf("failed to glob: %v", err)
+	}
+	if len(leftovers) != 0 {
+		t.Errorf("expected no temporary symlinks, found %v", leftovers)
+	}
+}
+
+func TestReplaceSymlink_Errors(t *testing.T) {
+	tempDir := t.TempDir()
+	link := filepath.Join(tempDir, "current")
+
+	t.Run("Symlink fails", func(t *testing.T) {
+		fsys := faultyOS(failOp("Symlink", fmt.Errorf("symlink failed")))
+		if err := ReplaceSymlink(fsys, tempDir, link); err == nil {
+			t.Errorf("expected symlink failure")
 		}
 	})
 
-	t.Run("MkdirAll fails", func(t *testing.T) {
-		inj.MkdirAll = func(string, os.FileMode) error { return fmt.Errorf("mkdir failed") }
-		err := copyDir(sourceDir, destDir)
-		if err == nil {
-			t.Errorf("expected mkdir failure")
+	t.Run("Rename fails", func(t *testing.T) {
+		fsys := faultyOS(failOp("Rename", fmt.Errorf("rename failed")))
+		if err := ReplaceSymlink(fsys, tempDir, link); err == nil {
+			t.Errorf("expected rename failure")
+		}
+		leftovers, _ := filepath.Glob(link + ".tmp-*")
+		if len(leftovers) != 0 {
+			t.Errorf("expected temporary symlink to be cleaned up, found %v", leftovers)
 		}
 	})
+}
 
-	t.Run("Open fails", func(t *testing.T) {
-		inj.Open = func(string) (*os.File, error) { return nil, fmt.Errorf("open failed") }
-		err := copyDir(sourceDir, destDir)
-		if err == nil {
-			t.Errorf("expected open failure")
+func TestMirrorDir(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	dest := filepath.Join(tempDir, "dest")
+
+	writeTree(t, source, map[string]string{
+		"index.php":        "new index",
+		"unchanged.php":    "same",
+		"libs/new.php":     "added",
+		"replaced/dir.php": "now a directory",
+	})
+	writeTree(t, dest, map[string]string{
+		"index.php":          "old index",
+		"unchanged.php":      "same",
+		"stale.php":          "remove me",
+		"stale-dir/file.php": "remove me too",
+		"replaced":           "was a file",
+	})
+
+	unchangedBefore, err := os.Stat(filepath.Join(dest, "unchanged.php"))
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+
+	if err := MirrorDir(context.Background(), OS{}, source, dest); err != nil {
+		t.Fatalf("MirrorDir failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"index.php":        "new index",
+		"unchanged.php":    "same",
+		"libs/new.php":     "added",
+		"replaced/dir.php": "now a directory",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(data) != content {
+			t.Errorf("unexpected content for %s: %q (%v)", name, data, err)
+		}
+	}
+
+	for _, name := range []string{"stale.php", "stale-dir"} {
+		if _, err := os.Lstat(filepath.Join(dest, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+
+	unchangedAfter, err := os.Stat(filepath.Join(dest, "unchanged.php"))
+	if err != nil {
+		t.Fatalf("failed to stat: %v", err)
+	}
+	if !os.SameFile(unchangedBefore, unchangedAfter) {
+		t.Errorf("expected unchanged file to be left in place")
+	}
+}
+
+func TestMirrorDir_Errors(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	dest := filepath.Join(tempDir, "dest")
+	writeTree(t, source, map[string]string{"file.txt": "new"})
+	writeTree(t, dest, map[string]string{"file.txt": "old"})
+
+	t.Run("Write fails", func(t *testing.T) {
+		fsys := faultyOS(failOp("Write", fmt.Errorf("write failed")))
+		if err := MirrorDir(context.Background(), fsys, source, dest); err == nil {
+			t.Errorf("expected write failure")
+		}
+		data, err := os.ReadFile(filepath.Join(dest, "file.txt"))
+		if err != nil || string(data) != "old" {
+			t.Errorf("expected original file untouched, got %q (%v)", data, err)
+		}
+		leftovers, _ := filepath.Glob(filepath.Join(dest, "*.pma-up-tmp"))
+		if len(leftovers) != 0 {
+			t.Errorf("expected temporary files to be removed, found %v", leftovers)
 		}
 	})
 
-	t.Run("OpenFile fails", func(t *testing.T) {
-		inj.OpenFile = func(string, int, os.FileMode) (*os.File, error) { return nil, fmt.Errorf("openfile failed") }
-		err := copyDir(sourceDir, destDir)
-		if err == nil {
-			t.Errorf("expected openfile failure")
+	t.Run("Rename fails", func(t *testing.T) {
+		fsys := faultyOS(failOp("Rename", fmt.Errorf("rename failed")))
+		if err := MirrorDir(context.Background(), fsys, source, dest); err == nil {
+			t.Errorf("expected rename failure")
 		}
 	})
+}
 
-	t.Run("Copy fails", func(t *testing.T) {
-		inj.Copy = func(io.Writer, io.Reader) (int64, error) { return 0, fmt.Errorf("copy failed") }
-		err := copyDir(sourceDir, destDir)
-		if err == nil {
-			t.Errorf("expected copy failure")
+func TestIsMountPoint(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mount point detection is only implemented on Linux")
+	}
+
+	mounted, err := IsMountPoint(OS{}, "/")
+	if err != nil || !mounted {
+		t.Errorf("expected / to be a mount point, got %v (%v)", mounted, err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "plain")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	mounted, err = IsMountPoint(OS{}, dir)
+	if err != nil || mounted {
+		t.Errorf("expected plain directory not to be a mount point, got %v (%v)", mounted, err)
+	}
+
+	if _, err := IsMountPoint(OS{}, filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing path")
+	}
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+}
+
+func TestCopyDir_Symlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	dest := filepath.Join(tempDir, "dest")
+	writeTree(t, source, map[string]string{"themes/pmahomme/theme.json": "{}"})
+	if err := os.Symlink("themes/pmahomme", filepath.Join(source, "default-theme")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink("missing-target", filepath.Join(source, "dangling")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	if err := CopyDir(context.Background(), OS{}, source, dest, CopyOptions{}); err != nil {
+		t.Fatalf("CopyDir failed: %v", err)
+	}
+
+	for name, expected := range map[string]string{"default-theme": "themes/pmahomme", "dangling": "missing-target"} {
+		target, err := os.Readlink(filepath.Join(dest, name))
+		if err != nil || target != expected {
+			t.Errorf("expected %s to be a symlink to %s, got %q (%v)", name, expected, target, err)
+		}
+	}
+}
+
+func TestMirrorDir_Symlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	dest := filepath.Join(tempDir, "dest")
+	writeTree(t, source, map[string]string{"a.txt": "a"})
+	writeTree(t, dest, map[string]string{"link": "regular file"})
+	if err := os.Symlink("a.txt", filepath.Join(source, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	if err := MirrorDir(context.Background(), OS{}, source, dest); err != nil {
+		t.Fatalf("MirrorDir failed: %v", err)
+	}
+	target, err := os.Readlink(filepath.Join(dest, "link"))
+	if err != nil || target != "a.txt" {
+		t.Errorf("expected mirrored symlink, got %q (%v)", target, err)
+	}
+}
+
+func TestDurable_SyncsFilesAndDirectories(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	writeTree(t, source, map[string]string{"index.php": "<?php", "libs/a.php": "<?php"})
+
+	var mu sync.Mutex
+	synced := map[string]int{}
+	fsys := &FaultFS{FS: OS{Durable: true}, Fail: func(op string, paths ...string) error {
+		if op == "Sync" {
+			mu.Lock()
+			defer mu.Unlock()
+			synced[paths[0]]++
+		}
+		return nil
+	}}
+
+	dest := filepath.Join(tempDir, "dest")
+	if err := CopyDir(context.Background(), fsys, source, dest, CopyOptions{}); err != nil {
+		t.Fatalf("CopyDir failed: %v", err)
+	}
+	for _, name := range []string{"index.php", "libs/a.php", ".", "libs"} {
+		if synced[filepath.Join(dest, name)] == 0 {
+			t.Errorf("expected %s to be synced", name)
+		}
+	}
+
+	if err := CopyFile(fsys, filepath.Join(source, "index.php"), filepath.Join(tempDir, "copy.php")); err != nil {
+		t.Fatalf("CopyFile failed: %v", err)
+	}
+	if synced[filepath.Join(tempDir, "copy.php")] == 0 || synced[tempDir] == 0 {
+		t.Errorf("expected copied file and its directory to be synced, got %v", synced)
+	}
+
+	moved := filepath.Join(tempDir, "moved", "dest")
+	if err := os.MkdirAll(filepath.Dir(moved), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	delete(synced, tempDir)
+	if err := MoveDir(context.Background(), fsys, dest, moved, CopyOptions{}); err != nil {
+		t.Fatalf("MoveDir failed: %v", err)
+	}
+	if synced[filepath.Dir(moved)] == 0 || synced[tempDir] == 0 {
+		t.Errorf("expected both parent directories to be synced after move, got %v", synced)
+	}
+}
+
+func TestDurable_SyncFailures(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	writeTree(t, source, map[string]string{"index.php": "<?php"})
+	failSync := failOp("Sync", fmt.Errorf("sync failed"))
+
+	t.Run("CopyFile", func(t *testing.T) {
+		fsys := faultyOS(failSync)
+		err := CopyFile(fsys, filepath.Join(source, "index.php"), filepath.Join(tempDir, "copy.php"))
+		if err == nil || !strings.Contains(err.Error(), "sync failed") {
+			t.Errorf("expected sync failure, got %v", err)
 		}
 	})
 
-	t.Run("RemoveAll fails", func(t *testing.T) {
-		inj.RemoveAll = func(string) error { return fmt.Errorf("removeall failed") }
-		inj.Rename = func(_, _ string) error {
-			return &os.LinkError{Op: "rename", Err: syscall.EXDEV}
+	t.Run("MoveDir", func(t *testing.T) {
+		dest := filepath.Join(tempDir, "renamed")
+		if err := MoveDir(context.Background(), faultyOS(failSync), source, dest, CopyOptions{}); err == nil {
+			t.Errorf("expected sync failure")
 		}
-		err := MoveDir(sourceDir, destDir)
-		if err == nil {
-			t.Errorf("expected removeall failure")
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("expected rename to be undone, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(source, "index.php")); err != nil {
+			t.Errorf("expected source intact: %v", err)
+		}
+	})
+
+	t.Run("MoveDir EXDEV", func(t *testing.T) {
+		dest := filepath.Join(tempDir, "dest")
+		crossDevice := crossDeviceRename(source, dest)
+		fsys := faultyOS(func(op string, paths ...string) error {
+			if err := failSync(op, paths...); err != nil {
+				return err
+			}
+			return crossDevice(op, paths...)
+		})
+		if err := MoveDir(context.Background(), fsys, source, dest, CopyOptions{}); err == nil {
+			t.Errorf("expected sync failure")
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("expected no destination after failed sync, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(source, "index.php")); err != nil {
+			t.Errorf("expected source intact: %v", err)
+		}
+	})
+
+	t.Run("ReplaceSymlink", func(t *testing.T) {
+		fsys := faultyOS(failSync)
+		if err := ReplaceSymlink(fsys, source, filepath.Join(tempDir, "current")); err == nil {
+			t.Errorf("expected sync failure")
+		}
+	})
+
+	t.Run("only when durable", func(t *testing.T) {
+		for _, durable := range []bool{false, true} {
+			file, err := OS{Durable: durable}.Open(source)
+			if err != nil {
+				t.Fatalf("failed to open: %v", err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatalf("failed to close: %v", err)
+			}
+			// Syncing a closed file fails, unless Sync is a no-op.
+			if err := file.Sync(); (err != nil) != durable {
+				t.Errorf("durable=%v: unexpected sync result %v", durable, err)
+			}
+		}
+	})
+}
+
+// cloningFS counts clones, performed as byte copies, and regular writes.
+type cloningFS struct {
+	*FaultFS
+	clones, writes atomic.Int32
+}
+
+func (c *cloningFS) Clone(dst, src File) error {
+	c.clones.Add(1)
+	_, err := io.Copy(unwrapFault(dst), unwrapFault(src))
+	return err
+}
+
+func TestCopyDir_Clone(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	writeTree(t, source, map[string]string{"index.php": "<?php echo 1;"})
+
+	t.Run("clone used when supported", func(t *testing.T) {
+		fsys := &cloningFS{}
+		fsys.FaultFS = faultyOS(func(op string, _ ...string) error {
+			if op == "Write" {
+				fsys.writes.Add(1)
+			}
+			return nil
+		})
+
+		if err := CopyDir(context.Background(), fsys, source, filepath.Join(tempDir, "cloned"), CopyOptions{}); err != nil {
+			t.Fatalf("CopyDir failed: %v", err)
+		}
+		if fsys.clones.Load() != 1 || fsys.writes.Load() != 0 {
+			t.Errorf("expected a clone and no byte copy, got %d clones and %d writes", fsys.clones.Load(), fsys.writes.Load())
+		}
+	})
+
+	t.Run("real clone or fallback", func(t *testing.T) {
+		dest := filepath.Join(tempDir, "real")
+		if err := CopyDir(context.Background(), OS{}, source, dest, CopyOptions{}); err != nil {
+			t.Fatalf("CopyDir failed: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dest, "index.php"))
+		if err != nil || string(data) != "<?php echo 1;" {
+			t.Errorf("unexpected copied content %q (%v)", data, err)
 		}
 	})
 }
+
+func TestCopyDir_Hardlinks(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	writeTree(t, source, map[string]string{"index.php": "<?php", "libs/a.php": "<?php", "config.inc.php": "<?php"})
+	opts := CopyOptions{Hardlink: func(rel string) bool { return rel != "config.inc.php" }}
+
+	t.Run("linked", func(t *testing.T) {
+		dest := filepath.Join(tempDir, "linked")
+		if err := CopyDir(context.Background(), OS{}, source, dest, opts); err != nil {
+			t.Fatalf("CopyDir failed: %v", err)
+		}
+		for name, linked := range map[string]bool{"index.php": true, "libs/a.php": true, "config.inc.php": false} {
+			srcInfo, _ := os.Stat(filepath.Join(source, name))
+			dstInfo, err := os.Stat(filepath.Join(dest, name))
+			if err != nil || os.SameFile(srcInfo, dstInfo) != linked {
+				t.Errorf("expected %s hard linked %t (%v)", name, linked, err)
+			}
+		}
+	})
+
+	t.Run("fallback to copy", func(t *testing.T) {
+		fsys := faultyOS(func(op string, paths ...string) error {
+			if op == "Link" {
+				return &os.LinkError{Op: "link", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+			}
+			return nil
+		})
+		dest := filepath.Join(tempDir, "copied")
+		if err := CopyDir(context.Background(), fsys, source, dest, opts); err != nil {
+			t.Fatalf("CopyDir failed: %v", err)
+		}
+		srcInfo, _ := os.Stat(filepath.Join(source, "index.php"))
+		dstInfo, err := os.Stat(filepath.Join(dest, "index.php"))
+		if err != nil || os.SameFile(srcInfo, dstInfo) {
+			t.Errorf("expected an independent copy (%v)", err)
+		}
+	})
+}
+
+func TestCopyDir_Parallel(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	files := map[string]string{}
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("dir%d/sub/file%d.php", i%5, i)] = fmt.Sprintf("content %d", i)
+	}
+	writeTree(t, source, files)
+	opts := CopyOptions{Workers: 4}
+
+	t.Run("bounded and complete", func(t *testing.T) {
+		var inFlight, peak atomic.Int32
+		fsys := faultyOS(func(op string, paths ...string) error {
+			switch op {
+			case "Write":
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					seen := peak.Load()
+					if current <= seen || peak.CompareAndSwap(seen, current) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+			case "OpenFile":
+				if _, err := os.Stat(filepath.Dir(paths[0])); err != nil {
+					t.Errorf("parent of %s not created before the file: %v", paths[0], err)
+				}
+			}
+			return nil
+		})
+
+		dest := filepath.Join(tempDir, "dest")
+		if err := CopyDir(context.Background(), fsys, source, dest, opts); err != nil {
+			t.Fatalf("CopyDir failed: %v", err)
+		}
+		for name, content := range files {
+			data, err := os.ReadFile(filepath.Join(dest, name))
+			if err != nil || string(data) != content {
+				t.Errorf("unexpected content for %s: %q (%v)", name, data, err)
+			}
+		}
+		if peak.Load() < 2 || peak.Load() > 4 {
+			t.Errorf("expected between 2 and 4 concurrent copies, peak was %d", peak.Load())
+		}
+	})
+
+	t.Run("first error propagates", func(t *testing.T) {
+		var writes atomic.Int32
+		fsys := faultyOS(func(op string, _ ...string) error {
+			if op == "Write" && writes.Add(1) == 10 {
+				return fmt.Errorf("copy failed")
+			}
+			return nil
+		})
+
+		err := CopyDir(context.Background(), fsys, source, filepath.Join(tempDir, "failed"), opts)
+		if err == nil || !strings.Contains(err.Error(), "copy failed") {
+			t.Fatalf("expected copy failure, got %v", err)
+		}
+		if writes.Load() >= int32(len(files)) {
+			t.Errorf("expected the copy to stop early, %d files were copied", writes.Load())
+		}
+	})
+
+	t.Run("cancellation stops the copy", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var writes atomic.Int32
+		fsys := faultyOS(func(op string, _ ...string) error {
+			if op == "Write" && writes.Add(1) == 10 {
+				cancel()
+			}
+			return nil
+		})
+
+		err := CopyDir(ctx, fsys, source, filepath.Join(tempDir, "cancelled"), opts)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected cancellation, got %v", err)
+		}
+		if writes.Load() >= int32(len(files)) {
+			t.Errorf("expected the copy to stop early, %d files were copied", writes.Load())
+		}
+	})
+}
+
+func TestWalk(t *testing.T) {
+	fsys := NewMem()
+	for _, name := range []string{"/root/b/file", "/root/a/file", "/root/c"} {
+		if err := fsys.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := WriteFile(fsys, name, []byte(name), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+
+	visited := []string{}
+	err := Walk(fsys, "/root", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path)
+		if path == "/root/b" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+
+	expected := []string{"/root", "/root/a", "/root/a/file", "/root/b", "/root/c"}
+	if strings.Join(visited, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected walk order %v", visited)
+	}
+
+	err = Walk(fsys, "/missing", func(_ string, _ os.FileInfo, err error) error { return err })
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected missing root to be reported, got %v", err)
+	}
+}
+
+func TestEvalSymlinks(t *testing.T) {
+	fsys := NewMem()
+	if err := fsys.MkdirAll("/srv/releases/5.2.2", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := fsys.Symlink("releases/5.2.2", "/srv/current"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := fsys.Symlink("/srv/current", "/www"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+
+	resolved, err := EvalSymlinks(fsys, "/www")
+	if err != nil || resolved != filepath.FromSlash("/srv/releases/5.2.2") {
+		t.Errorf("unexpected resolution %q (%v)", resolved, err)
+	}
+
+	if err := fsys.Symlink("/loop", "/loop"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if _, err := EvalSymlinks(fsys, "/loop"); err == nil {
+		t.Errorf("expected symlink loop to fail")
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTree(t, tempDir, map[string]string{"index.php": "12345", "libs/a.php": "123"})
+
+	usage, err := DiskUsage(OS{}, filepath.Join(tempDir, "missing", "dir"))
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("free space is not reported on this platform")
+	}
+	if err != nil || usage.Free == 0 {
+		t.Errorf("expected free space on the temp filesystem, got %+v (%v)", usage, err)
+	}
+	if err := CheckSpace(OS{}, tempDir, math.MaxUint64); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("expected not enough space, got %v", err)
+	}
+
+	if size, err := DirSize(OS{}, tempDir); err != nil || size != 8 {
+		t.Errorf("expected 8 bytes, got %d (%v)", size, err)
+	}
+	for bytes, expected := range map[uint64]string{512: "512 B", 1536: "1.5 KiB", 10 << 30: "10.0 GiB"} {
+		if got := HumanSize(bytes); got != expected {
+			t.Errorf("HumanSize(%d) = %q, expected %q", bytes, got, expected)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package fs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// TryLock places an exclusive flock on f without waiting.
+//
+// Filesystems that do not support flock, such as some NFS mounts, fail with
+// errors.ErrUnsupported.
+func (OS) TryLock(f File) error {
+	file, ok := f.(*osFile)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, syscall.EWOULDBLOCK):
+		return ErrLocked
+	case errors.Is(err, syscall.ENOLCK), errors.Is(err, syscall.EOPNOTSUPP), errors.Is(err, syscall.ENOTSUP):
+		return errors.Join(errors.ErrUnsupported, err)
+	}
+	return &os.PathError{Op: "flock", Path: file.Name(), Err: err}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fs
+
+import "errors"
+
+// TryLock places an exclusive advisory lock on f without waiting.
+//
+// Advisory locks are only implemented on Linux and macOS; elsewhere it always
+// fails with errors.ErrUnsupported.
+func (OS) TryLock(File) error {
+	return errors.ErrUnsupported
+}
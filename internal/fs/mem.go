@@ -0,0 +1,726 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Mem is an FS held entirely in memory, for exercising the update pipeline
+// without touching disk.
+//
+// It follows POSIX semantics closely enough for the operations of this
+// package: symlinks are resolved, hard links share content, and renaming a
+// non-empty directory over another fails. Directories marked with Mount
+// behave like separate filesystems: renames and hard links across them fail
+// with EXDEV. SetCapacity limits the space of a filesystem, making writes
+// beyond it fail with ENOSPC. TryLock locks files like flock. Paths are
+// slash-separated and relative paths are resolved from the root. Mem is safe
+// for concurrent use; create it with NewMem.
+type Mem struct {
+	mu       sync.Mutex
+	root     *memNode
+	devices  int
+	tempSeq  int
+	capacity map[int]uint64
+}
+
+// memNode is a file, directory or symlink. Hard links share the same node.
+type memNode struct {
+	mode     os.FileMode
+	data     []byte
+	target   string
+	children map[string]*memNode
+	modTime  time.Time
+	dev      int
+	mount    bool
+	lock     *memFile
+	uid, gid int
+}
+
+// NewMem returns an empty in-memory filesystem holding only its root directory.
+func NewMem() *Mem {
+	return &Mem{root: newMemDir(0755, 0)}
+}
+
+func newMemDir(perm os.FileMode, dev int) *memNode {
+	return &memNode{mode: os.ModeDir | perm.Perm(), children: map[string]*memNode{}, modTime: time.Now(), dev: dev}
+}
+
+func (n *memNode) isDir() bool {
+	return n.mode.IsDir()
+}
+
+func (n *memNode) isSymlink() bool {
+	return n.mode&os.ModeSymlink != 0
+}
+
+// memPath cleans name into an absolute slash-separated path.
+func memPath(name string) string {
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+// lookup resolves name and returns the directory holding its last element,
+// the clean path of that directory, the element's name and its node, which
+// is nil when the element does not exist. Symlinks in the last element are
+// only followed when follow is set.
+func (m *Mem) lookup(name string, follow bool) (dir *memNode, dirPath, base string, node *memNode, err error) {
+	return m.lookupHops(memPath(name), follow, 0)
+}
+
+func (m *Mem) lookupHops(name string, follow bool, hops int) (*memNode, string, string, *memNode, error) {
+	if name == "/" {
+		return nil, "/", "", m.root, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	dir, dirPath := m.root, "/"
+	for i, part := range parts {
+		if !dir.isDir() {
+			return nil, "", "", nil, syscall.ENOTDIR
+		}
+
+		last := i == len(parts)-1
+		child := dir.children[part]
+		if child == nil {
+			if last {
+				return dir, dirPath, part, nil, nil
+			}
+			return nil, "", "", nil, os.ErrNotExist
+		}
+
+		if child.isSymlink() && (!last || follow) {
+			if hops++; hops > maxSymlinkHops {
+				return nil, "", "", nil, syscall.ELOOP
+			}
+			target := child.target
+			if !strings.HasPrefix(target, "/") {
+				target = dirPath + "/" + target
+			}
+			rest := append([]string{target}, parts[i+1:]...)
+			return m.lookupHops(memPath(strings.Join(rest, "/")), follow, hops)
+		}
+
+		if last {
+			return dir, dirPath, part, child, nil
+		}
+		dir, dirPath = child, memPath(dirPath+"/"+part)
+	}
+	return nil, "", "", nil, os.ErrNotExist
+}
+
+// existing resolves name to an existing node.
+func (m *Mem) existing(op, name string, follow bool) (*memNode, error) {
+	_, _, _, node, err := m.lookup(name, follow)
+	if err == nil && node == nil {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return node, nil
+}
+
+func (m *Mem) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (m *Mem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir, _, base, node, err := m.lookup(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	switch {
+	case node == nil && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case node == nil:
+		node = &memNode{mode: perm.Perm(), modTime: time.Now(), dev: dir.dev}
+		dir.children[base] = node
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case node.isDir() && writable:
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	case flag&os.O_TRUNC != 0 && writable:
+		node.data = nil
+		node.modTime = time.Now()
+	}
+
+	return &memFile{mem: m, node: node, name: name, flag: flag}, nil
+}
+
+func (m *Mem) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(filepath.Base(name)), nil
+}
+
+func (m *Mem) Lstat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return node.info(filepath.Base(name)), nil
+}
+
+func (m *Mem) ReadDir(name string) ([]os.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.isDir() {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+
+	entries := make([]os.DirEntry, 0, len(node.children))
+	for childName, child := range node.children {
+		entries = append(entries, iofs.FileInfoToDirEntry(child.info(childName)))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *Mem) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.mkdirAll(memPath(path), perm)
+}
+
+func (m *Mem) mkdirAll(path string, perm os.FileMode) error {
+	dir, _, base, node, err := m.lookup(path, true)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := m.mkdirAll(filepath.ToSlash(filepath.Dir(path)), perm); err != nil {
+			return err
+		}
+		dir, _, base, node, err = m.lookup(path, true)
+	}
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+
+	if node != nil {
+		if !node.isDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	dir.children[base] = newMemDir(perm, dir.dev)
+	return nil
+}
+
+// TempDir returns /tmp, the default directory of MkdirTemp.
+func (m *Mem) TempDir() string {
+	return "/tmp"
+}
+
+// MkdirTemp creates a new directory in dir like os.MkdirTemp. An empty dir
+// stands for /tmp, which is created on first use.
+func (m *Mem) MkdirTemp(dir, pattern string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if dir == "" {
+		dir = m.TempDir()
+		if err := m.mkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	if strings.Contains(pattern, "/") {
+		return "", &os.PathError{Op: "mkdirtemp", Path: pattern, Err: errors.New("pattern contains path separator")}
+	}
+
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+
+	for {
+		m.tempSeq++
+		name := memPath(dir + "/" + prefix + fmt.Sprint(m.tempSeq) + suffix)
+
+		parent, _, base, node, err := m.lookup(name, false)
+		if err == nil && parent == nil {
+			err = os.ErrExist
+		}
+		if err != nil {
+			return "", &os.PathError{Op: "mkdirtemp", Path: name, Err: err}
+		}
+		if node == nil {
+			parent.children[base] = newMemDir(0700, parent.dev)
+			return name, nil
+		}
+	}
+}
+
+func (m *Mem) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+
+	oldDir, oldDirPath, oldBase, node, err := m.lookup(oldpath, false)
+	if err == nil && node == nil {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		return linkErr(err)
+	}
+	newDir, newDirPath, newBase, replaced, err := m.lookup(newpath, false)
+	if err != nil {
+		return linkErr(err)
+	}
+
+	switch {
+	case oldDir == nil || newDir == nil || node.mount:
+		return linkErr(syscall.EBUSY)
+	case replaced == node:
+		return nil
+	case node.dev != newDir.dev:
+		return linkErr(syscall.EXDEV)
+	case node.isDir() && strings.HasPrefix(newDirPath+"/", memPath(oldDirPath+"/"+oldBase)+"/"):
+		return linkErr(syscall.EINVAL)
+	case replaced != nil && replaced.isDir() && !node.isDir():
+		return linkErr(syscall.EISDIR)
+	case replaced != nil && !replaced.isDir() && node.isDir():
+		return linkErr(syscall.ENOTDIR)
+	case replaced != nil && replaced.isDir() && len(replaced.children) > 0:
+		return linkErr(syscall.ENOTEMPTY)
+	case replaced != nil && replaced.mount:
+		return linkErr(syscall.EBUSY)
+	}
+
+	delete(oldDir.children, oldBase)
+	newDir.children[newBase] = node
+	return nil
+}
+
+func (m *Mem) RemoveAll(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir, _, base, node, err := m.lookup(path, false)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && node == nil) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "unlinkat", Path: path, Err: err}
+	}
+
+	if dir == nil {
+		return &os.PathError{Op: "unlinkat", Path: path, Err: syscall.EBUSY}
+	}
+	if node.mount {
+		// A mount point loses its content but stays, like with the real RemoveAll.
+		node.children = map[string]*memNode{}
+		return &os.PathError{Op: "unlinkat", Path: path, Err: syscall.EBUSY}
+	}
+	delete(dir.children, base)
+	return nil
+}
+
+func (m *Mem) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir, _, base, node, err := m.lookup(newname, false)
+	if err == nil && node != nil {
+		err = os.ErrExist
+	}
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+
+	dir.children[base] = &memNode{mode: os.ModeSymlink | 0777, target: filepath.ToSlash(oldname), modTime: time.Now(), dev: dir.dev}
+	return nil
+}
+
+func (m *Mem) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !node.isSymlink() {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return filepath.FromSlash(node.target), nil
+}
+
+func (m *Mem) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+
+	_, _, _, node, err := m.lookup(oldname, false)
+	if err == nil && node == nil {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		return linkErr(err)
+	}
+	dir, _, base, existing, err := m.lookup(newname, false)
+	if err != nil {
+		return linkErr(err)
+	}
+
+	switch {
+	case existing != nil:
+		return linkErr(os.ErrExist)
+	case node.isDir():
+		return linkErr(syscall.EPERM)
+	case node.dev != dir.dev:
+		return linkErr(syscall.EXDEV)
+	}
+	dir.children[base] = node
+	return nil
+}
+
+func (m *Mem) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	node.mode = node.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *Mem) Chown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("chown", name, true)
+	if err != nil {
+		return err
+	}
+	if uid >= 0 {
+		node.uid = uid
+	}
+	if gid >= 0 {
+		node.gid = gid
+	}
+	return nil
+}
+
+// Owner returns the numeric owner and group of the file name, as set by Chown;
+// files are created owned by 0:0.
+func (m *Mem) Owner(name string) (uid, gid int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("stat", name, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	return node.uid, node.gid, nil
+}
+
+// Mount marks the existing directory path as the root of a separate
+// filesystem. Renames and hard links in or out of it fail with EXDEV, and it
+// is reported by IsMountPoint.
+func (m *Mem) Mount(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("mount", path, true)
+	if err != nil {
+		return err
+	}
+	if !node.isDir() {
+		return &os.PathError{Op: "mount", Path: path, Err: syscall.ENOTDIR}
+	}
+
+	m.devices++
+	node.mount = true
+	node.setDevice(m.devices)
+	return nil
+}
+
+func (n *memNode) setDevice(dev int) {
+	n.dev = dev
+	for _, child := range n.children {
+		if !child.mount {
+			child.setDevice(dev)
+		}
+	}
+}
+
+// SetCapacity limits the filesystem holding the existing path to bytes of
+// file content. Filesystems without a capacity are unlimited.
+func (m *Mem) SetCapacity(path string, bytes uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("setcapacity", path, true)
+	if err != nil {
+		return err
+	}
+	if m.capacity == nil {
+		m.capacity = map[int]uint64{}
+	}
+	m.capacity[node.dev] = bytes
+	return nil
+}
+
+// DiskUsage reports the space left on the filesystem holding path, which is
+// math.MaxUint64 when its capacity is not limited.
+func (m *Mem) DiskUsage(path string) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("statfs", path, true)
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{Device: uint64(node.dev), Free: m.free(node.dev)}, nil
+}
+
+// free returns the bytes left on device dev.
+func (m *Mem) free(dev int) uint64 {
+	capacity, limited := m.capacity[dev]
+	if !limited {
+		return math.MaxUint64
+	}
+	if used := m.root.used(dev, map[*memNode]bool{}); used < capacity {
+		return capacity - used
+	}
+	return 0
+}
+
+// used returns the content size of the files of device dev below n, counting
+// hard links once.
+func (n *memNode) used(dev int, seen map[*memNode]bool) uint64 {
+	if seen[n] {
+		return 0
+	}
+	seen[n] = true
+
+	var size uint64
+	if n.dev == dev {
+		size = uint64(len(n.data))
+	}
+	for _, child := range n.children {
+		size += child.used(dev, seen)
+	}
+	return size
+}
+
+// TryLock locks the node of f, like flock, until f is closed.
+func (m *Mem) TryLock(f File) error {
+	file, ok := f.(*memFile)
+	if !ok || file.mem != m {
+		return errors.ErrUnsupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if file.closed {
+		return file.pathError("flock", os.ErrClosed)
+	}
+	if file.node.lock != nil && file.node.lock != file {
+		return ErrLocked
+	}
+	file.node.lock = file
+	return nil
+}
+
+// IsMountPoint reports whether path was marked with Mount.
+func (m *Mem) IsMountPoint(path string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, err := m.existing("stat", path, true)
+	if err != nil {
+		return false, err
+	}
+	return node.mount, nil
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return &memInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+// memInfo describes a Mem node.
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return i.size }
+func (i *memInfo) Mode() os.FileMode  { return i.mode }
+func (i *memInfo) ModTime() time.Time { return i.modTime }
+func (i *memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *memInfo) Sys() any           { return nil }
+
+// memFile is an open Mem node.
+type memFile struct {
+	mem    *Mem
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (f *memFile) pathError(op string, err error) error {
+	return &os.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("read", os.ErrClosed)
+	}
+	if f.node.isDir() {
+		return 0, f.pathError("read", syscall.EISDIR)
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("read", os.ErrClosed)
+	}
+	if f.node.isDir() {
+		return 0, f.pathError("read", syscall.EISDIR)
+	}
+	if off < 0 {
+		return 0, f.pathError("readat", errors.New("negative offset"))
+	}
+	if off >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathError("write", os.ErrClosed)
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, f.pathError("write", syscall.EBADF)
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.node.data))
+	}
+
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		if uint64(end-int64(len(f.node.data))) > f.mem.free(f.node.dev) {
+			return 0, f.pathError("write", syscall.ENOSPC)
+		}
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("close", os.ErrClosed)
+	}
+	f.closed = true
+	if f.node.lock == f {
+		f.node.lock = nil
+	}
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return nil, f.pathError("stat", os.ErrClosed)
+	}
+	return f.node.info(filepath.Base(f.name)), nil
+}
+
+func (f *memFile) Sync() error {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("sync", os.ErrClosed)
+	}
+	return nil
+}
+
+func (f *memFile) Chmod(mode os.FileMode) error {
+	f.mem.mu.Lock()
+	defer f.mem.mu.Unlock()
+
+	if f.closed {
+		return f.pathError("chmod", os.ErrClosed)
+	}
+	f.node.mode = f.node.mode.Type() | mode.Perm()
+	return nil
+}
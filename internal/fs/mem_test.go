@@ -0,0 +1,230 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMem_Files(t *testing.T) {
+	fsys := NewMem()
+	if err := fsys.MkdirAll("/srv/pma/libs", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := WriteFile(fsys, "/srv/pma/index.php", []byte("<?php"), 0640); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	data, err := ReadFile(fsys, "/srv/pma/index.php")
+	if err != nil || string(data) != "<?php" {
+		t.Errorf("unexpected content %q (%v)", data, err)
+	}
+	info, err := fsys.Stat("/srv/pma/index.php")
+	if err != nil || info.Mode() != 0640 || info.Size() != 5 {
+		t.Errorf("unexpected info %v (%v)", info, err)
+	}
+
+	if _, err := fsys.OpenFile("/srv/pma/index.php", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !errors.Is(err, os.ErrExist) {
+		t.Errorf("expected exclusive create to fail, got %v", err)
+	}
+	if _, err := fsys.Open("/srv/pma/missing.php"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+	if err := fsys.MkdirAll("/srv/pma/index.php/sub", 0755); err == nil {
+		t.Errorf("expected MkdirAll below a file to fail")
+	}
+
+	entries, err := fsys.ReadDir("/srv/pma")
+	if err != nil || len(entries) != 2 || entries[0].Name() != "index.php" || !entries[1].IsDir() {
+		t.Errorf("unexpected entries %v (%v)", entries, err)
+	}
+}
+
+func TestMem_Chown(t *testing.T) {
+	fsys := NewMem()
+	if err := fsys.MkdirAll("/srv/pma/tmp", 0750); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := Chown(fsys, "/srv/pma/tmp", 33, 33); err != nil {
+		t.Fatalf("Chown failed: %v", err)
+	}
+	if err := Chown(fsys, "/srv/pma/tmp", -1, 34); err != nil {
+		t.Fatalf("Chown failed: %v", err)
+	}
+	if uid, gid, err := fsys.Owner("/srv/pma/tmp"); err != nil || uid != 33 || gid != 34 {
+		t.Errorf("unexpected owner %d:%d (%v)", uid, gid, err)
+	}
+	if err := Chown(fsys, "/srv/pma/missing", 0, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected missing file error, got %v", err)
+	}
+	if err := Chown(struct{ FS }{fsys}, "/srv/pma/tmp", 0, 0); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+}
+
+func TestMem_SymlinksAndLinks(t *testing.T) {
+	fsys := NewMem()
+	if err := fsys.MkdirAll("/srv/releases/1", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := fsys.Symlink("releases/1", "/srv/current"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if err := WriteFile(fsys, "/srv/current/index.php", []byte("v1"), 0644); err != nil {
+		t.Fatalf("WriteFile through symlink failed: %v", err)
+	}
+	if data, err := ReadFile(fsys, "/srv/releases/1/index.php"); err != nil || string(data) != "v1" {
+		t.Errorf("expected write through symlink, got %q (%v)", data, err)
+	}
+
+	info, err := fsys.Lstat("/srv/current")
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected Lstat to report the symlink, got %v (%v)", info, err)
+	}
+	if target, err := fsys.Readlink("/srv/current"); err != nil || target != "releases/1" {
+		t.Errorf("unexpected link target %q (%v)", target, err)
+	}
+
+	if err := fsys.Link("/srv/releases/1/index.php", "/srv/index.php"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := WriteFile(fsys, "/srv/index.php", []byte("v2"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if data, _ := ReadFile(fsys, "/srv/releases/1/index.php"); string(data) != "v2" {
+		t.Errorf("expected hard links to share content, got %q", data)
+	}
+}
+
+func TestMem_Rename(t *testing.T) {
+	fsys := NewMem()
+	for _, dir := range []string{"/a/sub", "/b/sub", "/empty"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+	}
+
+	cases := []struct {
+		oldPath, newPath string
+		expected         error
+	}{
+		{"/a", "/b", syscall.ENOTEMPTY},
+		{"/a", "/a/sub/inside", syscall.EINVAL},
+		{"/missing", "/c", os.ErrNotExist},
+		{"/a", "/missing/c", os.ErrNotExist},
+	}
+	for _, c := range cases {
+		if err := fsys.Rename(c.oldPath, c.newPath); !errors.Is(err, c.expected) {
+			t.Errorf("Rename(%s, %s): expected %v, got %v", c.oldPath, c.newPath, c.expected, err)
+		}
+	}
+
+	if err := fsys.Rename("/a", "/empty"); err != nil {
+		t.Fatalf("Rename over empty directory failed: %v", err)
+	}
+	if _, err := fsys.Stat("/empty/sub"); err != nil {
+		t.Errorf("expected renamed tree, got %v", err)
+	}
+	if _, err := fsys.Stat("/a"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected source to be gone, got %v", err)
+	}
+}
+
+func TestMem_Mount(t *testing.T) {
+	fsys := NewMem()
+	for _, dir := range []string{"/srv/pma", "/mnt/data"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+	}
+	if err := WriteFile(fsys, "/srv/pma/index.php", []byte("<?php"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fsys.Mount("/mnt/data"); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+
+	if mounted, err := IsMountPoint(fsys, "/mnt/data"); err != nil || !mounted {
+		t.Errorf("expected mount point, got %v (%v)", mounted, err)
+	}
+	if mounted, err := IsMountPoint(fsys, "/srv/pma"); err != nil || mounted {
+		t.Errorf("expected plain directory, got %v (%v)", mounted, err)
+	}
+
+	if err := fsys.Rename("/srv/pma", "/mnt/data/pma"); !errors.Is(err, syscall.EXDEV) {
+		t.Errorf("expected EXDEV across mounts, got %v", err)
+	}
+	if err := fsys.Rename("/mnt/data", "/mnt/moved"); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected mount point rename to fail, got %v", err)
+	}
+
+	// MoveDir falls back to a staged copy across mounts.
+	if err := MoveDir(context.Background(), fsys, "/srv/pma", "/mnt/data/pma", CopyOptions{}); err != nil {
+		t.Fatalf("MoveDir failed: %v", err)
+	}
+	if data, err := ReadFile(fsys, "/mnt/data/pma/index.php"); err != nil || string(data) != "<?php" {
+		t.Errorf("unexpected moved content %q (%v)", data, err)
+	}
+	if entries, _ := fsys.ReadDir("/srv"); len(entries) != 0 {
+		t.Errorf("expected source and trash to be removed, found %v", entries)
+	}
+}
+
+func TestFaultFS(t *testing.T) {
+	var ops []string
+	fsys := &FaultFS{FS: NewMem(), Fail: func(op string, paths ...string) error {
+		ops = append(ops, op)
+		if op == "Sync" {
+			return errors.New("sync failed")
+		}
+		return nil
+	}}
+
+	if err := WriteFile(fsys, "/file", []byte("data"), 0644); err == nil {
+		t.Errorf("expected injected sync failure")
+	}
+	if len(ops) != 3 || ops[0] != "OpenFile" || ops[1] != "Write" || ops[2] != "Sync" {
+		t.Errorf("unexpected operations %v", ops)
+	}
+	if mounted, err := IsMountPoint(fsys, "/"); err != nil || mounted {
+		t.Errorf("expected mount detection to be forwarded, got %v (%v)", mounted, err)
+	}
+}
+
+func TestMem_Capacity(t *testing.T) {
+	fsys := NewMem()
+	if err := fsys.MkdirAll("/mnt/data", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := fsys.Mount("/mnt/data"); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+	if err := fsys.SetCapacity("/mnt/data", 10); err != nil {
+		t.Fatalf("SetCapacity failed: %v", err)
+	}
+
+	if err := WriteFile(fsys, "/mnt/data/a", []byte("123456"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fsys.Link("/mnt/data/a", "/mnt/data/b"); err != nil {
+		t.Fatalf("Link failed: %v", err)
+	}
+	if err := WriteFile(fsys, "/mnt/data/c", []byte("12345"), 0644); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("expected ENOSPC, got %v", err)
+	}
+
+	usage, err := DiskUsage(fsys, "/mnt/data/missing/dir")
+	if err != nil || usage.Free != 4 {
+		t.Errorf("expected 4 bytes free counting hard links once, got %+v (%v)", usage, err)
+	}
+	root, err := DiskUsage(fsys, "/")
+	if err != nil || root.Device == usage.Device || root.Free != math.MaxUint64 {
+		t.Errorf("expected an unlimited separate root filesystem, got %+v (%v)", root, err)
+	}
+	if err := CheckSpace(fsys, "/mnt/data", 5); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("expected not enough space, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// CopyMetadata carries ownership and extended attributes of path over to targetPath.
+//
+// Ownership is only copied when running as root, since other users cannot give
+// files away. Extended attributes include SELinux labels (security.selinux) and
+// POSIX ACLs (system.posix_acl_access, system.posix_acl_default); they are
+// skipped for symlinks and where the target filesystem does not support them.
+func (OS) CopyMetadata(path, targetPath string, info os.FileInfo) error {
+	// Ownership goes first: chown clears attributes such as security.capability.
+	if os.Geteuid() == 0 {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(targetPath, int(stat.Uid), int(stat.Gid)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return copyXattrs(path, targetPath)
+}
+
+// copyXattrs copies every extended attribute of path to targetPath.
+func copyXattrs(path, targetPath string) error {
+	names, err := listXattrs(path)
+	if err != nil {
+		if xattrUnsupported(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getXattr(path, name)
+		if err != nil {
+			if xattrUnsupported(err) {
+				continue
+			}
+			return err
+		}
+		if err := syscall.Setxattr(targetPath, name, value, 0); err != nil && !xattrUnsupported(err) {
+			return &os.PathError{Op: "setxattr " + name, Path: targetPath, Err: err}
+		}
+	}
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
+
+// xattrUnsupported reports whether err means the attribute cannot be handled
+// here: the filesystem lacks xattr support, the attribute vanished, or an
+// unprivileged process is not allowed to set it.
+func xattrUnsupported(err error) bool {
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENODATA) {
+		return true
+	}
+	return os.Geteuid() != 0 && (errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES))
+}
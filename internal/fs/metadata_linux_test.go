@@ -0,0 +1,60 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCopyDir_Ownership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("ownership is only preserved when running as root")
+	}
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	dest := filepath.Join(tempDir, "dest")
+	writeTree(t, source, map[string]string{"config.inc.php": "<?php"})
+	if err := os.Lchown(filepath.Join(source, "config.inc.php"), 1234, 5678); err != nil {
+		t.Fatalf("failed to chown: %v", err)
+	}
+
+	if err := CopyDir(context.Background(), OS{}, source, dest, CopyOptions{}); err != nil {
+		t.Fatalf("CopyDir failed: %v", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(dest, "config.inc.php"))
+	if err != nil {
+		t.Fatalf("failed to stat copy: %v", err)
+	}
+	stat := info.Sys().(*syscall.Stat_t)
+	if stat.Uid != 1234 || stat.Gid != 5678 {
+		t.Errorf("expected ownership 1234:5678, got %d:%d", stat.Uid, stat.Gid)
+	}
+}
+
+func TestCopyDir_Xattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	source := filepath.Join(tempDir, "source")
+	dest := filepath.Join(tempDir, "dest")
+	writeTree(t, source, map[string]string{"index.php": "<?php"})
+
+	err := syscall.Setxattr(filepath.Join(source, "index.php"), "user.pma-up.test", []byte("label"), 0)
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("filesystem does not support user extended attributes")
+	}
+	if err != nil {
+		t.Fatalf("failed to set xattr: %v", err)
+	}
+
+	if err := CopyDir(context.Background(), OS{}, source, dest, CopyOptions{}); err != nil {
+		t.Fatalf("CopyDir failed: %v", err)
+	}
+
+	value, err := getXattr(filepath.Join(dest, "index.php"), "user.pma-up.test")
+	if err != nil || string(value) != "label" {
+		t.Errorf("expected xattr to be copied, got %q (%v)", value, err)
+	}
+}
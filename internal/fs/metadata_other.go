@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fs
+
+import "os"
+
+// CopyMetadata carries ownership and extended attributes of path over to targetPath.
+//
+// Only Linux is supported; elsewhere it is a no-op.
+func (OS) CopyMetadata(string, string, os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,88 @@
+package fs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// mountInfoPath lists the mount points visible to the current process.
+const mountInfoPath = "/proc/self/mountinfo"
+
+// IsMountPoint reports whether path is the root of a mounted filesystem,
+// including bind mounts of directories living on the same device.
+func (OS) IsMountPoint(path string) (bool, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, wrap("failed to resolve path", err)
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return false, wrap("failed to resolve absolute path", err)
+	}
+
+	if mounted, err := listedInMountInfo(resolved); err == nil {
+		return mounted, nil
+	}
+
+	// Fall back to comparing devices when mountinfo is unavailable.
+	var self, parent syscall.Stat_t
+	if err := syscall.Stat(resolved, &self); err != nil {
+		return false, wrap("failed to stat path", err)
+	}
+	if err := syscall.Stat(filepath.Dir(resolved), &parent); err != nil {
+		return false, wrap("failed to stat parent", err)
+	}
+	return self.Dev != parent.Dev || self.Ino == parent.Ino, nil
+}
+
+func listedInMountInfo(path string) (bool, error) {
+	file, err := os.Open(mountInfoPath)
+	if err != nil {
+		return false, err
+	}
+	defer safeClose("mountinfo", file)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if unescapeMountPath(fields[4]) == path {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (\040 for space, etc.) used in mountinfo.
+func unescapeMountPath(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			code := 0
+			valid := true
+			for _, digit := range field[i+1 : i+4] {
+				if digit < '0' || digit > '7' {
+					valid = false
+					break
+				}
+				code = code*8 + int(digit-'0')
+			}
+			if valid {
+				builder.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		builder.WriteByte(field[i])
+	}
+	return builder.String()
+}
@@ -0,0 +1,17 @@
+package fs
+
+import "testing"
+
+func TestUnescapeMountPath(t *testing.T) {
+	cases := map[string]string{
+		"/var/www/phpmyadmin":      "/var/www/phpmyadmin",
+		`/mnt/with\040space`:       "/mnt/with space",
+		`/mnt/tab\011and\134slash`: "/mnt/tab\tand\\slash",
+		`/mnt/not\09escape`:        `/mnt/not\09escape`,
+	}
+	for input, expected := range cases {
+		if got := unescapeMountPath(input); got != expected {
+			t.Errorf("unescapeMountPath(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fs
+
+// IsMountPoint reports whether path is the root of a mounted filesystem.
+//
+// Mount point detection is only implemented on Linux; elsewhere it always
+// reports false.
+func (OS) IsMountPoint(string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,97 @@
+package fs
+
+import "os"
+
+// OS is the FS backed by the host filesystem.
+type OS struct {
+	// Durable makes Sync calls on files and directories flush them to stable
+	// storage. Without it they are no-ops, trading the guarantee of surviving
+	// a power loss right after an update for speed.
+	Durable bool
+}
+
+// osFile is an open *os.File whose Sync honors OS.Durable.
+type osFile struct {
+	*os.File
+	durable bool
+}
+
+func (f *osFile) Sync() error {
+	if !f.durable {
+		return nil
+	}
+	return f.File.Sync()
+}
+
+func (o OS) wrapFile(file *os.File, err error) (File, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &osFile{File: file, durable: o.Durable}, nil
+}
+
+func (o OS) Open(name string) (File, error) {
+	return o.wrapFile(os.Open(name))
+}
+
+func (o OS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return o.wrapFile(os.OpenFile(name, flag, perm))
+}
+
+func (OS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (OS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (OS) MkdirTemp(dir, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
+
+func (OS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (OS) Symlink(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (OS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (OS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OS) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (OS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// osFiles unwraps two files opened by OS.
+func osFiles(dst, src File) (*os.File, *os.File, bool) {
+	dstFile, dstOK := dst.(*osFile)
+	srcFile, srcOK := src.(*osFile)
+	if !dstOK || !srcOK {
+		return nil, nil, false
+	}
+	return dstFile.File, srcFile.File, true
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fs
+
+import "errors"
+
+// DiskUsage reports the free space of the filesystem holding path.
+//
+// Free space is only reported on Linux and macOS; elsewhere it always fails
+// with errors.ErrUnsupported.
+func (OS) DiskUsage(string) (Usage, error) {
+	return Usage{}, errors.ErrUnsupported
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package fs
+
+import "syscall"
+
+// DiskUsage reports the free space of the filesystem holding the existing path.
+func (OS) DiskUsage(path string) (Usage, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return Usage{}, wrap("failed to stat "+path, err)
+	}
+	var sfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &sfs); err != nil {
+		return Usage{}, wrap("failed to statfs "+path, err)
+	}
+	return Usage{Device: uint64(st.Dev), Free: uint64(sfs.Bavail) * uint64(sfs.Bsize)}, nil
+}
@@ -0,0 +1,247 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinkHops bounds symlink resolution, like the kernel's ELOOP limit.
+const maxSymlinkHops = 40
+
+// ReadFile reads the whole content of the file name.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer safeClose("file", file)
+
+	return io.ReadAll(file)
+}
+
+// WriteFile writes data to the file name, creating it with perm if needed and
+// truncating it otherwise. The content is synced before the file is closed.
+func WriteFile(fsys FS, name string, data []byte, perm os.FileMode) error {
+	file, err := fsys.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		safeClose("file", file)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		safeClose("file", file)
+		return err
+	}
+	return file.Close()
+}
+
+// Walk walks the tree rooted at root like filepath.Walk, calling fn for every
+// entry in lexical order. Symlinks are reported, not followed.
+//
+// Unlike filepath.Walk, fn is called once for a directory that cannot be read,
+// with the read error.
+func Walk(fsys FS, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fsys, root, info, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walk(fsys FS, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	entries, readErr := fsys.ReadDir(path)
+	if err := fn(path, info, readErr); err != nil || readErr != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		childPath := filepath.Join(path, entry.Name())
+		childInfo, err := fsys.Lstat(childPath)
+		if err != nil {
+			if err := fn(childPath, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		if err := walk(fsys, childPath, childInfo, fn); err != nil {
+			if !childInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EvalSymlinks returns the absolute path name after resolving every symlink
+// along path, like filepath.EvalSymlinks.
+func EvalSymlinks(fsys FS, path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	hops := 0
+	resolved := filepath.VolumeName(absPath) + string(os.PathSeparator)
+	pending := strings.Split(strings.TrimPrefix(absPath, resolved), string(os.PathSeparator))
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if name == "" {
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		info, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return "", &os.PathError{Op: "evalsymlinks", Path: path, Err: errors.New("too many levels of symbolic links")}
+		}
+		target, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = filepath.VolumeName(target) + string(os.PathSeparator)
+			target = strings.TrimPrefix(target, resolved)
+		}
+		pending = append(strings.Split(filepath.Clean(target), string(os.PathSeparator)), pending...)
+	}
+	return resolved, nil
+}
+
+// IsMountPoint reports whether path is the root of a mounted filesystem of
+// fsys. It always reports false when fsys cannot detect mount points.
+func IsMountPoint(fsys FS, path string) (bool, error) {
+	if m, ok := fsys.(mountChecker); ok {
+		return m.IsMountPoint(path)
+	}
+	return false, nil
+}
+
+// TempDir returns the directory MkdirTemp of fsys creates temporary
+// directories in when given no directory: os.TempDir() unless fsys names
+// its own.
+func TempDir(fsys FS) string {
+	if t, ok := fsys.(tempDirer); ok {
+		return t.TempDir()
+	}
+	return os.TempDir()
+}
+
+// ErrLocked reports that another open file holds the lock TryLock asked for.
+var ErrLocked = errors.New("file is locked")
+
+// TryLock places an exclusive advisory lock on the open file f without
+// waiting. The lock is released when f is closed. It fails with ErrLocked when
+// another open file holds the lock, and with errors.ErrUnsupported when fsys
+// cannot lock files.
+func TryLock(fsys FS, f File) error {
+	if l, ok := fsys.(locker); ok {
+		return l.TryLock(f)
+	}
+	return errors.ErrUnsupported
+}
+
+// Chown changes the numeric owner and group of the file name, -1 keeping
+// either. It fails with errors.ErrUnsupported when fsys cannot change owners.
+func Chown(fsys FS, name string, uid, gid int) error {
+	if c, ok := fsys.(chowner); ok {
+		return c.Chown(name, uid, gid)
+	}
+	return errors.ErrUnsupported
+}
+
+// ErrNoSpace reports that a filesystem lacks the free space an operation needs.
+var ErrNoSpace = errors.New("not enough disk space")
+
+// CheckSpace fails with ErrNoSpace when the filesystem holding path has less
+// than need bytes free. It passes when fsys cannot report free space.
+func CheckSpace(fsys FS, path string, need uint64) error {
+	usage, err := DiskUsage(fsys, path)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return wrap("failed to check free space", err)
+	}
+	if usage.Free < need {
+		return fmt.Errorf("%w on the filesystem of %s: %s needed, %s available",
+			ErrNoSpace, path, HumanSize(need), HumanSize(usage.Free))
+	}
+	return nil
+}
+
+// HumanSize formats a number of bytes with binary units, e.g. "12.5 MiB".
+func HumanSize(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// DiskUsage reports the free space of the filesystem holding path, or its
+// nearest existing ancestor when path does not exist yet. It fails with
+// errors.ErrUnsupported when fsys cannot report free space.
+func DiskUsage(fsys FS, path string) (Usage, error) {
+	u, ok := fsys.(usageReporter)
+	if !ok {
+		return Usage{}, errors.ErrUnsupported
+	}
+
+	for {
+		_, err := fsys.Stat(path)
+		if err == nil {
+			return u.DiskUsage(path)
+		}
+		parent := filepath.Dir(path)
+		if !errors.Is(err, os.ErrNotExist) || parent == path {
+			return Usage{}, err
+		}
+		path = parent
+	}
+}
+
+// DirSize returns the total size of the regular files in the tree rooted at root.
+func DirSize(fsys FS, root string) (uint64, error) {
+	var size uint64
+	err := Walk(fsys, root, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
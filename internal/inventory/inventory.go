@@ -0,0 +1,512 @@
+// Package inventory renders phpMyAdmin configuration files from a declarative
+// description of the servers, control user, storage database and interface
+// defaults of an installation.
+//
+// An inventory is a TOML file:
+//
+//	blowfish_secret_file = "/run/secrets/pma-blowfish"
+//
+//	[control]
+//	user = "pma"
+//	password_env = "PMA_CONTROL_PASSWORD"
+//
+//	[storage]
+//	database = "phpmyadmin"
+//
+//	[settings]
+//	DefaultLang = "en"
+//	Export.format = "csv"
+//
+//	[[servers]]
+//	verbose = "Primary"
+//	host = "db1.example.com"
+//	auth_type = "cookie"
+//
+//	[servers.settings]
+//	hide_db = "^(information|performance)_schema$"
+//
+// Every secret (blowfish_secret, passwords) is given either inline, or read
+// from the environment variable named by its _env key, or from the file named
+// by its _file key.
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/phpconfig"
+)
+
+// AuthTypes are the authentication types a server may use.
+var AuthTypes = []string{"cookie", "http", "config", "signon"}
+
+// storageTables maps the phpMyAdmin configuration storage directives to their
+// table names, without the pma__ prefix.
+var storageTables = [][2]string{
+	{"bookmarktable", "bookmark"},
+	{"relation", "relation"},
+	{"table_info", "table_info"},
+	{"table_coords", "table_coords"},
+	{"pdf_pages", "pdf_pages"},
+	{"column_info", "column_info"},
+	{"history", "history"},
+	{"table_uiprefs", "table_uiprefs"},
+	{"tracking", "tracking"},
+	{"userconfig", "userconfig"},
+	{"recent", "recent"},
+	{"favorite", "favorite"},
+	{"users", "users"},
+	{"usergroups", "usergroups"},
+	{"navigationhiding", "navigationhiding"},
+	{"savedsearches", "savedsearches"},
+	{"central_columns", "central_columns"},
+	{"designer_settings", "designer_settings"},
+	{"export_templates", "export_templates"},
+}
+
+// Secret is a value kept out of the inventory.
+type Secret struct {
+	Value string // Inline value
+	Env   string // Environment variable holding the value
+	File  string // File holding the value, without trailing newlines
+}
+
+// IsSet reports whether the secret is given.
+func (s Secret) IsSet() bool {
+	return s.Value != "" || s.Env != "" || s.File != ""
+}
+
+// Resolve returns the value of the secret.
+func (s Secret) Resolve(fsys fs.FS) (string, error) {
+	switch {
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return value, nil
+	case s.File != "":
+		data, err := fs.ReadFile(fsys, s.File)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return s.Value, nil
+}
+
+// Control is the control user phpMyAdmin uses for its advanced features.
+type Control struct {
+	User     string
+	Password Secret
+	Host     string // Server holding the storage database, the server itself when empty
+	Port     int
+}
+
+// Storage is the phpMyAdmin configuration storage.
+type Storage struct {
+	Database string // Database holding the storage tables, storage disabled when empty
+	Prefix   string // Prefix of the table names, "pma__" when empty
+}
+
+// Server is one database server offered by phpMyAdmin.
+type Server struct {
+	Verbose  string // Name shown instead of the host
+	Host     string
+	Port     int
+	Socket   string
+	SSL      bool
+	AuthType string // One of AuthTypes, "cookie" when empty
+	User     string // User for the config authentication type
+	Password Secret // Password for the config authentication type
+	Control  *Control
+	Settings map[string]any // Other $cfg['Servers'][$i] directives
+}
+
+// Inventory describes the configuration of a phpMyAdmin installation.
+type Inventory struct {
+	BlowfishSecret Secret
+	Control        *Control // Control user of every server that sets none
+	Storage        Storage
+	Servers        []Server
+	Settings       map[string]any // Other $cfg directives
+}
+
+// Load reads and validates the inventory at path. Secrets are not resolved.
+func Load(fsys fs.FS, path string) (*Inventory, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return inv, nil
+}
+
+// Parse decodes and validates an inventory.
+func Parse(src string) (*Inventory, error) {
+	doc, err := decodeTOML(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var tableErr error
+	root := &table{values: doc, err: &tableErr}
+	inv := &Inventory{
+		BlowfishSecret: root.secret("blowfish_secret"),
+		Control:        root.control("control"),
+		Settings:       root.settings("settings"),
+	}
+	if storage := root.table("storage"); storage != nil {
+		inv.Storage = Storage{Database: storage.string("database"), Prefix: storage.string("prefix")}
+		storage.done()
+	}
+	for _, server := range root.tables("servers") {
+		inv.Servers = append(inv.Servers, Server{
+			Verbose:  server.string("verbose"),
+			Host:     server.string("host"),
+			Port:     server.int("port"),
+			Socket:   server.string("socket"),
+			SSL:      server.bool("ssl"),
+			AuthType: server.string("auth_type"),
+			User:     server.string("user"),
+			Password: server.secret("password"),
+			Control:  server.control("control"),
+			Settings: server.settings("settings"),
+		})
+		server.done()
+	}
+	root.done()
+	if tableErr != nil {
+		return nil, tableErr
+	}
+
+	if !inv.BlowfishSecret.IsSet() {
+		return nil, errors.New("blowfish_secret is required")
+	}
+	if len(inv.Servers) == 0 {
+		return nil, errors.New("at least one server is required")
+	}
+	for i, server := range inv.Servers {
+		if server.Host == "" && server.Socket == "" {
+			return nil, fmt.Errorf("servers[%d]: host or socket is required", i)
+		}
+		if server.AuthType != "" && !slices.Contains(AuthTypes, server.AuthType) {
+			return nil, fmt.Errorf("servers[%d]: unknown auth_type %q, expected one of %s", i, server.AuthType, strings.Join(AuthTypes, ", "))
+		}
+		if server.AuthType == "config" && server.User == "" {
+			return nil, fmt.Errorf("servers[%d]: auth_type config requires user", i)
+		}
+	}
+	return inv, nil
+}
+
+// Render returns the config.inc.php described by the inventory, resolving its
+// secrets. source names the inventory in the header comment.
+func (inv *Inventory) Render(fsys fs.FS, source string) ([]byte, error) {
+	w := &phpWriter{fsys: fsys}
+	w.printf("<?php\n")
+	w.printf("/**\n * phpMyAdmin configuration generated by pma-up from %s.\n", strings.ReplaceAll(source, "*/", "* /"))
+	w.printf(" * Changes made here are lost on the next update: edit the inventory instead.\n */\n\n")
+	w.printf("declare(strict_types=1);\n\n")
+	w.secret(phpconfig.Key{"blowfish_secret"}, inv.BlowfishSecret)
+
+	w.printf("\n$i = 0;\n")
+	for _, server := range inv.Servers {
+		w.printf("\n$i++;\n")
+		key := func(name string) phpconfig.Key {
+			return phpconfig.Key{"Servers", phpconfig.AnyIndex, name}
+		}
+		if server.Verbose != "" {
+			w.assign(key("verbose"), server.Verbose)
+		}
+		if server.Host != "" {
+			w.assign(key("host"), server.Host)
+		}
+		if server.Port != 0 {
+			w.assign(key("port"), strconv.Itoa(server.Port))
+		}
+		if server.Socket != "" {
+			w.assign(key("socket"), server.Socket)
+		}
+		if server.SSL {
+			w.assign(key("ssl"), true)
+		}
+		authType := server.AuthType
+		if authType == "" {
+			authType = "cookie"
+		}
+		w.assign(key("auth_type"), authType)
+		if server.User != "" {
+			w.assign(key("user"), server.User)
+		}
+		if server.Password.IsSet() {
+			w.secret(key("password"), server.Password)
+		}
+
+		control := server.Control
+		if control == nil {
+			control = inv.Control
+		}
+		if control != nil {
+			if control.Host != "" {
+				w.assign(key("controlhost"), control.Host)
+			}
+			if control.Port != 0 {
+				w.assign(key("controlport"), strconv.Itoa(control.Port))
+			}
+			w.assign(key("controluser"), control.User)
+			w.secret(key("controlpass"), control.Password)
+		}
+		if inv.Storage.Database != "" {
+			prefix := inv.Storage.Prefix
+			if prefix == "" {
+				prefix = "pma__"
+			}
+			w.assign(key("pmadb"), inv.Storage.Database)
+			for _, storageTable := range storageTables {
+				w.assign(key(storageTable[0]), prefix+storageTable[1])
+			}
+		}
+		w.settings(phpconfig.Key{"Servers", phpconfig.AnyIndex}, server.Settings)
+	}
+
+	if len(inv.Settings) > 0 {
+		w.printf("\n")
+		w.settings(nil, inv.Settings)
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	return []byte(w.b.String()), nil
+}
+
+// phpWriter writes PHP assignments, keeping the first error.
+type phpWriter struct {
+	fsys fs.FS
+	b    strings.Builder
+	err  error
+}
+
+func (w *phpWriter) printf(format string, args ...any) {
+	fmt.Fprintf(&w.b, format, args...)
+}
+
+func (w *phpWriter) assign(key phpconfig.Key, value any) {
+	literal, err := phpLiteral(value)
+	if err != nil && w.err == nil {
+		w.err = fmt.Errorf("%s: %w", key, err)
+	}
+	w.printf("%s = %s;\n", key, literal)
+}
+
+func (w *phpWriter) secret(key phpconfig.Key, secret Secret) {
+	value, err := secret.Resolve(w.fsys)
+	if err != nil && w.err == nil {
+		w.err = fmt.Errorf("%s: %w", key, err)
+	}
+	w.assign(key, value)
+}
+
+// settings assigns each setting below prefix, nested tables giving nested
+// keys, in key order.
+func (w *phpWriter) settings(prefix phpconfig.Key, settings map[string]any) {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		key := append(slices.Clone(prefix), name)
+		if nested, ok := settings[name].(map[string]any); ok {
+			w.settings(key, nested)
+			continue
+		}
+		w.assign(key, settings[name])
+	}
+}
+
+// phpLiteral formats a decoded TOML value as PHP.
+func phpLiteral(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return phpconfig.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", fmt.Errorf("unsupported number %v", v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []any:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			literal, err := phpLiteral(elem)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = literal
+		}
+		return "[" + strings.Join(elems, ", ") + "]", nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// table reads the keys of a decoded TOML table, recording the first error in
+// err, which is shared with its child tables. Keys that are read are removed,
+// so that done can report unknown ones.
+type table struct {
+	path   string
+	values map[string]any
+	err    *error
+}
+
+func (t *table) fail(key, format string, args ...any) {
+	if *t.err == nil {
+		*t.err = fmt.Errorf("%s: %s", t.name(key), fmt.Sprintf(format, args...))
+	}
+}
+
+func (t *table) name(key string) string {
+	if t.path == "" {
+		return key
+	}
+	return t.path + "." + key
+}
+
+func (t *table) take(key string) (any, bool) {
+	value, ok := t.values[key]
+	delete(t.values, key)
+	return value, ok
+}
+
+func (t *table) child(key string, values map[string]any) *table {
+	return &table{path: t.name(key), values: values, err: t.err}
+}
+
+func (t *table) string(key string) string {
+	value, ok := t.take(key)
+	if !ok {
+		return ""
+	}
+	s, ok := value.(string)
+	if !ok {
+		t.fail(key, "expected a string")
+	}
+	return s
+}
+
+func (t *table) int(key string) int {
+	value, ok := t.take(key)
+	if !ok {
+		return 0
+	}
+	n, ok := value.(int64)
+	if !ok || n < 0 || n > math.MaxInt32 {
+		t.fail(key, "expected a positive integer")
+	}
+	return int(n)
+}
+
+func (t *table) bool(key string) bool {
+	value, ok := t.take(key)
+	if !ok {
+		return false
+	}
+	b, ok := value.(bool)
+	if !ok {
+		t.fail(key, "expected true or false")
+	}
+	return b
+}
+
+// secret reads the secret given by key, key_env or key_file.
+func (t *table) secret(key string) Secret {
+	s := Secret{Value: t.string(key), Env: t.string(key + "_env"), File: t.string(key + "_file")}
+	set := 0
+	for _, value := range []string{s.Value, s.Env, s.File} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		t.fail(key, "set only one of %s, %s_env and %s_file", key, key, key)
+	}
+	return s
+}
+
+func (t *table) table(key string) *table {
+	value, ok := t.take(key)
+	if !ok {
+		return nil
+	}
+	values, ok := value.(map[string]any)
+	if !ok {
+		t.fail(key, "expected a table")
+		return nil
+	}
+	return t.child(key, values)
+}
+
+func (t *table) tables(key string) []*table {
+	value, ok := t.take(key)
+	if !ok {
+		return nil
+	}
+	list, ok := value.([]map[string]any)
+	if !ok {
+		t.fail(key, "expected an array of tables ([[%s]])", key)
+		return nil
+	}
+	tables := make([]*table, len(list))
+	for i, values := range list {
+		tables[i] = t.child(fmt.Sprintf("%s[%d]", key, i), values)
+	}
+	return tables
+}
+
+func (t *table) control(key string) *Control {
+	control := t.table(key)
+	if control == nil {
+		return nil
+	}
+	c := &Control{
+		User:     control.string("user"),
+		Password: control.secret("password"),
+		Host:     control.string("host"),
+		Port:     control.int("port"),
+	}
+	if c.User == "" {
+		control.fail("user", "required")
+	}
+	control.done()
+	return c
+}
+
+func (t *table) settings(key string) map[string]any {
+	settings := t.table(key)
+	if settings == nil {
+		return nil
+	}
+	values := settings.values
+	settings.values = nil
+	return values
+}
+
+// done reports the first key of the table that was not read.
+func (t *table) done() {
+	if len(t.values) > 0 {
+		t.fail(slices.Min(slices.Collect(maps.Keys(t.values))), "unknown key")
+	}
+}
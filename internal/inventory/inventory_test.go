@@ -0,0 +1,227 @@
+package inventory
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/phpconfig"
+)
+
+const exampleInventory = `# Production servers
+blowfish_secret_file = "/run/secrets/pma-blowfish"
+
+[control]
+user = "pma"
+password_env = "PMA_CONTROL_PASSWORD"
+
+[storage]
+database = "phpmyadmin"
+
+[settings]
+DefaultLang = "en"
+MaxNavigationItems = 100
+ShowPhpInfo = false
+Export.format = "csv"
+"Import.allow_interrupt" = true
+AvailableCharsets = [
+  "utf-8", # the default
+  "iso-8859-1",
+]
+
+[[servers]]
+verbose = "Primary \"db1\""
+host = "db1.example.com"
+port = 3_307
+
+[servers.settings]
+hide_db = '^(information|performance)_schema$'
+
+[[servers]]
+socket = "/run/mysqld/mysqld.sock"
+auth_type = "config"
+user = "root"
+password = "it's secret"
+
+[servers.control]
+user = "local_pma"
+password = ""
+`
+
+func TestDecodeTOML(t *testing.T) {
+	doc, err := decodeTOML(exampleInventory)
+	if err != nil {
+		t.Fatalf("decodeTOML failed: %v", err)
+	}
+	settings := doc["settings"].(map[string]any)
+	if settings["MaxNavigationItems"] != int64(100) || settings["ShowPhpInfo"] != false ||
+		settings["Export"].(map[string]any)["format"] != "csv" || settings["Import.allow_interrupt"] != true {
+		t.Errorf("unexpected settings: %v", settings)
+	}
+	if charsets := settings["AvailableCharsets"].([]any); len(charsets) != 2 || charsets[1] != "iso-8859-1" {
+		t.Errorf("unexpected array: %v", charsets)
+	}
+	servers := doc["servers"].([]map[string]any)
+	if len(servers) != 2 || servers[0]["port"] != int64(3307) || servers[0]["verbose"] != `Primary "db1"` {
+		t.Fatalf("unexpected servers: %v", servers)
+	}
+	if hide := servers[0]["settings"].(map[string]any)["hide_db"]; hide != "^(information|performance)_schema$" {
+		t.Errorf("unexpected literal string: %v", hide)
+	}
+
+	for word, want := range map[string]any{
+		"0": int64(0), "-17": int64(-17), "+1_000": int64(1000), "0xDEAD_beef": int64(0xdeadbeef),
+		"0o755": int64(0o755), "0b1010": int64(10), "3.14": 3.14, "-0.5": -0.5, "6.02e+2_3": 6.02e23,
+		"1E-2": 0.01, "0.0": 0.0, "-inf": math.Inf(-1),
+	} {
+		doc, err := decodeTOML("a = " + word + "\n")
+		if err != nil || doc["a"] != want {
+			t.Errorf("%s: expected %v (%T), got %v (%v)", word, want, want, doc["a"], err)
+		}
+	}
+	if doc, err := decodeTOML("a = nan\n"); err != nil || !math.IsNaN(doc["a"].(float64)) {
+		t.Errorf("expected nan, got %v (%v)", doc["a"], err)
+	}
+	for _, word := range []string{"017", "-01", "00", "0X1F", "0x", "0x_1", "0o8", "0b102", "+0x1", "1__000", "_1", "1_",
+		"01.5", "1.", ".5", "1e", "1e+-2", "1._5", "1_.5", "0x1p-2", "Inf", "infinity", "1_e2"} {
+		if doc, err := decodeTOML("a = " + word + "\n"); err == nil {
+			t.Errorf("%s: expected error, got %v", word, doc["a"])
+		}
+	}
+
+	for name, src := range map[string]string{
+		"duplicate key":       "a = 1\na = 2\n",
+		"unterminated string": "a = \"abc\n",
+		"invalid value":       "a = yes\n",
+		"missing equals":      "a 1\n",
+		"trailing garbage":    "a = 1 2\n",
+		"inline table":        "a = { b = 1 }\n",
+		"unterminated array":  "a = [1, 2\n",
+		"bad header":          "[a\n",
+		"table over value":    "a = 1\n[a]\n",
+	} {
+		if _, err := decodeTOML(src); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Tables may only be defined once, but implicitly created ones may be
+	// defined later and every array of tables element has its own.
+	for _, src := range []string{
+		"[a.b]\nx = 1\n[a]\ny = 2\n",
+		"[a]\nb.c = 1\n[a.b.d]\nx = 1\n",
+		"[[s]]\n[s.t]\nx = 1\n[[s]]\n[s.t]\nx = 2\n",
+	} {
+		if _, err := decodeTOML(src); err != nil {
+			t.Errorf("%q: unexpected error: %v", src, err)
+		}
+	}
+	for src, want := range map[string]string{
+		"[a]\nx = 1\n\n[a]\n":                    `line 4: table "a" is already defined`,
+		"[a.b]\n[a.b]\n":                         `line 2: table "a.b" is already defined`,
+		"[a]\nb.c = 1\n[a.b]\n":                  `line 3: table "a.b" is already defined`,
+		"[a.b]\n[a]\nb.c = 1\n":                  `line 3: table "b" is already defined`,
+		"[[a]]\n[a]\n":                           `line 2: table "a" is already defined as an array of tables`,
+		"[a]\n[[a]]\n":                           `line 2: table "a" is already defined`,
+		"[[t.a]]\n[t]\na.y = 2\n":                `line 3: key "a" is already defined as an array of tables`,
+		"a = 1\nb = { c = 1 }\n":                 `line 2: inline tables are not supported`,
+		"a = 1\nb = \"\"\"\nmulti\n\"\"\"\n":     `line 2: multi-line strings are not supported`,
+		"a = '''x'''\n":                          `line 1: multi-line strings are not supported`,
+		"a = 1\n\nwhen = 1979-05-27T07:32:00Z\n": `line 3: dates and times are not supported`,
+		"at = 07:32:00\n":                        `line 1: dates and times are not supported`,
+	} {
+		if _, err := decodeTOML(src); err == nil || err.Error() != want {
+			t.Errorf("%q: expected %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/run/secrets", 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	secret := strings.Repeat("s", 32)
+	if err := fs.WriteFile(mem, "/run/secrets/pma-blowfish", []byte(secret+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	t.Setenv("PMA_CONTROL_PASSWORD", "control pass")
+
+	inv, err := Parse(exampleInventory)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	data, err := inv.Render(mem, "/etc/pma-up/inventory.toml")
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	rendered := string(data)
+
+	for _, want := range []string{
+		"generated by pma-up from /etc/pma-up/inventory.toml",
+		"$cfg['blowfish_secret'] = '" + secret + "';\n",
+		"$cfg['Servers'][$i]['verbose'] = 'Primary \"db1\"';\n",
+		"$cfg['Servers'][$i]['port'] = '3307';\n",
+		"$cfg['Servers'][$i]['auth_type'] = 'cookie';\n",
+		"$cfg['Servers'][$i]['controluser'] = 'pma';\n",
+		"$cfg['Servers'][$i]['controlpass'] = 'control pass';\n",
+		"$cfg['Servers'][$i]['pmadb'] = 'phpmyadmin';\n",
+		"$cfg['Servers'][$i]['bookmarktable'] = 'pma__bookmark';\n",
+		"$cfg['Servers'][$i]['hide_db'] = '^(information|performance)_schema$';\n",
+		"$cfg['Servers'][$i]['socket'] = '/run/mysqld/mysqld.sock';\n",
+		"$cfg['Servers'][$i]['password'] = 'it\\'s secret';\n",
+		"$cfg['Servers'][$i]['controluser'] = 'local_pma';\n",
+		"$cfg['AvailableCharsets'] = ['utf-8', 'iso-8859-1'];\n",
+		"$cfg['Export']['format'] = 'csv';\n",
+		"$cfg['MaxNavigationItems'] = 100;\n",
+		"$cfg['ShowPhpInfo'] = false;\n",
+	} {
+		if !strings.Contains(rendered, want) {
+			t.Errorf("expected %q in rendered config:\n%s", want, rendered)
+		}
+	}
+
+	// The rendered file reads back as PHP with two servers.
+	directives, err := phpconfig.Parse(data)
+	if err != nil {
+		t.Fatalf("rendered config does not parse: %v", err)
+	}
+	hosts := 0
+	for _, d := range directives {
+		if d.Key.String() == "$cfg['Servers'][$i]['auth_type']" {
+			hosts++
+		}
+	}
+	if hosts != 2 {
+		t.Errorf("expected 2 servers, got %d:\n%s", hosts, rendered)
+	}
+
+	t.Setenv("PMA_CONTROL_PASSWORD", "")
+	if err := mem.RemoveAll("/run/secrets/pma-blowfish"); err != nil {
+		t.Fatalf("failed to remove secret: %v", err)
+	}
+	if _, err := inv.Render(mem, "inventory.toml"); err == nil || !strings.Contains(err.Error(), "blowfish_secret") {
+		t.Errorf("expected error for missing secret file, got %v", err)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	const server = "\n[[servers]]\nhost = \"db\"\n"
+	for name, src := range map[string]string{
+		"no secret":            server,
+		"no servers":           "blowfish_secret = 'x'\n",
+		"unknown key":          "blowfish_secret = 'x'\ncolour = 'blue'\n" + server,
+		"unknown server key":   "blowfish_secret = 'x'\n" + server + "hots = 'db'\n",
+		"two secret forms":     "blowfish_secret = 'x'\nblowfish_secret_env = 'X'\n" + server,
+		"wrong type":           "blowfish_secret = 'x'\n" + server + "port = '3306'\n",
+		"no host":              "blowfish_secret = 'x'\n[[servers]]\nverbose = 'db'\n",
+		"unknown auth type":    "blowfish_secret = 'x'\n" + server + "auth_type = 'magic'\n",
+		"config without user":  "blowfish_secret = 'x'\n" + server + "auth_type = 'config'\n",
+		"control without user": "blowfish_secret = 'x'\n[control]\npassword = 'x'\n" + server,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
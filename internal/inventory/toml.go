@@ -0,0 +1,481 @@
+package inventory
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeTOML decodes the subset of TOML inventories use: tables, arrays of
+// tables, dotted keys, single-line strings, integers, floats, booleans and
+// arrays. Tables decode to map[string]any, arrays of tables to
+// []map[string]any and arrays to []any. Documents using other TOML syntax,
+// such as inline tables, multi-line strings or dates, or defining a table
+// twice, are rejected with the line at fault.
+func decodeTOML(src string) (map[string]any, error) {
+	p := &tomlParser{src: src, line: 1, origins: map[string]tableOrigin{}}
+	root := map[string]any{}
+	current, currentPath := root, ""
+	for {
+		p.skipSpaceAndNewlines()
+		if p.pos >= len(p.src) {
+			return root, nil
+		}
+
+		var err error
+		if p.src[p.pos] == '[' {
+			current, currentPath, err = p.header(root)
+		} else {
+			err = p.keyValue(current, currentPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+
+	// origins records how each table was created, by its path: its quoted
+	// keys joined by dots, with the index of array of tables elements.
+	origins map[string]tableOrigin
+}
+
+// tableOrigin is how a table came to exist, which decides whether later
+// headers and dotted keys may define it again or extend it.
+type tableOrigin int
+
+const (
+	implicitTable tableOrigin = iota // Created as the parent of a header's table
+	headerTable                      // Defined by a [table] header
+	dottedTable                      // Defined by a dotted key
+)
+
+// skipSpace skips spaces, tabs and a comment up to the end of the line.
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t':
+			p.pos++
+		case '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) skipSpaceAndNewlines() {
+	for {
+		p.skipSpace()
+		if p.pos < len(p.src) && (p.src[p.pos] == '\n' || p.src[p.pos] == '\r') {
+			if p.src[p.pos] == '\n' {
+				p.line++
+			}
+			p.pos++
+			continue
+		}
+		return
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == '\r' {
+		p.pos++
+	}
+	if p.pos < len(p.src) && p.src[p.pos] != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.src[p.pos])
+	}
+	return nil
+}
+
+// header reads a [table] or [[array.of.tables]] header and returns the table
+// following keys belong to, and its path.
+func (p *tomlParser) header(root map[string]any) (map[string]any, string, error) {
+	array := strings.HasPrefix(p.src[p.pos:], "[[")
+	if array {
+		p.pos += 2
+	} else {
+		p.pos++
+	}
+	p.skipSpace()
+	keys, err := p.key()
+	if err != nil {
+		return nil, "", err
+	}
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(p.src[p.pos:], closing) {
+		return nil, "", fmt.Errorf("expected %q closing table header", closing)
+	}
+	p.pos += len(closing)
+
+	parent, path, err := p.descend(root, "", keys[:len(keys)-1], implicitTable)
+	if err != nil {
+		return nil, "", err
+	}
+	last, name := keys[len(keys)-1], strings.Join(keys, ".")
+	path = tablePath(path, last)
+	switch existing := parent[last].(type) {
+	case nil:
+		table := map[string]any{}
+		if array {
+			parent[last] = []map[string]any{table}
+			return table, path + "[0]", nil
+		}
+		parent[last] = table
+		p.origins[path] = headerTable
+		return table, path, nil
+	case map[string]any:
+		if array || p.origins[path] != implicitTable {
+			return nil, "", fmt.Errorf("table %q is already defined", name)
+		}
+		p.origins[path] = headerTable
+		return existing, path, nil
+	case []map[string]any:
+		if !array {
+			return nil, "", fmt.Errorf("table %q is already defined as an array of tables", name)
+		}
+		table := map[string]any{}
+		parent[last] = append(existing, table)
+		return table, fmt.Sprintf("%s[%d]", path, len(existing)), nil
+	default:
+		return nil, "", fmt.Errorf("key %q is already defined", name)
+	}
+}
+
+// descend returns the table at the dotted path keys below table, whose path is
+// path, and its path, creating missing tables with the given origin. A header
+// continues through any table, and through the last element of an array of
+// tables; a dotted key only through tables dotted keys created.
+func (p *tomlParser) descend(table map[string]any, path string, keys []string, origin tableOrigin) (map[string]any, string, error) {
+	for _, key := range keys {
+		path = tablePath(path, key)
+		switch next := table[key].(type) {
+		case nil:
+			child := map[string]any{}
+			table[key] = child
+			p.origins[path] = origin
+			table = child
+		case map[string]any:
+			if origin == dottedTable && p.origins[path] != dottedTable {
+				return nil, "", fmt.Errorf("table %q is already defined", key)
+			}
+			table = next
+		case []map[string]any:
+			if origin == dottedTable {
+				return nil, "", fmt.Errorf("key %q is already defined as an array of tables", key)
+			}
+			path = fmt.Sprintf("%s[%d]", path, len(next)-1)
+			table = next[len(next)-1]
+		default:
+			return nil, "", fmt.Errorf("key %q is already defined", key)
+		}
+	}
+	return table, path, nil
+}
+
+// tablePath returns the path of the table key below the table at path.
+func tablePath(path, key string) string {
+	return path + "." + strconv.Quote(key)
+}
+
+func (p *tomlParser) keyValue(table map[string]any, path string) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.pos >= len(p.src) || p.src[p.pos] != '=' {
+		return fmt.Errorf("expected '=' after key")
+	}
+	p.pos++
+	p.skipSpace()
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+
+	parent, _, err := p.descend(table, path, keys[:len(keys)-1], dottedTable)
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return fmt.Errorf("key %q is already defined", last)
+	}
+	parent[last] = value
+	return nil
+}
+
+// key reads a possibly dotted key, and the spaces following it.
+func (p *tomlParser) key() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		var key string
+		switch {
+		case p.pos >= len(p.src):
+			return nil, fmt.Errorf("expected key")
+		case p.src[p.pos] == '"' || p.src[p.pos] == '\'':
+			var err error
+			if key, err = p.string(); err != nil {
+				return nil, err
+			}
+		default:
+			start := p.pos
+			for p.pos < len(p.src) && isBareKeyByte(p.src[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, fmt.Errorf("expected key, found %q", p.src[p.pos])
+			}
+			key = p.src[start:p.pos]
+		}
+		keys = append(keys, key)
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyByte(c byte) bool {
+	return c == '_' || c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *tomlParser) value() (any, error) {
+	if p.pos >= len(p.src) {
+		return nil, fmt.Errorf("expected value")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '"' || c == '\'':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) || strings.HasPrefix(p.src[p.pos:], "'''") {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return p.string()
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return nil, fmt.Errorf("inline tables are not supported")
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n#,]", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	word := p.src[start:p.pos]
+	if isDateTime(word) {
+		return nil, fmt.Errorf("dates and times are not supported")
+	}
+	switch word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, ok := parseInteger(word); ok {
+		return n, nil
+	}
+	if f, ok := parseFloat(word); ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q", word)
+}
+
+// isDateTime reports whether word starts like a TOML date or time, e.g.
+// 1979-05-27 or 07:32:00.
+func isDateTime(word string) bool {
+	digits := func(s string) bool {
+		return s != "" && strings.Trim(s, "0123456789") == ""
+	}
+	if len(word) >= 10 && digits(word[:4]) && word[4] == '-' && digits(word[5:7]) && word[7] == '-' && digits(word[8:10]) {
+		return true
+	}
+	return len(word) >= 5 && digits(word[:2]) && word[2] == ':' && digits(word[3:5])
+}
+
+// parseInteger parses a TOML integer: a decimal without leading zeros, or a
+// hexadecimal, octal or binary number prefixed with 0x, 0o or 0b.
+func parseInteger(word string) (int64, bool) {
+	base, digits, sign := 10, word, ""
+	switch {
+	case strings.HasPrefix(word, "0x"):
+		base, digits = 16, word[2:]
+	case strings.HasPrefix(word, "0o"):
+		base, digits = 8, word[2:]
+	case strings.HasPrefix(word, "0b"):
+		base, digits = 2, word[2:]
+	default:
+		if strings.HasPrefix(word, "+") || strings.HasPrefix(word, "-") {
+			sign, digits = word[:1], word[1:]
+		}
+		if len(digits) > 1 && digits[0] == '0' {
+			return 0, false
+		}
+	}
+	digits, ok := stripUnderscores(digits, base)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(sign+digits, base, 64)
+	return n, err == nil
+}
+
+// parseFloat parses a TOML float: a decimal integer part without leading
+// zeros followed by a fraction, an exponent or both, or inf or nan.
+func parseFloat(word string) (float64, bool) {
+	unsigned := word
+	if strings.HasPrefix(word, "+") || strings.HasPrefix(word, "-") {
+		unsigned = word[1:]
+	}
+	switch unsigned {
+	case "inf":
+		f, err := strconv.ParseFloat(word, 64)
+		return f, err == nil
+	case "nan":
+		return math.NaN(), true
+	}
+
+	mantissa, exponent, hasExponent := strings.Cut(strings.ReplaceAll(unsigned, "E", "e"), "e")
+	integer, fraction, hasFraction := strings.Cut(mantissa, ".")
+	if !hasFraction && !hasExponent {
+		return 0, false
+	}
+	if len(integer) > 1 && integer[0] == '0' {
+		return 0, false
+	}
+	if _, ok := stripUnderscores(integer, 10); !ok {
+		return 0, false
+	}
+	if _, ok := stripUnderscores(fraction, 10); hasFraction && !ok {
+		return 0, false
+	}
+	if hasExponent {
+		if strings.HasPrefix(exponent, "+") || strings.HasPrefix(exponent, "-") {
+			exponent = exponent[1:]
+		}
+		if _, ok := stripUnderscores(exponent, 10); !ok {
+			return 0, false
+		}
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64)
+	return f, err == nil
+}
+
+// stripUnderscores checks that s is made of digits of base, optionally
+// separated by single underscores, and returns it without them.
+func stripUnderscores(s string, base int) (string, bool) {
+	if s == "" {
+		return "", false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' {
+			if i == 0 || i == len(s)-1 || s[i+1] == '_' {
+				return "", false
+			}
+			continue
+		}
+		if digit := strings.IndexByte("0123456789abcdef", lower(s[i])); digit < 0 || digit >= base {
+			return "", false
+		}
+	}
+	return strings.ReplaceAll(s, "_", ""), true
+}
+
+// lower returns the lowercase form of the ASCII letter c, c otherwise.
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func (p *tomlParser) array() ([]any, error) {
+	p.pos++
+	values := []any{}
+	for {
+		p.skipSpaceAndNewlines()
+		if p.pos >= len(p.src) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if p.src[p.pos] == ']' {
+			p.pos++
+			return values, nil
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipSpaceAndNewlines()
+		if p.pos < len(p.src) && p.src[p.pos] == ',' {
+			p.pos++
+		} else if p.pos < len(p.src) && p.src[p.pos] != ']' {
+			return nil, fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// string reads a single-line basic ("...") or literal ('...') string.
+func (p *tomlParser) string() (string, error) {
+	quote := p.src[p.pos]
+	var b strings.Builder
+	for i := p.pos + 1; i < len(p.src); i++ {
+		c := p.src[i]
+		switch {
+		case c == quote:
+			p.pos = i + 1
+			return b.String(), nil
+		case c == '\n':
+			return "", fmt.Errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			if i+1 >= len(p.src) {
+				return "", fmt.Errorf("unterminated string")
+			}
+			i++
+			switch esc := p.src[i]; esc {
+			case '"', '\\':
+				b.WriteByte(esc)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'u', 'U':
+				size := 4
+				if esc == 'U' {
+					size = 8
+				}
+				if i+size >= len(p.src) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.src[i+1:i+1+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += size
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,140 @@
+// Package journal records the progress of an update on disk.
+//
+// The updater writes the journal next to the installation before every step
+// that changes it, so that a run killed halfway (OOM, reboot, SIGKILL) leaves
+// enough information for the next run to finish the update or roll it back.
+// A completed update removes its journal.
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// Phase is a completed step of an update.
+type Phase string
+
+const (
+	PhaseDownloaded     Phase = "downloaded"      // The release archive is in the temporary directory
+	PhaseExtracted      Phase = "extracted"       // The release tree is in the staging directory
+	PhaseConfigRestored Phase = "config-restored" // The new release tree holds the configuration file
+	PhaseBackedUp       Phase = "backed-up"       // The installation is saved at the backup path
+	PhaseSwapped        Phase = "swapped"         // The new release is live
+)
+
+// Strategy is how the release is put in place, which decides how an
+// interrupted update is recovered.
+type Strategy string
+
+const (
+	StrategyInPlace  Strategy = "in-place" // Installation moved to a backup, release moved in
+	StrategySymlink  Strategy = "symlink"  // Release installed next to the symlink target, symlink switched
+	StrategyMount    Strategy = "mount"    // Installation copied to a backup, mount point synchronized
+	StrategyReleases Strategy = "releases" // Release directory activated by the releases layout
+	StrategyInstall  Strategy = "install"  // Release moved or copied into a missing or empty installation path
+)
+
+// Journal describes an update in progress.
+type Journal struct {
+	Version          string    `json:"version"`              // phpMyAdmin version being installed
+	InstalledVersion string    `json:"installed_version"`    // phpMyAdmin version being replaced, empty if unknown
+	Strategy         Strategy  `json:"strategy,omitempty"`   // How the release is put in place, empty until chosen
+	Phases           []Phase   `json:"phases"`               // Completed phases, in order
+	TempDir          string    `json:"temp_dir"`             // Directory holding the downloaded archive
+	StagingDir       string    `json:"staging_dir"`          // Directory the release is extracted into
+	Release          string    `json:"release,omitempty"`    // Extracted release tree
+	Target           string    `json:"target,omitempty"`     // Final location of the release tree, for the symlink and releases strategies
+	Backup           string    `json:"backup,omitempty"`     // Backup of the installation, or the previous symlink target
+	BackupTime       time.Time `json:"backup_time,omitzero"` // Time the backup was taken
+	Adopted          string    `json:"adopted,omitempty"`    // Release the installation is being adopted as, for the releases strategy
+	StartedAt        time.Time `json:"started_at"`           // Time the update started
+}
+
+// Path returns the location of the journal for the given installation path.
+//
+// The journal is a hidden file placed next to the installation, e.g.
+// /var/www/.phpmyadmin.pma-up.journal.json for /var/www/phpmyadmin.
+func Path(destinationPath string) string {
+	dir, base := filepath.Split(filepath.Clean(destinationPath))
+	return filepath.Join(dir, "."+base+".pma-up.journal.json")
+}
+
+// Load reads the journal stored at path on fsys.
+//
+// A missing journal is not an error and yields a nil journal.
+func Load(fsys fs.FS, path string) (*Journal, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	j := &Journal{}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("failed to parse journal: %w", err)
+	}
+	return j, nil
+}
+
+// Save writes the journal to path on fsys.
+//
+// The journal is written to a temporary file first and renamed into place, so
+// a crash never leaves a partially written journal.
+func (j *Journal) Save(fsys fs.FS, path string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode journal: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := fs.WriteFile(fsys, tmpPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := fsys.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace journal: %w", err)
+	}
+	return fs.SyncDir(fsys, filepath.Dir(path))
+}
+
+// Remove deletes the journal stored at path on fsys.
+func Remove(fsys fs.FS, path string) error {
+	if err := fsys.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return fs.SyncDir(fsys, filepath.Dir(path))
+}
+
+// Complete records phase as completed.
+func (j *Journal) Complete(phase Phase) {
+	if !j.Reached(phase) {
+		j.Phases = append(j.Phases, phase)
+	}
+}
+
+// Revoke records phase as no longer completed, e.g. before destroying the
+// tree it vouches for.
+func (j *Journal) Revoke(phase Phase) {
+	j.Phases = slices.DeleteFunc(j.Phases, func(p Phase) bool { return p == phase })
+}
+
+// Reached reports whether phase was completed.
+func (j *Journal) Reached(phase Phase) bool {
+	return slices.Contains(j.Phases, phase)
+}
+
+// LastPhase returns the latest completed phase, or "started" when none was.
+func (j *Journal) LastPhase() Phase {
+	if len(j.Phases) == 0 {
+		return "started"
+	}
+	return j.Phases[len(j.Phases)-1]
+}
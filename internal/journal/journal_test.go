@@ -0,0 +1,93 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+func TestPath(t *testing.T) {
+	got := Path("/var/www/phpmyadmin/")
+	if got != "/var/www/.phpmyadmin.pma-up.journal.json" {
+		t.Errorf("unexpected journal path: %s", got)
+	}
+}
+
+func TestJournal_SaveLoadRemove(t *testing.T) {
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/var/www", 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	path := Path("/var/www/phpmyadmin")
+
+	j, err := Load(mem, path)
+	if err != nil || j != nil {
+		t.Fatalf("expected no journal, got %+v (%v)", j, err)
+	}
+
+	started := time.Date(2025, 6, 1, 3, 0, 0, 0, time.UTC)
+	want := &Journal{
+		Version:    "5.2.2",
+		Strategy:   StrategyInPlace,
+		TempDir:    "/tmp/pma-up-1",
+		StagingDir: "/var/www/.phpmyadmin.pma-up-staging-2",
+		Backup:     "/var/www/phpmyadmin_backup_1",
+		StartedAt:  started,
+	}
+	want.Complete(PhaseDownloaded)
+	if err := want.Save(mem, path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := mem.Lstat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected temporary journal to be renamed, got %v", err)
+	}
+
+	got, err := Load(mem, path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got.Version != want.Version || got.Strategy != want.Strategy || got.Backup != want.Backup ||
+		!got.StartedAt.Equal(started) || !got.BackupTime.IsZero() || !got.Reached(PhaseDownloaded) {
+		t.Errorf("unexpected journal after round trip: %+v", got)
+	}
+
+	if err := Remove(mem, path); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if j, err := Load(mem, path); err != nil || j != nil {
+		t.Errorf("expected journal removed, got %+v (%v)", j, err)
+	}
+}
+
+func TestLoad_Corrupt(t *testing.T) {
+	mem := fs.NewMem()
+	if err := fs.WriteFile(mem, "/journal.json", []byte("{"), 0644); err != nil {
+		t.Fatalf("failed to write journal: %v", err)
+	}
+	if _, err := Load(mem, "/journal.json"); err == nil {
+		t.Error("expected error for corrupt journal")
+	}
+}
+
+func TestJournal_Phases(t *testing.T) {
+	j := &Journal{}
+	if got := j.LastPhase(); got != "started" {
+		t.Errorf("expected started, got %s", got)
+	}
+
+	j.Complete(PhaseDownloaded)
+	j.Complete(PhaseExtracted)
+	j.Complete(PhaseDownloaded)
+	if len(j.Phases) != 2 || j.LastPhase() != PhaseExtracted {
+		t.Errorf("unexpected phases: %v", j.Phases)
+	}
+
+	j.Complete(PhaseConfigRestored)
+	j.Revoke(PhaseConfigRestored)
+	if j.Reached(PhaseConfigRestored) || !j.Reached(PhaseExtracted) {
+		t.Errorf("unexpected phases after revoke: %v", j.Phases)
+	}
+}
@@ -0,0 +1,274 @@
+// Package lock keeps concurrent pma-up runs away from the same installation.
+//
+// A run holds an exclusive advisory lock (flock) on a lock file placed next to
+// the installation for its whole duration. The lock file records which process
+// holds it, so that a run finding the installation locked can name the holder.
+// On filesystems without advisory locks, such as some NFS mounts, a claim file
+// next to the lock file holding the record is the lock instead: it is created
+// atomically, so that only one run succeeds, and a claim left behind by a
+// process that is no longer running is taken over.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// pollInterval is how often a held lock is retried while waiting for it.
+const pollInterval = 100 * time.Millisecond
+
+// claimSuffix names the claim file of a lock file, used as the lock where
+// advisory locks are unsupported.
+const claimSuffix = ".claim"
+
+// claimSeq tells apart the claims attempted concurrently by this process.
+var claimSeq atomic.Uint64
+
+// Holder describes the process holding a lock.
+type Holder struct {
+	PID     int       `json:"pid"`     // Process ID of the holder
+	Command string    `json:"command"` // Command line of the holder
+	Since   time.Time `json:"since"`   // Time the lock was acquired
+}
+
+// String names the holder for messages, e.g.
+// "PID 1234 (pma-up /var/www/phpmyadmin ...) since 2025-06-01 03:00:00".
+func (h Holder) String() string {
+	if h.PID == 0 {
+		return "another process"
+	}
+	return fmt.Sprintf("PID %d (%s) since %s", h.PID, h.Command, h.Since.Local().Format(time.DateTime))
+}
+
+// HeldError reports that a lock is held by another process.
+type HeldError struct {
+	Path   string // Lock file
+	Holder Holder // Process holding the lock, PID 0 if unknown
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s", e.Path, e.Holder)
+}
+
+// Lock is a lock held by the current process.
+type Lock struct {
+	fsys  fs.FS
+	file  fs.File // Lock file holding the advisory lock, nil for a claim
+	path  string
+	claim string // Claim file held instead of an advisory lock, if any
+}
+
+// Path returns the lock file guarding the given installation path.
+//
+// The lock file is a hidden file placed next to the installation, e.g.
+// /var/www/.phpmyadmin.pma-up.lock for /var/www/phpmyadmin.
+func Path(destinationPath string) string {
+	dir, base := filepath.Split(filepath.Clean(destinationPath))
+	return filepath.Join(dir, "."+base+".pma-up.lock")
+}
+
+// Acquire takes the lock at path on fsys, waiting up to timeout for another
+// process to release it. A zero timeout tries once; a negative one waits
+// indefinitely, or until ctx is cancelled. When the lock stays held, the error
+// is a *HeldError.
+func Acquire(ctx context.Context, fsys fs.FS, path string, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := tryAcquire(fsys, path)
+		var held *HeldError
+		if !errors.As(err, &held) {
+			return l, err
+		}
+
+		wait := pollInterval
+		if timeout >= 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, err
+			}
+			wait = min(wait, remaining)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func tryAcquire(fsys fs.FS, path string) (*Lock, error) {
+	file, err := fsys.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = fs.TryLock(fsys, file)
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrLocked):
+		holder, _ := readHolder(fsys, path)
+		closeFile(file)
+		return nil, &HeldError{Path: path, Holder: holder}
+	case errors.Is(err, errors.ErrUnsupported):
+		closeFile(file)
+		return claim(fsys, path)
+	default:
+		closeFile(file)
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	if err := writeHolder(fsys, path); err != nil {
+		// The advisory lock is held; the record only helps naming the holder.
+		fmt.Printf("warning: %v\n", err)
+	}
+	return &Lock{fsys: fsys, file: file, path: path}, nil
+}
+
+// claim takes the lock at path without advisory locks, by creating its claim
+// file holding the record of the current process. The claim is created
+// atomically, so that of two runs only one succeeds, and a claim whose holder
+// is no longer running is moved aside before it is replaced, so that of two
+// runs taking it over only one does.
+func claim(fsys fs.FS, path string) (*Lock, error) {
+	claimPath := path + claimSuffix
+	for takenOver := false; ; takenOver = true {
+		err := createClaim(fsys, claimPath)
+		if err == nil {
+			return &Lock{fsys: fsys, path: path, claim: claimPath}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		holder, err := readHolder(fsys, claimPath)
+		if errors.Is(err, os.ErrNotExist) && !takenOver {
+			// Released in the meantime.
+			continue
+		}
+		if err != nil || takenOver || holder.PID == 0 || holder.PID == os.Getpid() || processAlive(holder.PID) {
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+		if err := removeStaleClaim(fsys, claimPath, holder); err != nil {
+			return nil, err
+		}
+		fmt.Printf("warning: taking over stale lock %s of %s, which is no longer running\n", path, holder)
+	}
+}
+
+// createClaim creates the claim file at claimPath holding the record of the
+// current process, failing with an error matching os.ErrExist when it exists.
+// The record is written to a file of its own and hard linked into place, so
+// that a claim is never seen without its record; where hard links are
+// unsupported, the claim is created exclusively and written afterwards.
+func createClaim(fsys fs.FS, claimPath string) error {
+	tmpPath := fmt.Sprintf("%s.%d-%d.tmp", claimPath, os.Getpid(), claimSeq.Add(1))
+	if err := writeHolder(fsys, tmpPath); err != nil {
+		return err
+	}
+	defer func() {
+		if err := fsys.RemoveAll(tmpPath); err != nil {
+			fmt.Printf("warning: failed to remove %s: %v\n", tmpPath, err)
+		}
+	}()
+
+	err := fsys.Link(tmpPath, claimPath)
+	if err == nil || errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	file, err := fsys.OpenFile(claimPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	closeFile(file)
+	return writeHolder(fsys, claimPath)
+}
+
+// removeStaleClaim removes the claim file at claimPath of holder, which is no
+// longer running. The claim is renamed aside first, and put back if it turns
+// out another run replaced it in the meantime.
+func removeStaleClaim(fsys fs.FS, claimPath string, holder Holder) error {
+	stalePath := fmt.Sprintf("%s.%d.stale", claimPath, os.Getpid())
+	if err := fsys.Rename(claimPath, stalePath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to move stale lock claim aside: %w", err)
+	}
+	moved, err := readHolder(fsys, stalePath)
+	if err != nil || moved.PID != holder.PID || !moved.Since.Equal(holder.Since) {
+		if linkErr := fsys.Link(stalePath, claimPath); linkErr != nil && !errors.Is(linkErr, os.ErrExist) {
+			return fmt.Errorf("failed to restore lock claim of %s: %w", moved, linkErr)
+		}
+	}
+	if err := fsys.RemoveAll(stalePath); err != nil {
+		return fmt.Errorf("failed to remove stale lock claim: %w", err)
+	}
+	return nil
+}
+
+// Release clears the holder record and releases the lock. The lock file itself
+// is kept, so that processes waiting on it keep locking the same file.
+func (l *Lock) Release() error {
+	if l.claim != "" {
+		if err := l.fsys.RemoveAll(l.claim); err != nil {
+			return fmt.Errorf("failed to release lock: %w", err)
+		}
+		return nil
+	}
+
+	clearErr := fs.WriteFile(l.fsys, l.path, nil, 0644)
+	if clearErr != nil {
+		clearErr = fmt.Errorf("failed to clear lock file: %w", clearErr)
+	}
+	if err := l.file.Close(); err != nil {
+		return errors.Join(clearErr, fmt.Errorf("failed to release lock: %w", err))
+	}
+	return clearErr
+}
+
+// readHolder reads the holder record of the lock file at path. An empty
+// record, as left by Release, yields a zero Holder.
+func readHolder(fsys fs.FS, path string) (Holder, error) {
+	var holder Holder
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return holder, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return holder, nil
+	}
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return Holder{}, fmt.Errorf("failed to parse lock file: %w", err)
+	}
+	return holder, nil
+}
+
+// writeHolder records the current process as the holder of the lock file at path.
+func writeHolder(fsys fs.FS, path string) error {
+	data, err := json.Marshal(Holder{PID: os.Getpid(), Command: strings.Join(os.Args, " "), Since: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode lock holder: %w", err)
+	}
+	if err := fs.WriteFile(fsys, path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+func closeFile(file fs.File) {
+	if err := file.Close(); err != nil {
+		fmt.Printf("warning: failed to close lock file: %v\n", err)
+	}
+}
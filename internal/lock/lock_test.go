@@ -0,0 +1,148 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+func TestPath(t *testing.T) {
+	if got := Path("/var/www/phpmyadmin/"); got != filepath.FromSlash("/var/www/.phpmyadmin.pma-up.lock") {
+		t.Errorf("unexpected lock path %s", got)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/var/www", 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	for name, fsys := range map[string]fs.FS{"os": fs.OS{}, "mem": mem} {
+		t.Run(name, func(t *testing.T) {
+			path := Path("/var/www/phpmyadmin")
+			if name == "os" {
+				path = filepath.Join(t.TempDir(), ".phpmyadmin.pma-up.lock")
+			}
+
+			held, err := Acquire(context.Background(), fsys, path, 0)
+			if err != nil {
+				t.Fatalf("Acquire failed: %v", err)
+			}
+
+			_, err = Acquire(context.Background(), fsys, path, 0)
+			var heldErr *HeldError
+			if !errors.As(err, &heldErr) {
+				t.Fatalf("expected lock to be held, got %v", err)
+			}
+			if heldErr.Holder.PID != os.Getpid() || !strings.Contains(err.Error(), "PID") {
+				t.Errorf("expected holder to be named, got %v", err)
+			}
+
+			go func() {
+				time.Sleep(2 * pollInterval)
+				if err := held.Release(); err != nil {
+					t.Errorf("Release failed: %v", err)
+				}
+			}()
+			waited, err := Acquire(context.Background(), fsys, path, 10*time.Second)
+			if err != nil {
+				t.Fatalf("expected lock after waiting, got %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*pollInterval)
+			defer cancel()
+			if _, err := Acquire(ctx, fsys, path, -1); !errors.As(err, &heldErr) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected waiting to stop with the context, got %v", err)
+			}
+
+			if err := waited.Release(); err != nil {
+				t.Errorf("Release failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAcquire_WithoutAdvisoryLocks(t *testing.T) {
+	mem := fs.NewMem()
+	fsys := &fs.FaultFS{FS: mem, Fail: func(op string, _ ...string) error {
+		if op == "TryLock" {
+			return errors.ErrUnsupported
+		}
+		return nil
+	}}
+	const path = "/.phpmyadmin.pma-up.lock"
+	const claimPath = path + claimSuffix
+
+	record := func(pid int) {
+		t.Helper()
+		data, _ := json.Marshal(Holder{PID: pid, Command: "pma-up", Since: time.Now()})
+		if err := fs.WriteFile(mem, claimPath, data, 0644); err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+	}
+
+	record(os.Getppid())
+	var heldErr *HeldError
+	if _, err := Acquire(context.Background(), fsys, path, 0); !errors.As(err, &heldErr) || heldErr.Holder.PID != os.Getppid() {
+		t.Errorf("expected lock of a running process to be held, got %v", err)
+	}
+
+	// PIDs are bounded well below this one, so no such process runs.
+	record(1 << 30)
+	l, err := Acquire(context.Background(), fsys, path, 0)
+	if err != nil {
+		t.Fatalf("expected stale lock to be taken over, got %v", err)
+	}
+	if holder, err := readHolder(mem, claimPath); err != nil || holder.PID != os.Getpid() {
+		t.Errorf("expected current process to be recorded, got %+v (%v)", holder, err)
+	}
+	if err := l.Release(); err != nil {
+		t.Errorf("Release failed: %v", err)
+	}
+	if _, err := mem.Lstat(claimPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected claim to be removed, got %v", err)
+	}
+	if entries, _ := mem.ReadDir("/"); len(entries) != 1 {
+		t.Errorf("expected only the lock file left, got %v", entries)
+	}
+
+	// Runs racing for the lock, or to take over a stale one, never both get it.
+	for _, stale := range []bool{false, true} {
+		if stale {
+			record(1 << 30)
+		}
+		var wg sync.WaitGroup
+		var acquired atomic.Int32
+		locks := make(chan *Lock, 8)
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if l, err := Acquire(context.Background(), fsys, path, 0); err == nil {
+					acquired.Add(1)
+					locks <- l
+				}
+			}()
+		}
+		wg.Wait()
+		close(locks)
+		if acquired.Load() != 1 {
+			t.Errorf("expected exactly one run to get the lock (stale: %t), got %d", stale, acquired.Load())
+		}
+		for l := range locks {
+			if err := l.Release(); err != nil {
+				t.Errorf("Release failed: %v", err)
+			}
+		}
+	}
+}
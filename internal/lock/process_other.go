@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package lock
+
+import "os"
+
+// processAlive reports whether a process with the given PID is running.
+//
+// On Windows finding a process fails once it has exited; elsewhere the
+// process is assumed to be running.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = process.Release()
+	return true
+}
@@ -0,0 +1,207 @@
+// Package manifest records the checksum of every file shipped by a phpMyAdmin
+// release, so that files modified locally after installation can be found.
+package manifest
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// Name is the file holding the manifest at the root of every release
+// installed by pma-up.
+const Name = ".pma-up-manifest.json"
+
+// Manifest lists the files shipped by a phpMyAdmin release.
+type Manifest struct {
+	Version string            `json:"version"`
+	Files   map[string]string `json:"files"` // Slash-separated path relative to the release root -> "sha256:<hex>"
+}
+
+// Changes lists the shipped files of an installation that differ from its
+// manifest, by slash-separated path relative to the installation.
+type Changes struct {
+	Modified []string // Files whose content differs from the release
+	Removed  []string // Files that are missing or no longer regular files
+}
+
+// Empty reports whether no shipped file was changed.
+func (c Changes) Empty() bool {
+	return len(c.Modified) == 0 && len(c.Removed) == 0
+}
+
+// Build computes the manifest of the release tree at root on fsys.
+func Build(fsys fs.FS, root, version string) (*Manifest, error) {
+	m := &Manifest{Version: version, Files: map[string]string{}}
+	err := fs.Walk(fsys, root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		if relPath == Name {
+			return nil
+		}
+		sum, err := fileChecksum(fsys, filePath)
+		if err != nil {
+			return err
+		}
+		m.Files[filepath.ToSlash(relPath)] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build manifest: %w", err)
+	}
+	return m, nil
+}
+
+// FromZip computes the manifest of the release archive at zipPath on fsys,
+// without extracting it. The single top-level directory releases are
+// packaged in is stripped from the paths.
+func FromZip(fsys fs.FS, zipPath, version string) (*Manifest, error) {
+	zipFile, err := fsys.Open(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file: %w", err)
+	}
+	defer func() {
+		if closeErr := zipFile.Close(); closeErr != nil {
+			fmt.Printf("warning: failed to close zip file: %v\n", closeErr)
+		}
+	}()
+
+	info, err := zipFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat zip file: %w", err)
+	}
+	r, err := zip.NewReader(zipFile, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip file: %w", err)
+	}
+
+	m := &Manifest{Version: version, Files: map[string]string{}}
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		_, relPath, found := strings.Cut(path.Clean(f.Name), "/")
+		if !found || relPath == Name {
+			continue
+		}
+		sum, err := zipChecksum(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from zip file: %w", f.Name, err)
+		}
+		m.Files[relPath] = sum
+	}
+	return m, nil
+}
+
+// Load reads the manifest of the release tree at root on fsys. It returns a
+// nil manifest and no error when the tree has none.
+func Load(fsys fs.FS, root string) (*Manifest, error) {
+	data, err := fs.ReadFile(fsys, filepath.Join(root, Name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", filepath.Join(root, Name), err)
+	}
+	return &m, nil
+}
+
+// Save writes the manifest into the release tree at root on fsys.
+func (m *Manifest) Save(fsys fs.FS, root string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := fs.WriteFile(fsys, filepath.Join(root, Name), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// Diff compares the installation at root on fsys with the manifest and
+// returns the shipped files that were changed, in lexical order. Files added
+// to the installation are not reported.
+func (m *Manifest) Diff(fsys fs.FS, root string) (Changes, error) {
+	var changes Changes
+	for _, relPath := range slices.Sorted(maps.Keys(m.Files)) {
+		filePath := filepath.Join(root, filepath.FromSlash(relPath))
+		info, err := fsys.Lstat(filePath)
+		if errors.Is(err, os.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+			changes.Removed = append(changes.Removed, relPath)
+			continue
+		}
+		if err != nil {
+			return Changes{}, fmt.Errorf("failed to stat %s: %w", filePath, err)
+		}
+
+		sum, err := fileChecksum(fsys, filePath)
+		if err != nil {
+			return Changes{}, err
+		}
+		if sum != m.Files[relPath] {
+			changes.Modified = append(changes.Modified, relPath)
+		}
+	}
+	return changes, nil
+}
+
+// fileChecksum returns the "sha256:<hex>" checksum of the file at path.
+func fileChecksum(fsys fs.FS, path string) (string, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			fmt.Printf("warning: failed to close %s: %v\n", path, closeErr)
+		}
+	}()
+	return checksum(file)
+}
+
+// zipChecksum returns the "sha256:<hex>" checksum of the zip entry f.
+func zipChecksum(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := rc.Close(); closeErr != nil {
+			fmt.Printf("warning: failed to close %s: %v\n", f.Name, closeErr)
+		}
+	}()
+	return checksum(rc)
+}
+
+func checksum(r io.Reader) (string, error) {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, r); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(sum.Sum(nil)), nil
+}
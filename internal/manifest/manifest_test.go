@@ -0,0 +1,131 @@
+package manifest
+
+import (
+	"archive/zip"
+	"bytes"
+	"maps"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+func writeFiles(t *testing.T, mem *fs.Mem, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := mem.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := fs.WriteFile(mem, name, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestBuildSaveLoadDiff(t *testing.T) {
+	mem := fs.NewMem()
+	writeFiles(t, mem, map[string]string{
+		"/pma/index.php":              "index",
+		"/pma/libraries/Config.php":   "config class",
+		"/pma/setup/index.php":        "setup",
+		"/pma/" + Name:                "stale manifest",
+		"/pma/themes/pmahomme/a.json": "theme",
+	})
+
+	if m, err := Load(mem, "/other"); err != nil || m != nil {
+		t.Fatalf("expected no manifest, got %+v (%v)", m, err)
+	}
+
+	built, err := Build(mem, "/pma", "5.2.1")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	want := []string{"index.php", "libraries/Config.php", "setup/index.php", "themes/pmahomme/a.json"}
+	if got := slices.Sorted(maps.Keys(built.Files)); !slices.Equal(got, want) {
+		t.Errorf("unexpected manifest files: %v", got)
+	}
+	if err := built.Save(mem, "/pma"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	m, err := Load(mem, "/pma")
+	if err != nil || m == nil {
+		t.Fatalf("Load failed: %+v (%v)", m, err)
+	}
+	if m.Version != "5.2.1" || !maps.Equal(m.Files, built.Files) {
+		t.Errorf("unexpected manifest after round trip: %+v", m)
+	}
+
+	changes, err := m.Diff(mem, "/pma")
+	if err != nil || !changes.Empty() {
+		t.Fatalf("expected no changes, got %+v (%v)", changes, err)
+	}
+
+	writeFiles(t, mem, map[string]string{
+		"/pma/libraries/Config.php": "patched",
+		"/pma/custom.php":           "added",
+	})
+	if err := mem.RemoveAll("/pma/setup"); err != nil {
+		t.Fatalf("failed to remove setup: %v", err)
+	}
+	changes, err = m.Diff(mem, "/pma")
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if !slices.Equal(changes.Modified, []string{"libraries/Config.php"}) || !slices.Equal(changes.Removed, []string{"setup/index.php"}) {
+		t.Errorf("unexpected changes: %+v", changes)
+	}
+}
+
+func TestLoad_Corrupt(t *testing.T) {
+	mem := fs.NewMem()
+	writeFiles(t, mem, map[string]string{"/pma/" + Name: "{"})
+	if _, err := Load(mem, "/pma"); err == nil {
+		t.Error("expected error for corrupt manifest")
+	}
+}
+
+func TestFromZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"phpMyAdmin-5.2.1-all-languages/":                     "",
+		"phpMyAdmin-5.2.1-all-languages/index.php":            "index",
+		"phpMyAdmin-5.2.1-all-languages/libraries/Config.php": "config class",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", name, err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+
+	mem := fs.NewMem()
+	writeFiles(t, mem, map[string]string{
+		"/tmp/release.zip":          buf.String(),
+		"/pma/index.php":            "index",
+		"/pma/libraries/Config.php": "config class",
+	})
+
+	fromZip, err := FromZip(mem, "/tmp/release.zip", "5.2.1")
+	if err != nil {
+		t.Fatalf("FromZip failed: %v", err)
+	}
+	built, err := Build(mem, "/pma", "5.2.1")
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if !maps.Equal(fromZip.Files, built.Files) {
+		t.Errorf("expected archive manifest %v to match extracted tree %v", fromZip.Files, built.Files)
+	}
+
+	if _, err := FromZip(mem, "/pma/index.php", "5.2.1"); err == nil {
+		t.Error("expected error for invalid zip")
+	}
+}
@@ -0,0 +1,579 @@
+// Package patch parses unified diffs and applies them to a directory tree.
+//
+// Hunks are applied like patch(1) without fuzz: their context must match
+// exactly, but may be found some lines away from where the hunk header says.
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// devNull names the missing side of a diff creating or deleting a file.
+const devNull = "/dev/null"
+
+// Patch is a parsed unified diff, possibly touching several files.
+type Patch struct {
+	Name  string  // Name of the patch, e.g. its file name
+	Files []*File // Changed files, in the order of the diff
+}
+
+// File is the change a patch makes to one file.
+type File struct {
+	OldName string // Path before the change, relative to the tree; empty when the file is created
+	NewName string // Path after the change, relative to the tree; empty when the file is deleted
+	Hunks   []*Hunk
+}
+
+// Path returns the path of the changed file relative to the tree.
+func (f *File) Path() string {
+	if f.NewName != "" {
+		return f.NewName
+	}
+	return f.OldName
+}
+
+// Hunk is one contiguous change of a file.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Old, New           []string // Lines the hunk replaces and replaces them with, including their line endings
+}
+
+// hunkHeaderPattern matches "@@ -l,s +l,s @@", where the sizes default to 1.
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Parse parses the unified diff data named name. Lines outside file headers
+// and hunks, such as commit messages or git extended headers, are ignored.
+//
+// Paths are relative to the tree the patch applies to. Like patch(1), Parse
+// resolves the two names of a file header to one path: the a/ and b/ prefixes
+// of git diffs are stripped, as is a differing first component, as in
+// "diff -ruN pma.orig pma", and of two names differing only by a suffix, as in
+// "diff -u login.php.orig login.php", the shorter is patched. A side dated at
+// the Unix epoch, which is how "diff -N" marks a missing file, is treated like
+// /dev/null.
+func Parse(name string, data []byte) (*Patch, error) {
+	p := &Patch{Name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	scanner.Split(scanLinesKeepEnds)
+
+	lineNo := 0
+	next := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		lineNo++
+		return scanner.Text(), true
+	}
+	fail := func(format string, args ...any) (*Patch, error) {
+		return nil, fmt.Errorf("%s:%d: %s", name, lineNo, fmt.Sprintf(format, args...))
+	}
+
+	line, ok := next()
+	for ok {
+		if !strings.HasPrefix(line, "--- ") {
+			line, ok = next()
+			continue
+		}
+		oldSide := parseFileHeader(line[4:])
+		if line, ok = next(); !ok || !strings.HasPrefix(line, "+++ ") {
+			return fail("expected +++ line after ---")
+		}
+		newSide := parseFileHeader(line[4:])
+		if oldSide.absent && newSide.absent {
+			return fail("both sides of the diff are missing")
+		}
+		target, err := resolvePath(oldSide.name, newSide.name)
+		if err != nil {
+			return fail("%v", err)
+		}
+		file := &File{OldName: target, NewName: target}
+		if oldSide.absent {
+			file.OldName = ""
+		}
+		if newSide.absent {
+			file.NewName = ""
+		}
+		p.Files = append(p.Files, file)
+
+		line, ok = next()
+		for ok && strings.HasPrefix(line, "@@ ") {
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			if match == nil {
+				return fail("malformed hunk header %q", strings.TrimRight(line, "\r\n"))
+			}
+			h := &Hunk{
+				OldStart: atoi(match[1], 0), OldLines: atoi(match[2], 1),
+				NewStart: atoi(match[3], 0), NewLines: atoi(match[4], 1),
+			}
+			file.Hunks = append(file.Hunks, h)
+
+			var lastOp byte
+			for len(h.Old) < h.OldLines || len(h.New) < h.NewLines {
+				if line, ok = next(); !ok {
+					return fail("unexpected end of patch in hunk")
+				}
+				op, text := line[0], line[1:]
+				if strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r") == "" {
+					// Some editors strip the space of empty context lines,
+					// CRLF ones included.
+					op, text = ' ', line
+				}
+				if !strings.HasSuffix(text, "\n") {
+					// The last line of a patch lacking its own newline.
+					text += "\n"
+				}
+				switch op {
+				case ' ':
+					h.Old, h.New = append(h.Old, text), append(h.New, text)
+				case '-':
+					h.Old = append(h.Old, text)
+				case '+':
+					h.New = append(h.New, text)
+				case '\\':
+					h.noNewline(lastOp)
+				default:
+					return fail("unexpected line %q in hunk", strings.TrimRight(line, "\r\n"))
+				}
+				lastOp = op
+			}
+
+			line, ok = next()
+			if ok && strings.HasPrefix(line, "\\") {
+				h.noNewline(lastOp)
+				line, ok = next()
+			}
+		}
+		if len(file.Hunks) == 0 && file.OldName != "" && file.NewName != "" {
+			return fail("no hunks for %s", file.Path())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if len(p.Files) == 0 {
+		return nil, fmt.Errorf("%s: no file changes found", name)
+	}
+	return p, nil
+}
+
+// noNewline records a "\ No newline at end of file" marker, which applies to
+// the last line read, of kind op.
+func (h *Hunk) noNewline(op byte) {
+	trim := func(lines []string) {
+		if len(lines) > 0 {
+			lines[len(lines)-1] = strings.TrimSuffix(lines[len(lines)-1], "\n")
+		}
+	}
+	switch op {
+	case ' ':
+		trim(h.Old)
+		trim(h.New)
+	case '-':
+		trim(h.Old)
+	case '+':
+		trim(h.New)
+	}
+}
+
+// scanLinesKeepEnds is bufio.ScanLines keeping the line endings.
+func scanLinesKeepEnds(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// fileHeader is one side of a ---/+++ file header.
+type fileHeader struct {
+	name   string // Path as written, unquoted; empty for /dev/null
+	absent bool   // Whether the file does not exist on this side
+}
+
+// epochLayouts are the timestamp layouts diff -u writes after a file name.
+var epochLayouts = []string{"2006-01-02 15:04:05.999999999 -0700", "2006-01-02 15:04:05 -0700"}
+
+// parseFileHeader parses the path and timestamp of a ---/+++ line.
+func parseFileHeader(field string) fileHeader {
+	name, stamp, _ := strings.Cut(strings.TrimRight(field, "\r\n"), "\t")
+	name = strings.TrimSpace(name)
+	if name == devNull {
+		return fileHeader{absent: true}
+	}
+	if unquoted, err := strconv.Unquote(name); err == nil && strings.HasPrefix(name, `"`) {
+		name = unquoted
+	}
+
+	side := fileHeader{name: name}
+	for _, layout := range epochLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(stamp)); err == nil {
+			side.absent = t.Unix() == 0
+			break
+		}
+	}
+	return side
+}
+
+// resolvePath returns the path, relative to the tree, of the file the two
+// names of a file header denote; either name is empty for /dev/null.
+func resolvePath(oldName, newName string) (string, error) {
+	var target string
+	switch {
+	case oldName == "" || newName == "":
+		target = cmp.Or(oldName, newName)
+		if strings.HasPrefix(target, "a/") || strings.HasPrefix(target, "b/") {
+			target = target[2:]
+		}
+	case strings.HasPrefix(oldName, "a/") && strings.HasPrefix(newName, "b/"):
+		if target = sameFile(oldName[2:], newName[2:]); target == "" {
+			return "", fmt.Errorf("renaming %s to %s is not supported", oldName[2:], newName[2:])
+		}
+	default:
+		if target = sameFile(oldName, newName); target == "" {
+			target = sameFile(stripComponent(oldName), stripComponent(newName))
+		}
+		if target == "" {
+			return "", fmt.Errorf("renaming %s to %s is not supported", oldName, newName)
+		}
+	}
+
+	clean := path.Clean(target)
+	if target == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid path %q: must stay inside the tree", target)
+	}
+	return clean, nil
+}
+
+// sameFile returns the file both names denote: the name itself when they are
+// equal, or the shorter when they only differ by a suffix such as ".orig" or
+// "~". It returns "" when they denote different files.
+func sameFile(a, b string) string {
+	if a == "" || b == "" {
+		return ""
+	}
+	a, b = path.Clean(a), path.Clean(b)
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+	suffix, ok := strings.CutPrefix(b, a)
+	if ok && (suffix == "" || suffix == "~" || strings.HasPrefix(suffix, ".") && !strings.Contains(suffix, "/")) {
+		return a
+	}
+	return ""
+}
+
+// stripComponent removes the first component of name, as patch -p1 does.
+func stripComponent(name string) string {
+	_, rest, _ := strings.Cut(name, "/")
+	return rest
+}
+
+func atoi(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// Load parses every patch in dir on fsys, i.e. the files named *.patch or
+// *.diff, in lexical order of their names.
+func Load(fsys fs.FS, dir string) ([]*Patch, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patches directory: %w", err)
+	}
+
+	var patches []*Patch
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".patch" && ext != ".diff") {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patch: %w", err)
+		}
+		p, err := Parse(entry.Name(), data)
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, p)
+	}
+	slices.SortFunc(patches, func(a, b *Patch) int { return strings.Compare(a.Name, b.Name) })
+	return patches, nil
+}
+
+// HunkResult reports how one hunk applied.
+type HunkResult struct {
+	Patch  string // Name of the patch
+	File   string // Path of the changed file
+	Index  int    // Position of the hunk in the file's hunks, from 1
+	Line   int    // Line the hunk header expects the change at
+	Offset int    // Lines away from Line the hunk applied
+	Err    error  // Why the hunk did not apply, nil if it did
+
+	pos int // Index of the first line the hunk replaces
+}
+
+func (r HunkResult) String() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("%s: %s: hunk #%d at line %d FAILED: %v", r.Patch, r.File, r.Index, r.Line, r.Err)
+	case r.Offset != 0:
+		return fmt.Sprintf("%s: %s: hunk #%d applied at line %d (offset %+d lines)", r.Patch, r.File, r.Index, r.Line+r.Offset, r.Offset)
+	}
+	return fmt.Sprintf("%s: %s: hunk #%d applied at line %d", r.Patch, r.File, r.Index, r.Line)
+}
+
+// Report lists how every hunk of a patch set applied.
+type Report []HunkResult
+
+// Failed returns the hunks that did not apply.
+func (r Report) Failed() Report {
+	var failed Report
+	for _, result := range r {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r Report) String() string {
+	lines := make([]string, len(r))
+	for i, result := range r {
+		lines[i] = result.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ApplyError reports a patch set that did not apply cleanly.
+type ApplyError struct {
+	Report Report // Every hunk of the patch set, including those that applied
+}
+
+func (e *ApplyError) Error() string {
+	failed := e.Report.Failed()
+	return fmt.Sprintf("%d of %d hunks did not apply:\n%s", len(failed), len(e.Report), e.Report)
+}
+
+var (
+	errContext = errors.New("context does not match")
+	errExists  = errors.New("file to create already exists")
+	errMissing = errors.New("file to patch does not exist")
+	errLeft    = errors.New("file to delete is not empty after the change")
+)
+
+// Apply applies patches, in order, to the tree at root on fsys.
+//
+// Every hunk is tried before anything is written, and the tree is only
+// modified if all of them apply; otherwise Apply fails with an *ApplyError
+// reporting each hunk. It returns the report and the relative paths of the
+// files changed, created or deleted.
+func Apply(fsys fs.FS, root string, patches []*Patch) (Report, []string, error) {
+	type state struct {
+		content []string // Lines including their line endings; nil when the file does not exist
+		perm    os.FileMode
+		changed bool
+	}
+	files := map[string]*state{}
+	var order []string
+
+	load := func(rel string) (*state, error) {
+		if st, ok := files[rel]; ok {
+			return st, nil
+		}
+		st := &state{perm: 0644}
+		fullPath := filepath.Join(root, filepath.FromSlash(rel))
+		info, err := fsys.Stat(fullPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		case !info.Mode().IsRegular():
+			return nil, fmt.Errorf("%s is not a regular file", rel)
+		default:
+			data, err := fs.ReadFile(fsys, fullPath)
+			if err != nil {
+				return nil, err
+			}
+			st.content, st.perm = splitLines(string(data)), info.Mode().Perm()
+		}
+		files[rel] = st
+		order = append(order, rel)
+		return st, nil
+	}
+
+	var report Report
+	failed := false
+	for _, p := range patches {
+		for _, file := range p.Files {
+			rel := file.Path()
+			st, err := load(rel)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read %s: %w", rel, err)
+			}
+			results := applyFile(st.content, file)
+			var fileErr error
+			switch {
+			case file.OldName == "" && st.content != nil:
+				fileErr = errExists
+			case file.OldName != "" && st.content == nil:
+				fileErr = errMissing
+			}
+
+			content := st.content
+			if fileErr == nil {
+				content, fileErr = patchLines(st.content, file.Hunks, results)
+			}
+			if fileErr == nil && file.NewName == "" && len(content) > 0 {
+				fileErr = errLeft
+			}
+			for i := range results {
+				results[i].Patch, results[i].File = p.Name, rel
+				if fileErr != nil && results[i].Err == nil && !errors.Is(fileErr, errContext) {
+					results[i].Err = fileErr
+				}
+			}
+			if fileErr != nil && len(results) == 0 {
+				results = append(results, HunkResult{Patch: p.Name, File: rel, Err: fileErr})
+			}
+			report = append(report, results...)
+			if fileErr != nil {
+				failed = true
+				continue
+			}
+
+			if file.NewName == "" {
+				content = nil
+			} else if content == nil {
+				content = []string{}
+			}
+			st.content, st.changed = content, true
+		}
+	}
+	if failed {
+		return report, nil, &ApplyError{Report: report}
+	}
+
+	var changed []string
+	for _, rel := range order {
+		st := files[rel]
+		if !st.changed {
+			continue
+		}
+		fullPath := filepath.Join(root, filepath.FromSlash(rel))
+		if st.content == nil {
+			if err := fsys.RemoveAll(fullPath); err != nil {
+				return report, changed, fmt.Errorf("failed to delete %s: %w", rel, err)
+			}
+		} else {
+			if err := fsys.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+				return report, changed, fmt.Errorf("failed to create directory for %s: %w", rel, err)
+			}
+			if err := fs.WriteFile(fsys, fullPath, []byte(strings.Join(st.content, "")), st.perm); err != nil {
+				return report, changed, fmt.Errorf("failed to write %s: %w", rel, err)
+			}
+		}
+		changed = append(changed, rel)
+	}
+	return report, changed, nil
+}
+
+// applyFile locates every hunk of file in content and returns their results.
+// Hunks are searched in order, each after the previous one, starting where
+// the previous offset suggests.
+func applyFile(content []string, file *File) []HunkResult {
+	results := make([]HunkResult, len(file.Hunks))
+	offset, minPos := 0, 0
+	for i, h := range file.Hunks {
+		results[i] = HunkResult{Index: i + 1, Line: h.OldStart}
+		expected := h.OldStart - 1 + offset
+		if h.OldLines == 0 {
+			// An insertion goes after line OldStart.
+			expected++
+		}
+		pos, ok := locate(content, h.Old, expected, minPos)
+		if !ok {
+			results[i].Err = errContext
+			continue
+		}
+		offset += pos - expected
+		results[i].Offset, results[i].pos = offset, pos
+		minPos = pos + len(h.Old)
+	}
+	return results
+}
+
+// patchLines applies hunks located by results to content. It fails with
+// errContext if a hunk could not be located.
+func patchLines(content []string, hunks []*Hunk, results []HunkResult) ([]string, error) {
+	for _, result := range results {
+		if result.Err != nil {
+			return nil, errContext
+		}
+	}
+	var patched []string
+	next := 0
+	for i, h := range hunks {
+		patched = append(patched, content[next:results[i].pos]...)
+		patched = append(patched, h.New...)
+		next = results[i].pos + len(h.Old)
+	}
+	return append(patched, content[next:]...), nil
+}
+
+// locate finds the lines old in content at or after minPos, as close as
+// possible to expected.
+func locate(content, old []string, expected, minPos int) (int, bool) {
+	last := len(content) - len(old)
+	for d := 0; ; d++ {
+		below, above := expected-d, expected+d
+		if below < minPos && above > last {
+			return 0, false
+		}
+		if below >= minPos && below <= last && matchAt(content, old, below) {
+			return below, true
+		}
+		if d > 0 && above >= minPos && above <= last && matchAt(content, old, above) {
+			return above, true
+		}
+	}
+}
+
+func matchAt(content, old []string, pos int) bool {
+	for i, line := range old {
+		if content[pos+i] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// splitLines splits s into lines keeping their line endings.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
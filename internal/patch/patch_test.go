@@ -0,0 +1,334 @@
+package patch
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+const loginPHP = `<?php
+function login() {
+    $banner = '';
+    echo $banner;
+    authenticate();
+}
+
+function logout() {
+    session_destroy();
+}
+`
+
+const bannerPatch = `From: Ops <ops@example.com>
+Subject: Show a login banner
+
+diff --git a/libraries/login.php b/libraries/login.php
+index 1111111..2222222 100644
+--- a/libraries/login.php
++++ b/libraries/login.php
+@@ -2,4 +2,4 @@ function login() {
+ function login() {
+-    $banner = '';
++    $banner = 'Authorized use only';
+     echo $banner;
+     authenticate();
+@@ -8,3 +8,4 @@ function login() {
+ function logout() {
+     session_destroy();
++    audit('logout');
+ }
+--- /dev/null
++++ b/robots.txt
+@@ -0,0 +1,2 @@
++User-agent: *
++Disallow: /
+\ No newline at end of file
+`
+
+func memTree(t *testing.T, files map[string]string) *fs.Mem {
+	t.Helper()
+	mem := fs.NewMem()
+	for name, content := range files {
+		if err := mem.MkdirAll("/pma/libraries", 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := fs.WriteFile(mem, "/pma/"+name, []byte(content), 0640); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return mem
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("banner.patch", []byte(bannerPatch))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(p.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(p.Files))
+	}
+
+	login := p.Files[0]
+	if login.OldName != "libraries/login.php" || login.Path() != "libraries/login.php" || len(login.Hunks) != 2 {
+		t.Errorf("unexpected file: %+v", login)
+	}
+	if h := login.Hunks[1]; h.OldStart != 8 || h.OldLines != 3 || len(h.Old) != 3 || len(h.New) != 4 {
+		t.Errorf("unexpected hunk: %+v", h)
+	}
+
+	robots := p.Files[1]
+	if robots.OldName != "" || robots.NewName != "robots.txt" {
+		t.Errorf("unexpected created file: %+v", robots)
+	}
+	if got := strings.Join(robots.Hunks[0].New, ""); got != "User-agent: *\nDisallow: /" {
+		t.Errorf("expected missing final newline honored, got %q", got)
+	}
+
+	for name, data := range map[string]string{
+		"no changes":       "just a message\n",
+		"escaping path":    "--- a/../etc/passwd\n+++ b/../etc/passwd\n@@ -1 +1 @@\n-a\n+b\n",
+		"absolute path":    "--- /etc/passwd\n+++ /etc/passwd\n@@ -1 +1 @@\n-a\n+b\n",
+		"missing +++":      "--- a/x\n@@ -1 +1 @@\n",
+		"truncated hunk":   "--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n-a\n",
+		"bad hunk header":  "--- a/x\n+++ b/x\n@@ -a +b @@\n",
+		"garbage in hunk":  "--- a/x\n+++ b/x\n@@ -1 +1 @@\n*a\n",
+		"rename":           "--- a/x\n+++ b/y\n@@ -1 +1 @@\n-a\n+b\n",
+		"rename in tree":   "--- pma.orig/x\n+++ pma/y\n@@ -1 +1 @@\n-a\n+b\n",
+		"rename to suffix": "--- x\n+++ x.orig/y\n@@ -1 +1 @@\n-a\n+b\n",
+		"no hunks to edit": "--- a/x\n+++ b/x\n",
+	} {
+		if _, err := Parse(name, []byte(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	p, err := Parse("banner.patch", []byte(bannerPatch))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	// Two lines added at the top shift every hunk.
+	mem := memTree(t, map[string]string{"libraries/login.php": "<?php\n// local\n// header\n" + strings.TrimPrefix(loginPHP, "<?php\n")})
+	report, changed, err := Apply(mem, "/pma", []*Patch{p})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if len(report) != 3 || report[0].Offset != 2 || report[1].Offset != 2 || len(report.Failed()) != 0 {
+		t.Errorf("unexpected report:\n%s", report)
+	}
+	if !slices.Equal(changed, []string{"libraries/login.php", "robots.txt"}) {
+		t.Errorf("unexpected changed files: %v", changed)
+	}
+
+	data, err := fs.ReadFile(mem, "/pma/libraries/login.php")
+	if err != nil || !strings.Contains(string(data), "$banner = 'Authorized use only';") ||
+		!strings.Contains(string(data), "    audit('logout');\n}\n") {
+		t.Errorf("unexpected patched file %q (%v)", data, err)
+	}
+	if info, err := mem.Stat("/pma/libraries/login.php"); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode kept, got %v (%v)", info, err)
+	}
+	if data, err := fs.ReadFile(mem, "/pma/robots.txt"); err != nil || string(data) != "User-agent: *\nDisallow: /" {
+		t.Errorf("unexpected created file %q (%v)", data, err)
+	}
+}
+
+func TestApply_CRLF(t *testing.T) {
+	// The empty context line lost its leading space, as some editors do.
+	crlfPatch := "--- a/libraries/login.php\r\n" +
+		"+++ b/libraries/login.php\r\n" +
+		"@@ -5,4 +5,5 @@\r\n" +
+		"     authenticate();\r\n" +
+		" }\r\n" +
+		"\r\n" +
+		"+// Logout\r\n" +
+		" function logout() {\r\n"
+
+	p, err := Parse("crlf.patch", []byte(crlfPatch))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if h := p.Files[0].Hunks[0]; len(h.Old) != 4 || h.Old[2] != "\r\n" {
+		t.Fatalf("unexpected hunk: %q", h.Old)
+	}
+
+	mem := memTree(t, map[string]string{"libraries/login.php": strings.ReplaceAll(loginPHP, "\n", "\r\n")})
+	if _, _, err := Apply(mem, "/pma", []*Patch{p}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	data, err := fs.ReadFile(mem, "/pma/libraries/login.php")
+	if err != nil || !strings.Contains(string(data), "}\r\n\r\n// Logout\r\nfunction logout() {\r\n") {
+		t.Errorf("unexpected patched file %q (%v)", data, err)
+	}
+}
+
+func TestApply_Failures(t *testing.T) {
+	p, err := Parse("banner.patch", []byte(bannerPatch))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	deletion, err := Parse("drop.patch", []byte("--- a/libraries/login.php\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-<?php\n-function login() {\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		patches []*Patch
+		failed  int
+	}{
+		{"changed context", map[string]string{"libraries/login.php": strings.Replace(loginPHP, "session_destroy", "session_end", 1)}, []*Patch{p}, 1},
+		{"missing file", map[string]string{}, []*Patch{p}, 2},
+		{"created file exists", map[string]string{"libraries/login.php": loginPHP, "robots.txt": "mine"}, []*Patch{p}, 1},
+		{"deleted file not empty", map[string]string{"libraries/login.php": loginPHP}, []*Patch{deletion}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memTree(t, tt.files)
+			report, _, err := Apply(mem, "/pma", tt.patches)
+			var applyErr *ApplyError
+			if !errors.As(err, &applyErr) {
+				t.Fatalf("expected ApplyError, got %v", err)
+			}
+			if got := len(report.Failed()); got != tt.failed {
+				t.Errorf("expected %d failed hunks, got:\n%s", tt.failed, report)
+			}
+			if !strings.Contains(err.Error(), "FAILED") {
+				t.Errorf("expected per-hunk report in error, got %q", err)
+			}
+
+			// Nothing is written unless every hunk applies.
+			for name, content := range tt.files {
+				if data, err := fs.ReadFile(mem, "/pma/"+name); err != nil || string(data) != content {
+					t.Errorf("expected %s untouched, got %q (%v)", name, data, err)
+				}
+			}
+			if _, ok := tt.files["robots.txt"]; !ok {
+				if _, err := mem.Stat("/pma/robots.txt"); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected robots.txt not created, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestApply_DeleteFile(t *testing.T) {
+	mem := memTree(t, map[string]string{"libraries/login.php": "<?php\nfunction login() {\n"})
+	deletion, err := Parse("drop.patch", []byte("--- a/libraries/login.php\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-<?php\n-function login() {\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if _, _, err := Apply(mem, "/pma", []*Patch{deletion}); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if _, err := mem.Stat("/pma/libraries/login.php"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected file deleted, got %v", err)
+	}
+}
+
+func TestApply_DiffStyles(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		paths []string
+	}{
+		{
+			name: "diff -u file.orig file",
+			patch: "--- libraries/login.php.orig\t2025-01-21 10:00:00.000000000 +0100\n" +
+				"+++ libraries/login.php\t2025-01-21 10:05:00.000000000 +0100\n" +
+				"@@ -8,3 +8,4 @@\n function logout() {\n     session_destroy();\n+    audit('logout');\n }\n",
+			paths: []string{"libraries/login.php"},
+		},
+		{
+			// diff -N dates the missing side of created and deleted files
+			// at the epoch, here in a local time zone.
+			name: "diff -ruN pma.orig pma",
+			patch: "diff -ruN pma.orig/libraries/login.php pma/libraries/login.php\n" +
+				"--- pma.orig/libraries/login.php\t2025-01-21 10:00:00.000000000 +0100\n" +
+				"+++ pma/libraries/login.php\t2025-01-21 10:05:00.000000000 +0100\n" +
+				"@@ -8,3 +8,4 @@\n function logout() {\n     session_destroy();\n+    audit('logout');\n }\n" +
+				"diff -ruN pma.orig/libraries/old.php pma/libraries/old.php\n" +
+				"--- pma.orig/libraries/old.php\t2025-01-21 10:00:00.000000000 +0100\n" +
+				"+++ pma/libraries/old.php\t1970-01-01 01:00:00.000000000 +0100\n" +
+				"@@ -1 +0,0 @@\n-<?php\n" +
+				"diff -ruN pma.orig/robots.txt pma/robots.txt\n" +
+				"--- pma.orig/robots.txt\t1969-12-31 19:00:00.000000000 -0500\n" +
+				"+++ pma/robots.txt\t2025-01-21 10:05:00.000000000 +0100\n" +
+				"@@ -0,0 +1 @@\n+User-agent: *\n",
+			paths: []string{"libraries/login.php", "libraries/old.php", "robots.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse("local.diff", []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			var paths []string
+			for _, file := range p.Files {
+				paths = append(paths, file.Path())
+			}
+			if !slices.Equal(paths, tt.paths) {
+				t.Fatalf("unexpected paths: %v", paths)
+			}
+
+			mem := memTree(t, map[string]string{"libraries/login.php": loginPHP, "libraries/old.php": "<?php\n"})
+			if _, _, err := Apply(mem, "/pma", []*Patch{p}); err != nil {
+				t.Fatalf("Apply failed: %v", err)
+			}
+			if data, err := fs.ReadFile(mem, "/pma/libraries/login.php"); err != nil || !strings.Contains(string(data), "    audit('logout');\n}\n") {
+				t.Errorf("unexpected patched file %q (%v)", data, err)
+			}
+			if len(tt.paths) == 3 {
+				if _, err := mem.Stat("/pma/libraries/old.php"); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected old.php deleted, got %v", err)
+				}
+				if data, err := fs.ReadFile(mem, "/pma/robots.txt"); err != nil || string(data) != "User-agent: *\n" {
+					t.Errorf("unexpected created file %q (%v)", data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	mem := memTree(t, map[string]string{"libraries/login.php": loginPHP})
+	if err := mem.MkdirAll("/patches", 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{
+		"20-banner.patch": bannerPatch,
+		"10-audit.diff":   "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n",
+		"README":          "not a patch",
+	} {
+		if err := fs.WriteFile(mem, "/patches/"+name, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	patches, err := Load(mem, "/patches")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(patches) != 2 || patches[0].Name != "10-audit.diff" || patches[1].Name != "20-banner.patch" {
+		t.Errorf("unexpected patches: %+v", patches)
+	}
+
+	if err := fs.WriteFile(mem, "/patches/30-broken.patch", []byte("--- a/x\n"), 0644); err != nil {
+		t.Fatalf("failed to write patch: %v", err)
+	}
+	if _, err := Load(mem, "/patches"); err == nil {
+		t.Error("expected error for broken patch")
+	}
+	if _, err := Load(mem, "/missing"); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
@@ -0,0 +1,209 @@
+package php
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a PHP version reduced to its major, minor and patch numbers.
+type Version [3]int
+
+// ParseVersion reads the version at the start of s, e.g. 8.1.2 in
+// "8.1.2-1ubuntu2.14". Missing minor and patch numbers are zero.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	rest := strings.TrimPrefix(s, "v")
+	for i := range v {
+		end := 0
+		for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			if i == 0 {
+				return v, fmt.Errorf("invalid version %q", s)
+			}
+			break
+		}
+		n, err := strconv.Atoi(rest[:end])
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+		rest = rest[end:]
+		if !strings.HasPrefix(rest, ".") {
+			break
+		}
+		rest = rest[1:]
+	}
+	return v, nil
+}
+
+// String formats the version as major.minor.patch.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as or newer than w.
+func (v Version) Compare(w Version) int {
+	for i := range v {
+		switch {
+		case v[i] < w[i]:
+			return -1
+		case v[i] > w[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Constraint is a Composer version constraint, such as "^7.2.5 || ^8.0".
+type Constraint struct {
+	text string
+	any  [][]bound // Alternatives, each satisfied when all its bounds are
+}
+
+// bound is a single comparison against a version.
+type bound struct {
+	op      string // One of >=, >, <=, <, = or !=
+	version Version
+}
+
+// ParseConstraint parses a Composer version constraint. It understands
+// alternatives separated by || or |, conjunctions separated by spaces or
+// commas, the comparison operators, ^ and ~ ranges, wildcards such as 8.* and
+// exact versions. Stability flags and hyphen ranges are not supported.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{text: strings.TrimSpace(s)}
+	for alternative := range strings.SplitSeq(strings.ReplaceAll(s, "||", "|"), "|") {
+		var bounds []bound
+		fields := strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// An operator may be separated from its version, e.g. ">= 8.1".
+			if strings.Trim(field, "<>=!^~") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			parsed, err := parseBounds(field)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("invalid constraint %q: %w", s, err)
+			}
+			bounds = append(bounds, parsed...)
+		}
+		if len(bounds) == 0 {
+			return Constraint{}, fmt.Errorf("invalid constraint %q: empty alternative", s)
+		}
+		c.any = append(c.any, bounds)
+	}
+	return c, nil
+}
+
+// parseBounds translates a single constraint term into bounds.
+func parseBounds(term string) ([]bound, error) {
+	if term == "*" {
+		return []bound{{">=", Version{}}}, nil
+	}
+	if strings.ContainsAny(term, "@-") {
+		return nil, fmt.Errorf("unsupported term %q", term)
+	}
+
+	op := term[:len(term)-len(strings.TrimLeft(term, "<>=!^~"))]
+	text := strings.TrimPrefix(term[len(op):], "v")
+	parts := strings.Split(text, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version %q", text)
+	}
+
+	// A wildcard ends the version, e.g. 8.* or 8.1.*.
+	wildcard := parts[len(parts)-1] == "*"
+	if wildcard {
+		if op != "" || len(parts) == 1 {
+			return nil, fmt.Errorf("invalid wildcard %q", term)
+		}
+		parts = parts[:len(parts)-1]
+	}
+	var v Version
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", text)
+		}
+		v[i] = n
+	}
+
+	switch {
+	case wildcard:
+		return []bound{{">=", v}, {"<", bump(v, len(parts)-1)}}, nil
+	case op == "^":
+		// The first non-zero number may not change.
+		i := 0
+		for i < len(parts)-1 && v[i] == 0 {
+			i++
+		}
+		return []bound{{">=", v}, {"<", bump(v, i)}}, nil
+	case op == "~":
+		// The last given number may grow, or the minor one if only the major is given.
+		i := max(len(parts)-2, 0)
+		return []bound{{">=", v}, {"<", bump(v, i)}}, nil
+	case op == "" || op == "==":
+		if len(parts) < 3 {
+			// An incomplete version stands for all its releases, e.g. 8.1 for 8.1.*.
+			return []bound{{">=", v}, {"<", bump(v, len(parts)-1)}}, nil
+		}
+		return []bound{{"=", v}}, nil
+	case op == ">=" || op == ">" || op == "<=" || op == "<" || op == "=" || op == "!=":
+		return []bound{{op, v}}, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op)
+}
+
+// bump returns v with its number at index i incremented and the following
+// numbers zeroed.
+func bump(v Version, i int) Version {
+	v[i]++
+	for j := i + 1; j < len(v); j++ {
+		v[j] = 0
+	}
+	return v
+}
+
+// Allows reports whether v satisfies the constraint.
+func (c Constraint) Allows(v Version) bool {
+	for _, bounds := range c.any {
+		ok := true
+		for _, b := range bounds {
+			if !b.allows(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b bound) allows(v Version) bool {
+	cmp := v.Compare(b.version)
+	switch b.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// String returns the constraint as written.
+func (c Constraint) String() string {
+	return c.text
+}
@@ -0,0 +1,183 @@
+// Package php checks that the PHP installation serving phpMyAdmin meets the
+// requirements of a release, as declared by the composer.json it ships, and
+// checks the syntax of PHP files with it.
+package php
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// ComposerFile is the file at the root of a release declaring its requirements.
+const ComposerFile = "composer.json"
+
+// Requirements are the PHP version and extensions a release needs.
+type Requirements struct {
+	PHP        *Constraint // Supported PHP versions, nil when not declared
+	Extensions []string    // Required extensions, lowercase and sorted, e.g. "mysqli"
+}
+
+// ReadRequirements reads the requirements declared by the composer.json at
+// the root of the release at dir: the "php" entry and the "ext-*" entries of
+// its "require" section.
+func ReadRequirements(fsys fs.FS, dir string) (Requirements, error) {
+	path := filepath.Join(dir, ComposerFile)
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return Requirements{}, err
+	}
+	var composer struct {
+		Require map[string]string `json:"require"`
+	}
+	if err := json.Unmarshal(data, &composer); err != nil {
+		return Requirements{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var req Requirements
+	for name, constraint := range composer.Require {
+		name = strings.ToLower(name)
+		switch {
+		case name == "php":
+			c, err := ParseConstraint(constraint)
+			if err != nil {
+				return Requirements{}, fmt.Errorf("failed to parse PHP requirement of %s: %w", path, err)
+			}
+			req.PHP = &c
+		case strings.HasPrefix(name, "ext-"):
+			req.Extensions = append(req.Extensions, strings.TrimPrefix(name, "ext-"))
+		}
+	}
+	slices.Sort(req.Extensions)
+	return req, nil
+}
+
+// Host describes a PHP installation.
+type Host struct {
+	Binary     string   // PHP binary probed
+	Version    string   // Version as reported, e.g. "8.1.2-1ubuntu2.14"
+	Extensions []string // Loaded extensions, normalized like Composer's ext-* names
+}
+
+// Probe runs the PHP binary with -v and -m and returns the version and
+// extensions it reports.
+func Probe(ctx context.Context, binary string) (*Host, error) {
+	versionOutput, err := run(ctx, binary, "-v")
+	if err != nil {
+		return nil, err
+	}
+	version, err := parseVersionOutput(versionOutput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read version of %s: %w", binary, err)
+	}
+	modulesOutput, err := run(ctx, binary, "-m")
+	if err != nil {
+		return nil, err
+	}
+	return &Host{Binary: binary, Version: version, Extensions: parseModules(modulesOutput)}, nil
+}
+
+func run(ctx context.Context, binary string, arg string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, arg)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to run %s %s: %w: %s", binary, arg, err, msg)
+		}
+		return nil, fmt.Errorf("failed to run %s %s: %w", binary, arg, err)
+	}
+	return output, nil
+}
+
+// parseVersionOutput returns the version from the first line of php -v, e.g.
+// "PHP 8.1.2-1ubuntu2.14 (cli) (built: ...)".
+func parseVersionOutput(output []byte) (string, error) {
+	line, _, _ := strings.Cut(string(output), "\n")
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "PHP" {
+		return "", fmt.Errorf("unexpected output %q", line)
+	}
+	if _, err := ParseVersion(fields[1]); err != nil {
+		return "", err
+	}
+	return fields[1], nil
+}
+
+// parseModules returns the extensions listed by php -m, skipping its section
+// headers. Names are lowercased with spaces replaced by dashes, so that
+// "Zend OPcache" matches Composer's ext-zend-opcache.
+func parseModules(output []byte) []string {
+	var extensions []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		extensions = append(extensions, strings.ReplaceAll(strings.ToLower(line), " ", "-"))
+	}
+	slices.Sort(extensions)
+	return slices.Compact(extensions)
+}
+
+// Lint runs the PHP binary with -l to check the syntax of the file at path.
+// A file that does not parse yields an error quoting PHP's message.
+func Lint(ctx context.Context, binary, path string) error {
+	output, err := exec.CommandContext(ctx, binary, "-l", path).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || ctx.Err() != nil {
+		return fmt.Errorf("failed to run %s -l: %w", binary, err)
+	}
+
+	// Keep the error itself, dropping the summary and blank lines.
+	var messages []string
+	for line := range strings.Lines(string(output)) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "Errors parsing ") {
+			messages = append(messages, line)
+		}
+	}
+	if len(messages) == 0 {
+		return fmt.Errorf("%s -l failed: %w", binary, err)
+	}
+	return errors.New(strings.Join(messages, " "))
+}
+
+// Check returns the requirements host does not meet, each described in a
+// sentence; none when the release can run on it.
+func Check(req Requirements, host *Host) []string {
+	var problems []string
+	if req.PHP != nil {
+		version, err := ParseVersion(host.Version)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("PHP version %q cannot be compared with the required %s", host.Version, req.PHP))
+		} else if !req.PHP.Allows(version) {
+			problems = append(problems, fmt.Sprintf("PHP %s is installed, but %s is required", host.Version, req.PHP))
+		}
+	}
+
+	var missing []string
+	for _, ext := range req.Extensions {
+		if !slices.Contains(host.Extensions, ext) {
+			missing = append(missing, ext)
+		}
+	}
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("required PHP extensions are not loaded: %s", strings.Join(missing, ", ")))
+	}
+	return problems
+}
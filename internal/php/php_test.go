@@ -0,0 +1,215 @@
+package php
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		allowed    []string
+		refused    []string
+	}{
+		{"^7.2.5 || ^8.0", []string{"7.2.5", "7.4.33", "8.0.0", "8.3.1"}, []string{"7.2.4", "7.1.0", "9.0.0"}},
+		{"^0.3.1", []string{"0.3.1", "0.3.9"}, []string{"0.4.0", "0.3.0"}},
+		{"~8.1", []string{"8.1.0", "8.4.2"}, []string{"9.0.0", "8.0.30"}},
+		{"~8.1.2", []string{"8.1.2", "8.1.9"}, []string{"8.2.0"}},
+		{">= 7.4, <8.3", []string{"7.4.0", "8.2.99"}, []string{"7.3.33", "8.3.0"}},
+		{">=8.1 <8.2 | 8.3.*", []string{"8.1.5", "8.3.0"}, []string{"8.2.0", "8.4.0"}},
+		{"8.1", []string{"8.1.0", "8.1.30"}, []string{"8.2.0"}},
+		{"8.1.2", []string{"8.1.2"}, []string{"8.1.3"}},
+		{"!=8.1.2", []string{"8.1.3"}, []string{"8.1.2"}},
+		{"*", []string{"5.6.40", "8.4.0"}, nil},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("ParseConstraint(%q): %v", tt.constraint, err)
+			continue
+		}
+		for _, want := range []struct {
+			versions []string
+			allowed  bool
+		}{{tt.allowed, true}, {tt.refused, false}} {
+			for _, s := range want.versions {
+				v, err := ParseVersion(s)
+				if err != nil {
+					t.Fatalf("ParseVersion(%q): %v", s, err)
+				}
+				if got := c.Allows(v); got != want.allowed {
+					t.Errorf("%q allows %s: got %v, want %v", tt.constraint, s, got, want.allowed)
+				}
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", "^8.0 ||", "1.0 - 2.0", "^8.0@dev", "8.x", "=>8", "1.2.3.4"} {
+		if _, err := ParseConstraint(invalid); err == nil {
+			t.Errorf("expected error for constraint %q", invalid)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for s, want := range map[string]Version{
+		"8.1.2-1ubuntu2.14": {8, 1, 2},
+		"8.3.0RC1":          {8, 3, 0},
+		"7.4":               {7, 4, 0},
+		"v8":                {8, 0, 0},
+	} {
+		if got, err := ParseVersion(s); err != nil || got != want {
+			t.Errorf("ParseVersion(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseVersion("PHP"); err == nil {
+		t.Error("expected error for a version without numbers")
+	}
+}
+
+func TestReadRequirements(t *testing.T) {
+	mem := fs.NewMem()
+	if err := mem.MkdirAll("/release", 0755); err != nil {
+		t.Fatalf("failed to create release: %v", err)
+	}
+	composer := `{"require": {"php": "^7.2.5 || ^8.0", "ext-mysqli": "*", "ext-Iconv": "*", "twig/twig": "^3.3"},
+		"require-dev": {"ext-xdebug": "*"}}`
+	if err := fs.WriteFile(mem, "/release/composer.json", []byte(composer), 0644); err != nil {
+		t.Fatalf("failed to write composer.json: %v", err)
+	}
+
+	req, err := ReadRequirements(mem, "/release")
+	if err != nil {
+		t.Fatalf("ReadRequirements failed: %v", err)
+	}
+	if req.PHP == nil || req.PHP.String() != "^7.2.5 || ^8.0" {
+		t.Errorf("unexpected PHP requirement %v", req.PHP)
+	}
+	if want := []string{"iconv", "mysqli"}; !slices.Equal(req.Extensions, want) {
+		t.Errorf("expected extensions %v, got %v", want, req.Extensions)
+	}
+
+	if err := fs.WriteFile(mem, "/release/composer.json", []byte(`{"require": {"php": ">=8.1@dev"}}`), 0644); err != nil {
+		t.Fatalf("failed to write composer.json: %v", err)
+	}
+	if _, err := ReadRequirements(mem, "/release"); err == nil {
+		t.Error("expected error for an unsupported constraint")
+	}
+}
+
+const versionOutput = `PHP 7.4.33 (cli) (built: Nov  8 2022 11:33:53) ( NTS )
+Copyright (c) The PHP Group
+Zend Engine v3.4.0, Copyright (c) Zend Technologies
+`
+
+const modulesOutput = `[PHP Modules]
+Core
+iconv
+json
+mysqli
+Zend OPcache
+
+[Zend Modules]
+Zend OPcache
+`
+
+func TestCheck(t *testing.T) {
+	version, err := parseVersionOutput([]byte(versionOutput))
+	if err != nil || version != "7.4.33" {
+		t.Fatalf("unexpected version %q (%v)", version, err)
+	}
+	host := &Host{Binary: "php", Version: version, Extensions: parseModules([]byte(modulesOutput))}
+	if want := []string{"core", "iconv", "json", "mysqli", "zend-opcache"}; !slices.Equal(host.Extensions, want) {
+		t.Fatalf("expected extensions %v, got %v", want, host.Extensions)
+	}
+
+	compatible, err := ParseConstraint("^7.2.5 || ^8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := Check(Requirements{PHP: &compatible, Extensions: []string{"mysqli", "zend-opcache"}}, host); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	newer, err := ParseConstraint("^8.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := Check(Requirements{PHP: &newer, Extensions: []string{"mbstring", "mysqli", "xml"}}, host)
+	if len(problems) != 2 || !strings.Contains(problems[0], "PHP 7.4.33 is installed, but ^8.1 is required") ||
+		!strings.Contains(problems[1], "mbstring, xml") {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	if _, err := parseVersionOutput([]byte("Could not open input file\n")); err == nil {
+		t.Error("expected error for unexpected php -v output")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake PHP binary is a shell script")
+	}
+	binary := filepath.Join(t.TempDir(), "php")
+	script := "#!/bin/sh\nif [ \"$1\" = -v ]; then\ncat <<'EOF'\n" + versionOutput + "EOF\nelse\ncat <<'EOF'\n" + modulesOutput + "EOF\nfi\n"
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake PHP binary: %v", err)
+	}
+
+	host, err := Probe(context.Background(), binary)
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if host.Version != "7.4.33" || !slices.Contains(host.Extensions, "mysqli") {
+		t.Errorf("unexpected host %+v", host)
+	}
+
+	if _, err := Probe(context.Background(), filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for a missing binary")
+	}
+}
+
+func TestLint(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake PHP binary is a shell script")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "php")
+	script := `#!/bin/sh
+if grep -q 'syntax error' "$2"; then
+	echo "PHP Parse error:  syntax error, unexpected end of file in $2 on line 3"
+	echo
+	echo "Errors parsing $2"
+	exit 255
+fi
+echo "No syntax errors detected in $2"
+`
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake PHP binary: %v", err)
+	}
+	good, bad := filepath.Join(dir, "good.php"), filepath.Join(dir, "bad.php")
+	if err := os.WriteFile(good, []byte("<?php\n$cfg['x'] = 1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("<?php\n// syntax error\n$cfg['x'] = \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Lint(context.Background(), binary, good); err != nil {
+		t.Errorf("expected %s to pass, got %v", good, err)
+	}
+	err := Lint(context.Background(), binary, bad)
+	if want := "PHP Parse error:  syntax error, unexpected end of file in " + bad + " on line 3"; err == nil || err.Error() != want {
+		t.Errorf("expected error %q, got %v", want, err)
+	}
+	if err := Lint(context.Background(), filepath.Join(dir, "missing"), good); err == nil || !strings.Contains(err.Error(), "failed to run") {
+		t.Errorf("expected error for a missing binary, got %v", err)
+	}
+}
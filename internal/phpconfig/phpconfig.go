@@ -0,0 +1,431 @@
+// Package phpconfig reads the $cfg directives set by phpMyAdmin configuration
+// files, such as config.inc.php and libraries/config.default.php.
+//
+// It is not a PHP parser: it finds the statements assigning to $cfg, skipping
+// comments and string literals, which is all configuration files contain in
+// practice.
+package phpconfig
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AnyIndex is the key element standing for an index that is not a string
+// literal, e.g. the server number in $cfg['Servers'][$i]['host'].
+const AnyIndex = "*"
+
+// Key is the path of a directive, e.g. ["Servers", "*", "host"].
+type Key []string
+
+// String formats the key as PHP, e.g. $cfg['Servers'][$i]['host'].
+func (k Key) String() string {
+	var b strings.Builder
+	b.WriteString("$cfg")
+	for _, elem := range k {
+		if elem == AnyIndex {
+			b.WriteString("[$i]")
+		} else {
+			b.WriteString("[" + Quote(elem) + "]")
+		}
+	}
+	return b.String()
+}
+
+// id returns a string uniquely identifying the key, for map lookups.
+func (k Key) id() string {
+	return strings.Join(k, "\x00")
+}
+
+// Directive is one assignment to $cfg.
+type Directive struct {
+	Key        Key
+	Line       int    // Line of the assignment, from 1
+	Array      bool   // The assigned value is an array literal
+	Deprecated bool   // The doc comment of the assignment contains @deprecated
+	Expr       string // Source of the assigned value
+
+	start, end int // Offsets of the assigned value in the source
+}
+
+// Parse returns the assignments to $cfg in the PHP source src, in order.
+func Parse(src []byte) ([]Directive, error) {
+	l := &lexer{src: src, line: 1}
+	var directives []Directive
+	doc := ""
+	for l.pos < len(src) {
+		switch c := src[l.pos]; {
+		case c == '\n' || c == ' ' || c == '\t' || c == '\r':
+			l.advance(1)
+		case l.hasPrefix("/**"):
+			start := l.pos
+			if err := l.skipBlockComment(); err != nil {
+				return nil, err
+			}
+			doc = string(src[start:l.pos])
+		case l.hasPrefix("/*"):
+			if err := l.skipBlockComment(); err != nil {
+				return nil, err
+			}
+		case l.hasPrefix("//") || c == '#':
+			l.skipLineComment()
+		case c == '\'' || c == '"':
+			if _, _, err := l.string(); err != nil {
+				return nil, err
+			}
+		case c == ';':
+			doc = ""
+			l.advance(1)
+		case l.hasPrefix("$cfg") && !isIdentByte(l.peek(4)):
+			d, ok, err := l.assignment()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				d.Deprecated = strings.Contains(doc, "@deprecated")
+				directives = append(directives, d)
+			}
+			doc = ""
+		case c == '$' || isIdentByte(c):
+			// Skip whole identifiers, so that e.g. $mycfg is not read as $cfg.
+			l.advance(1)
+			for l.pos < len(src) && isIdentByte(src[l.pos]) {
+				l.advance(1)
+			}
+		default:
+			l.advance(1)
+		}
+	}
+	return directives, nil
+}
+
+// lexer scans PHP source.
+type lexer struct {
+	src  []byte
+	pos  int
+	line int
+}
+
+func (l *lexer) advance(n int) {
+	end := min(l.pos+n, len(l.src))
+	l.line += bytes.Count(l.src[l.pos:end], []byte{'\n'})
+	l.pos = end
+}
+
+func (l *lexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.src[l.pos:], []byte(s))
+}
+
+// peek returns the byte n bytes ahead, or 0 past the end.
+func (l *lexer) peek(n int) byte {
+	if l.pos+n < len(l.src) {
+		return l.src[l.pos+n]
+	}
+	return 0
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+func (l *lexer) skipBlockComment() error {
+	end := bytes.Index(l.src[l.pos+2:], []byte("*/"))
+	if end < 0 {
+		return l.errorf("unterminated comment")
+	}
+	l.advance(end + 4)
+	return nil
+}
+
+func (l *lexer) skipLineComment() {
+	end := bytes.IndexByte(l.src[l.pos:], '\n')
+	if end < 0 {
+		end = len(l.src) - l.pos
+	}
+	l.advance(end)
+}
+
+// skipSpace skips whitespace and comments.
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\n' || c == ' ' || c == '\t' || c == '\r':
+			l.advance(1)
+		case l.hasPrefix("/*"):
+			if err := l.skipBlockComment(); err != nil {
+				return err
+			}
+		case l.hasPrefix("//") || c == '#':
+			l.skipLineComment()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// string reads the string literal at the current position and returns its
+// value, and whether it is a double-quoted string interpolating variables.
+func (l *lexer) string() (string, bool, error) {
+	quote := l.src[l.pos]
+	var value strings.Builder
+	interpolated := false
+	for i := l.pos + 1; i < len(l.src); i++ {
+		c := l.src[i]
+		switch {
+		case c == quote:
+			l.advance(i + 1 - l.pos)
+			return value.String(), interpolated, nil
+		case c == '\\' && i+1 < len(l.src):
+			next := l.src[i+1]
+			switch {
+			case next == quote || next == '\\':
+				value.WriteByte(next)
+				i++
+			case quote == '"' && next == '$':
+				value.WriteByte('$')
+				i++
+			default:
+				value.WriteByte(c)
+			}
+		case c == '$' && quote == '"':
+			interpolated = true
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", false, l.errorf("unterminated string")
+}
+
+// assignment reads the statement starting with $cfg at the current position
+// and reports whether it assigns to a $cfg element. The statement is consumed
+// up to its terminating semicolon.
+func (l *lexer) assignment() (Directive, bool, error) {
+	d := Directive{Line: l.line}
+	l.advance(len("$cfg"))
+
+	for {
+		if err := l.skipSpace(); err != nil {
+			return d, false, err
+		}
+		if l.pos >= len(l.src) || l.src[l.pos] != '[' {
+			break
+		}
+		elem, err := l.index()
+		if err != nil {
+			return d, false, err
+		}
+		d.Key = append(d.Key, elem)
+	}
+
+	// Only plain assignments define directives, not comparisons, compound
+	// assignments or reads.
+	if len(d.Key) == 0 || l.pos >= len(l.src) || l.src[l.pos] != '=' || l.peek(1) == '=' || l.peek(1) == '>' {
+		return d, false, nil
+	}
+	l.advance(1)
+	if err := l.skipSpace(); err != nil {
+		return d, false, err
+	}
+	d.Array = l.hasPrefix("[") || bytes.HasPrefix(bytes.ToLower(l.src[l.pos:]), []byte("array"))
+	d.start = l.pos
+	if err := l.skipStatement(); err != nil {
+		return d, false, err
+	}
+	d.end = l.pos - 1
+	for d.end > d.start && isSpace(l.src[d.end-1]) {
+		d.end--
+	}
+	d.Expr = string(l.src[d.start:d.end])
+	return d, true, nil
+}
+
+// index reads the bracketed index at the current position and returns its
+// value: the string literal, or AnyIndex for anything else.
+func (l *lexer) index() (string, error) {
+	start := l.line
+	l.advance(1)
+	if err := l.skipSpace(); err != nil {
+		return "", err
+	}
+
+	elem, literal := AnyIndex, false
+	if l.pos < len(l.src) && (l.src[l.pos] == '\'' || l.src[l.pos] == '"') {
+		value, interpolated, err := l.string()
+		if err != nil {
+			return "", err
+		}
+		if err := l.skipSpace(); err != nil {
+			return "", err
+		}
+		elem, literal = value, !interpolated
+	}
+
+	for depth := 0; l.pos < len(l.src); {
+		switch c := l.src[l.pos]; {
+		case c == '\'' || c == '"':
+			if _, _, err := l.string(); err != nil {
+				return "", err
+			}
+			literal = false
+			continue
+		case c == '[' || c == '(':
+			depth++
+		case (c == ']' || c == ')') && depth > 0:
+			depth--
+		case c == ']':
+			l.advance(1)
+			if !literal {
+				elem = AnyIndex
+			}
+			return elem, nil
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			// An expression such as 'a' . $b.
+			literal = false
+		}
+		l.advance(1)
+	}
+	return "", fmt.Errorf("line %d: unterminated index", start)
+}
+
+// skipStatement skips to just past the semicolon ending the current
+// statement, outside of brackets, strings and comments.
+func (l *lexer) skipStatement() error {
+	depth := 0
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == '\'' || c == '"':
+			if _, _, err := l.string(); err != nil {
+				return err
+			}
+			continue
+		case l.hasPrefix("/*"):
+			if err := l.skipBlockComment(); err != nil {
+				return err
+			}
+			continue
+		case l.hasPrefix("//") || c == '#':
+			l.skipLineComment()
+			continue
+		case c == '[' || c == '(' || c == '{':
+			depth++
+		case c == ']' || c == ')' || c == '}':
+			depth--
+		case c == ';' && depth <= 0:
+			l.advance(1)
+			return nil
+		}
+		l.advance(1)
+	}
+	return l.errorf("missing semicolon")
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// Report compares the directives of a configuration file with the defaults
+// of a phpMyAdmin release.
+type Report struct {
+	Unknown    []Directive // Set by the configuration but not defined by either release
+	Deprecated []Directive // Set by the configuration and marked deprecated by the new release
+	Dropped    []Directive // Set by the configuration, defined by the installed release but not the new one
+	New        []Key       // Defined by the new release but not the installed one
+}
+
+// Empty reports whether there is nothing to report.
+func (r Report) Empty() bool {
+	return len(r.Unknown) == 0 && len(r.Deprecated) == 0 && len(r.Dropped) == 0 && len(r.New) == 0
+}
+
+// Compare checks the directives set by a configuration file against the
+// defaults of the new release and, when known, of the installed one. A
+// directive within an array default that lists no elements, such as a rule
+// appended to $cfg['Servers'][$i]['AllowDeny']['rules'], counts as defined,
+// and so does a whole array assigned over several defined directives.
+func Compare(config, installedDefaults, newDefaults []Directive) Report {
+	installed, latest := newDefinitions(installedDefaults), newDefinitions(newDefaults)
+
+	var r Report
+	for _, d := range config {
+		if def, ok := latest.lookup(d.Key); ok {
+			if def.Deprecated {
+				r.Deprecated = append(r.Deprecated, d)
+			}
+			continue
+		}
+		if _, ok := installed.lookup(d.Key); ok {
+			r.Dropped = append(r.Dropped, d)
+			continue
+		}
+		r.Unknown = append(r.Unknown, d)
+	}
+
+	if len(installedDefaults) > 0 {
+		for _, d := range newDefaults {
+			if _, ok := installed.byID[d.Key.id()]; !ok && !slices.ContainsFunc(r.New, func(k Key) bool { return k.id() == d.Key.id() }) {
+				r.New = append(r.New, d.Key)
+			}
+		}
+	}
+	return r
+}
+
+// definitions indexes default directives.
+type definitions struct {
+	byID     map[string]Directive
+	prefixes map[string]bool // Proper prefixes of the defined keys
+}
+
+func newDefinitions(defaults []Directive) definitions {
+	defs := definitions{byID: map[string]Directive{}, prefixes: map[string]bool{}}
+	for _, d := range defaults {
+		defs.byID[d.Key.id()] = d
+		for i := 1; i < len(d.Key); i++ {
+			defs.prefixes[d.Key[:i].id()] = true
+		}
+	}
+	return defs
+}
+
+// lookup returns the default directive defining key.
+func (defs definitions) lookup(key Key) (Directive, bool) {
+	if d, ok := defs.byID[key.id()]; ok {
+		return d, true
+	}
+	if defs.prefixes[key.id()] {
+		return Directive{Key: key}, true
+	}
+	for i := len(key) - 1; i > 0; i-- {
+		prefix := key[:i].id()
+		if d, ok := defs.byID[prefix]; ok && d.Array && !defs.prefixes[prefix] {
+			return d, true
+		}
+	}
+	return Directive{}, false
+}
+
+// Describe formats the report, one directive per line.
+func (r Report) Describe() string {
+	var lines []string
+	for _, d := range r.Unknown {
+		lines = append(lines, "unknown:    "+d.Key.String()+" (line "+strconv.Itoa(d.Line)+")")
+	}
+	for _, d := range r.Deprecated {
+		lines = append(lines, "deprecated: "+d.Key.String()+" (line "+strconv.Itoa(d.Line)+")")
+	}
+	for _, d := range r.Dropped {
+		lines = append(lines, "removed:    "+d.Key.String()+" (line "+strconv.Itoa(d.Line)+"), no longer supported by the new release")
+	}
+	for _, k := range r.New {
+		lines = append(lines, "new:        "+k.String())
+	}
+	return strings.Join(lines, "\n")
+}
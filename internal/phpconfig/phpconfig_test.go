@@ -0,0 +1,252 @@
+package phpconfig
+
+import (
+	"slices"
+	"testing"
+)
+
+const defaultsPHP = `<?php
+/**
+ * Default configuration
+ */
+
+/**
+ * Your phpMyAdmin URL.
+ *
+ * @global string $cfg['PmaAbsoluteUri']
+ */
+$cfg['PmaAbsoluteUri'] = '';
+
+$i = 1;
+
+/**
+ * MySQL hostname or IP address
+ */
+$cfg['Servers'][$i]['host'] = 'localhost';
+
+/**
+ * Host authentication rules, [] = no rules
+ */
+$cfg['Servers'][$i]['AllowDeny']['rules'] = [];
+
+/**
+ * Whether to show "ShowChgPassword"; the ; in here ends nothing.
+ *
+ * @deprecated 5.2.0 Use $cfg['ShowChgPassword'] instead.
+ */
+$cfg['ShowChgPassword'] = true;
+
+$cfg['Export'] = [];
+$cfg['Export']['format'] = 'sql';
+$cfg['Export']['sql_structure_or_data'] = 'structure_and_data';
+
+// $cfg['Commented'] = 'out';
+# $cfg['AlsoCommented'] = 'out';
+/* $cfg['BlockCommented'] = 'out'; */
+$cfg['DefaultFunctions'] = array(
+    'FUNC_CHAR' => '',
+    'first_timestamp' => 'NOW',
+);
+$cfg["Double\"Quoted"] = "a;b";
+`
+
+func keys(directives []Directive) []string {
+	var names []string
+	for _, d := range directives {
+		names = append(names, d.Key.String())
+	}
+	return names
+}
+
+func TestParse(t *testing.T) {
+	directives, err := Parse([]byte(defaultsPHP))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	want := []string{
+		"$cfg['PmaAbsoluteUri']",
+		"$cfg['Servers'][$i]['host']",
+		"$cfg['Servers'][$i]['AllowDeny']['rules']",
+		"$cfg['ShowChgPassword']",
+		"$cfg['Export']",
+		"$cfg['Export']['format']",
+		"$cfg['Export']['sql_structure_or_data']",
+		"$cfg['DefaultFunctions']",
+		`$cfg['Double"Quoted']`,
+	}
+	if got := keys(directives); !slices.Equal(got, want) {
+		t.Fatalf("unexpected directives:\n got %q\nwant %q", got, want)
+	}
+
+	if d := directives[0]; d.Line != 11 || d.Array || d.Deprecated {
+		t.Errorf("unexpected directive: %+v", d)
+	}
+	if d := directives[3]; !d.Deprecated {
+		t.Errorf("expected %s deprecated", d.Key)
+	}
+	for _, i := range []int{2, 4, 7} {
+		if !directives[i].Array {
+			t.Errorf("expected %s to be an array", directives[i].Key)
+		}
+	}
+}
+
+func TestParse_Indexes(t *testing.T) {
+	src := `<?php
+$i = 0;
+$i++;
+$cfg['Servers'][$i]['host'] = 'db1';
+$cfg['Servers'][2]['host'] = 'db2';
+$cfg['Servers'][$i]['AllowDeny']['rules'][] = 'allow root from all';
+$cfg [ 'Lang' ] /* spaced */ = 'en';
+$cfg['Prefix' . $i] = 1;
+$cfg["Server$i"] = 1;
+if ($cfg['Lang'] == 'en') { $mycfg['Skipped'] = 1; }
+$cfg['Theme'] .= 'compound';
+`
+	directives, err := Parse([]byte(src))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := []string{
+		"$cfg['Servers'][$i]['host']",
+		"$cfg['Servers'][$i]['host']",
+		"$cfg['Servers'][$i]['AllowDeny']['rules'][$i]",
+		"$cfg['Lang']",
+		"$cfg[$i]",
+		"$cfg[$i]",
+	}
+	if got := keys(directives); !slices.Equal(got, want) {
+		t.Errorf("unexpected directives:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for name, src := range map[string]string{
+		"unterminated string":  "<?php\n$cfg['A'] = 'abc;\n",
+		"unterminated comment": "<?php\n/* $cfg['A'] = 1;\n",
+		"unterminated index":   "<?php\n$cfg['A'",
+		"missing semicolon":    "<?php\n$cfg['A'] = 1\n",
+	} {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	installed, err := Parse([]byte(defaultsPHP + "$cfg['ShowPhpInfo'] = false;\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	latest, err := Parse([]byte(defaultsPHP + "$cfg['ShowGitRevision'] = true;\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	config, err := Parse([]byte(`<?php
+$cfg['blowfish_secret'] = 'x';
+$i = 1;
+$cfg['Servers'][$i]['host'] = 'db';
+$cfg['Servers'][$i]['AllowDeny']['rules'][] = 'deny % from all';
+$cfg['Servers'][$i]['hots'] = 'typo';
+$cfg['Export'] = ['format' => 'csv'];
+$cfg['Export']['fromat'] = 'csv';
+$cfg['DefaultFunctions']['FUNC_CHAR'] = 'UUID';
+$cfg['ShowChgPassword'] = false;
+$cfg['ShowPhpInfo'] = true;
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	report := Compare(config, installed, latest)
+	if got := keys(report.Unknown); !slices.Equal(got, []string{
+		"$cfg['blowfish_secret']", "$cfg['Servers'][$i]['hots']", "$cfg['Export']['fromat']",
+	}) {
+		t.Errorf("unexpected unknown directives: %q", got)
+	}
+	if got := keys(report.Deprecated); !slices.Equal(got, []string{"$cfg['ShowChgPassword']"}) {
+		t.Errorf("unexpected deprecated directives: %q", got)
+	}
+	if got := keys(report.Dropped); !slices.Equal(got, []string{"$cfg['ShowPhpInfo']"}) {
+		t.Errorf("unexpected dropped directives: %q", got)
+	}
+	if len(report.New) != 1 || report.New[0].String() != "$cfg['ShowGitRevision']" {
+		t.Errorf("unexpected new directives: %v", report.New)
+	}
+	if report.Empty() {
+		t.Error("expected report not empty")
+	}
+
+	// Without the installed defaults, nothing is new and dropped directives
+	// are unknown.
+	report = Compare(config, nil, latest)
+	if len(report.New) != 0 || len(report.Dropped) != 0 || len(report.Unknown) != 4 {
+		t.Errorf("unexpected report without installed defaults: %+v", report)
+	}
+}
+
+func TestStringValue(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+		ok   bool
+	}{
+		{`'abc'`, "abc", true},
+		{`"a\"b"`, `a"b`, true},
+		{`'it\'s'`, "it's", true},
+		{`sodium_hex2bin('6162')`, "ab", true},
+		{`hex2bin( "6162" )`, "ab", true},
+		{`sodium_hex2bin('zz')`, "", false},
+		{`"secret$suffix"`, "", false},
+		{`'a' . 'b'`, "", false},
+		{`getenv('PMA_SECRET')`, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := Directive{Expr: tt.expr}.StringValue()
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("StringValue(%s) = %q, %v; want %q, %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSetString(t *testing.T) {
+	key := Key{"blowfish_secret"}
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			"replaces last assignment",
+			"<?php\n$cfg['blowfish_secret'] = 'a';\n$cfg['blowfish_secret'] = sodium_hex2bin('6162') ; // old\n",
+			"<?php\n$cfg['blowfish_secret'] = 'a';\n$cfg['blowfish_secret'] = 'it\\'s' ; // old\n",
+		},
+		{
+			"appends",
+			"<?php\n$cfg['Lang'] = 'en';",
+			"<?php\n$cfg['Lang'] = 'en';\n$cfg['blowfish_secret'] = 'it\\'s';\n",
+		},
+		{
+			"inserts before closing tag",
+			"<?php\n$cfg['Lang'] = 'en';\n?>\n",
+			"<?php\n$cfg['Lang'] = 'en';\n$cfg['blowfish_secret'] = 'it\\'s';\n?>\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SetString([]byte(tt.src), key, "it's")
+			if err != nil {
+				t.Fatalf("SetString failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := SetString([]byte("<?php\n$cfg['A'] = 'x"), key, "s"); err == nil {
+		t.Error("expected error for invalid source")
+	}
+}
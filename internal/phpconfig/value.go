@@ -0,0 +1,89 @@
+package phpconfig
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+)
+
+// Quote returns s as a single-quoted PHP string literal.
+func Quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// StringValue returns the string assigned by d, and false when the value is
+// not a constant string. Besides string literals, it understands the
+// sodium_hex2bin('...') and hex2bin('...') calls phpMyAdmin suggests for
+// binary secrets.
+func (d Directive) StringValue() (string, bool) {
+	l := &lexer{src: []byte(d.Expr), line: d.Line}
+
+	decode := false
+	for _, fn := range []string{"sodium_hex2bin", "hex2bin"} {
+		if len(l.src) > len(fn) && strings.EqualFold(string(l.src[:len(fn)]), fn) && !isIdentByte(l.src[len(fn)]) {
+			l.advance(len(fn))
+			if l.skipSpace() != nil || !l.hasPrefix("(") {
+				return "", false
+			}
+			l.advance(1)
+			decode = true
+			break
+		}
+	}
+
+	if l.skipSpace() != nil || l.pos >= len(l.src) || (l.src[l.pos] != '\'' && l.src[l.pos] != '"') {
+		return "", false
+	}
+	value, interpolated, err := l.string()
+	if err != nil || interpolated || l.skipSpace() != nil {
+		return "", false
+	}
+	if decode {
+		if !l.hasPrefix(")") {
+			return "", false
+		}
+		l.advance(1)
+		decoded, err := hex.DecodeString(value)
+		if err != nil || l.skipSpace() != nil {
+			return "", false
+		}
+		value = string(decoded)
+	}
+	if l.pos != len(l.src) {
+		return "", false
+	}
+	return value, true
+}
+
+// SetString returns the PHP source src with the last assignment to key set to
+// the string literal of value. Without such an assignment, one is added at the
+// end of the script.
+func SetString(src []byte, key Key, value string) ([]byte, error) {
+	directives, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(directives) - 1; i >= 0; i-- {
+		if d := directives[i]; d.Key.id() == key.id() {
+			return concat(src[:d.start], []byte(Quote(value)), src[d.end:]), nil
+		}
+	}
+
+	// Insert before a closing tag ending the file, if any.
+	end := len(bytes.TrimRight(src, " \t\r\n"))
+	if !bytes.HasSuffix(src[:end], []byte("?>")) {
+		end = len(src)
+	} else {
+		end -= len("?>")
+	}
+	statement := key.String() + " = " + Quote(value) + ";\n"
+	if end > 0 && src[end-1] != '\n' {
+		statement = "\n" + statement
+	}
+	return concat(src[:end], []byte(statement), src[end:]), nil
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
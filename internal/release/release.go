@@ -0,0 +1,148 @@
+// Package release manages the Capistrano-style installation layout.
+//
+// In this layout every phpMyAdmin release lives in its own directory below a
+// releases root, and the installation path is a symlink pointing at the
+// active release. Switching, rolling back and pruning releases never leaves
+// the installation path missing: the symlink is replaced atomically.
+package release
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// nameTimeFormat is the timestamp prefix of release directory names.
+// Lexical order of names matches chronological order.
+const nameTimeFormat = "20060102150405"
+
+// Layout describes a releases root and the symlink that selects the active release.
+type Layout struct {
+	FS   fs.FS  // Filesystem holding the releases
+	Root string // Directory holding one subdirectory per release
+	Link string // Symlink pointing at the active release
+}
+
+// DefaultRoot returns the default releases root for the installation path link,
+// a sibling directory named after it (e.g. /var/www/phpmyadmin-releases).
+func DefaultRoot(link string) string {
+	return filepath.Clean(link) + "-releases"
+}
+
+// NewName returns the directory name for a release of phpMyAdmin version installed at t.
+func NewName(version string, t time.Time) string {
+	return fmt.Sprintf("%s-%s", t.UTC().Format(nameTimeFormat), version)
+}
+
+// Path returns the full path of the release called name.
+func (l *Layout) Path(name string) string {
+	return filepath.Join(l.Root, name)
+}
+
+// Releases returns the names of all releases, oldest first.
+// Hidden directories are ignored.
+func (l *Layout) Releases() ([]string, error) {
+	entries, err := l.FS.ReadDir(l.Root)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read releases directory: %w", err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		// Hidden entries are staging directories, not releases.
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Current returns the name of the active release.
+//
+// It returns an empty name and no error when the link does not exist yet.
+func (l *Layout) Current() (string, error) {
+	target, err := l.FS.Readlink(l.Link)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read release symlink: %w", err)
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(l.Link), target)
+	}
+	if filepath.Dir(filepath.Clean(target)) != filepath.Clean(l.Root) {
+		return "", fmt.Errorf("symlink %s points outside releases directory: %s", l.Link, target)
+	}
+	return filepath.Base(target), nil
+}
+
+// Activate atomically points the installation symlink at the release called name.
+func (l *Layout) Activate(name string) error {
+	releasePath := l.Path(name)
+	info, err := l.FS.Stat(releasePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat release: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("release is not a directory: %s", releasePath)
+	}
+
+	absPath, err := filepath.Abs(releasePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve release path: %w", err)
+	}
+
+	if err := fs.ReplaceSymlink(l.FS, absPath, l.Link); err != nil {
+		return fmt.Errorf("failed to activate release %s: %w", name, err)
+	}
+	return nil
+}
+
+// Prune removes the oldest releases so that at most keep releases remain.
+//
+// The active release is never removed. A keep value of zero or less disables
+// pruning. It returns the names of the removed releases.
+func (l *Layout) Prune(keep int) ([]string, error) {
+	removed := []string{}
+	if keep <= 0 {
+		return removed, nil
+	}
+
+	current, err := l.Current()
+	if err != nil {
+		return removed, err
+	}
+
+	names, err := l.Releases()
+	if err != nil {
+		return removed, err
+	}
+
+	excess := len(names) - keep
+	for _, name := range names {
+		if excess <= 0 {
+			break
+		}
+		if name == current {
+			continue
+		}
+		if err := l.FS.RemoveAll(l.Path(name)); err != nil {
+			return removed, fmt.Errorf("failed to remove release %s: %w", name, err)
+		}
+		removed = append(removed, name)
+		excess--
+	}
+	return removed, nil
+}
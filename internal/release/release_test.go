@@ -0,0 +1,111 @@
+package release
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+func newTestLayout(t *testing.T, names ...string) *Layout {
+	tempDir := t.TempDir()
+	layout := &Layout{FS: fs.OS{}, Root: filepath.Join(tempDir, "phpmyadmin-releases"), Link: filepath.Join(tempDir, "phpmyadmin")}
+	for _, name := range names {
+		if err := os.MkdirAll(layout.Path(name), 0755); err != nil {
+			t.Fatalf("failed to create release %s: %v", name, err)
+		}
+	}
+	return layout
+}
+
+func TestNewName(t *testing.T) {
+	got := NewName("5.2.2", time.Date(2025, 1, 21, 3, 4, 5, 0, time.UTC))
+	if got != "20250121030405-5.2.2" {
+		t.Errorf("unexpected release name: %s", got)
+	}
+	if DefaultRoot("/var/www/phpmyadmin/") != "/var/www/phpmyadmin-releases" {
+		t.Errorf("unexpected default root: %s", DefaultRoot("/var/www/phpmyadmin/"))
+	}
+}
+
+func TestLayout_Activate(t *testing.T) {
+	layout := newTestLayout(t, "20250101000000-5.2.1", "20250201000000-5.2.2")
+
+	current, err := layout.Current()
+	if err != nil || current != "" {
+		t.Fatalf("expected no current release, got %q (%v)", current, err)
+	}
+
+	if err := layout.Activate("20250101000000-5.2.1"); err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+	if err := layout.Activate("20250201000000-5.2.2"); err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+
+	current, err = layout.Current()
+	if err != nil || current != "20250201000000-5.2.2" {
+		t.Fatalf("unexpected current release %q (%v)", current, err)
+	}
+
+	// Switching back to an older release is just another activation.
+	if err := layout.Activate("20250101000000-5.2.1"); err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+	current, err = layout.Current()
+	if err != nil || current != "20250101000000-5.2.1" {
+		t.Errorf("unexpected current release %q (%v)", current, err)
+	}
+
+	if err := layout.Activate("missing"); err == nil {
+		t.Errorf("expected error when activating a missing release")
+	}
+}
+
+func TestLayout_CurrentOutsideRoot(t *testing.T) {
+	layout := newTestLayout(t)
+	if err := os.Symlink(t.TempDir(), layout.Link); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if _, err := layout.Current(); err == nil {
+		t.Errorf("expected error for symlink outside releases root")
+	}
+}
+
+func TestLayout_Prune(t *testing.T) {
+	names := []string{
+		"20250101000000-5.2.0",
+		"20250201000000-5.2.1",
+		"20250301000000-5.2.2",
+		"20250401000000-5.2.3",
+	}
+	layout := newTestLayout(t, names...)
+
+	// Keep the oldest release active to verify it is never pruned.
+	if err := layout.Activate(names[0]); err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+
+	removed, err := layout.Prune(2)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != names[1] || removed[1] != names[2] {
+		t.Errorf("unexpected pruned releases: %v", removed)
+	}
+
+	remaining, err := layout.Releases()
+	if err != nil {
+		t.Fatalf("Releases failed: %v", err)
+	}
+	if len(remaining) != 2 || remaining[0] != names[0] || remaining[1] != names[3] {
+		t.Errorf("unexpected remaining releases: %v", remaining)
+	}
+
+	removed, err = layout.Prune(0)
+	if err != nil || len(removed) != 0 {
+		t.Errorf("expected pruning to be disabled, removed %v (%v)", removed, err)
+	}
+}
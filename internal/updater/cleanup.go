@@ -0,0 +1,180 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/lock"
+)
+
+// DefaultOrphanAge is how long a directory left by an earlier run must have
+// been untouched before it is removed, when no age is configured.
+const DefaultOrphanAge = time.Hour
+
+// workDirLockName names the lock file a run holds inside each of its
+// temporary and staging directories for as long as it uses them.
+const workDirLockName = ".pma-up.lock"
+
+var (
+	// tempDirPattern matches the temporary directories runs download into.
+	tempDirPattern = regexp.MustCompile(`^pma-up-\d+$`)
+
+	// stagingDirPattern matches the staging directories runs extract into and
+	// the staging and trash directories of cross-device moves (see fs.MoveDir),
+	// all of which hold a work directory lock while in use.
+	stagingDirPattern = regexp.MustCompile(`^\..+\.pma-up-(staging|trash)-\d+$`)
+)
+
+// Cleanup removes the temporary and staging directories left behind by
+// earlier runs that were killed before removing them.
+//
+// Like Run, it first locks the destination and finishes or rolls back an
+// interrupted update, which needs some of those directories. It then removes
+// every directory of an earlier run, in the temporary directory and next to
+// the installation, that has been untouched for opts.OrphanAge and that no
+// running update holds.
+//
+// Parameters:
+//   - ctx: cancels waiting for the destination lock.
+//   - opts: update options locating the installation; see Options for defaults.
+//
+// Returns:
+//   - error: non-nil if the destination cannot be locked or an interrupted
+//     update cannot be recovered.
+func Cleanup(ctx context.Context, opts Options) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+
+	runLock, err := lock.Acquire(ctx, opts.FS, lock.Path(opts.DestinationPath), opts.LockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock destination: %w", err)
+	}
+	defer func() {
+		if releaseErr := runLock.Release(); releaseErr != nil {
+			fmt.Printf("warning: failed to release lock: %v\n", releaseErr)
+		}
+	}()
+
+	if err := recoverInterrupted(context.WithoutCancel(ctx), opts, journal.Path(opts.DestinationPath)); err != nil {
+		return fmt.Errorf("failed to recover interrupted update: %w", err)
+	}
+
+	if removeOrphans(ctx, opts) == 0 {
+		fmt.Println("No directories left by earlier runs found")
+	}
+	return nil
+}
+
+// removeOrphans removes the directories left behind by earlier runs and
+// returns how many it removed. Failures are only reported as warnings.
+func removeOrphans(ctx context.Context, opts Options) int {
+	if opts.OrphanAge < 0 {
+		return 0
+	}
+
+	removed := 0
+	for _, dir := range orphanSearchDirs(opts) {
+		pattern := stagingDirPattern
+		if dir == fs.TempDir(opts.FS) {
+			pattern = tempDirPattern
+		}
+
+		entries, err := opts.FS.ReadDir(dir)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("warning: failed to look for directories left by earlier runs in %s: %v\n", dir, err)
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if ctx.Err() != nil {
+				return removed
+			}
+			if entry.IsDir() && pattern.MatchString(entry.Name()) && removeOrphan(ctx, opts, filepath.Join(dir, entry.Name())) {
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// orphanSearchDirs returns the directories runs create temporary and staging
+// directories in: the temporary directory, the directory holding the
+// installation or its symlink target, the releases directory and the
+// configured staging directory.
+func orphanSearchDirs(opts Options) []string {
+	dirs := []string{fs.TempDir(opts.FS), filepath.Dir(opts.DestinationPath), opts.ReleasesDir}
+	if target, err := fs.EvalSymlinks(opts.FS, opts.DestinationPath); err == nil {
+		dirs = append(dirs, filepath.Dir(target))
+	}
+	if opts.StagingDir != "" {
+		dirs = append(dirs, opts.StagingDir)
+	}
+
+	for i, dir := range dirs {
+		dirs[i] = filepath.Clean(dir)
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// removeOrphan removes the directory at path unless it was modified within
+// opts.OrphanAge or a running update holds its lock file.
+func removeOrphan(ctx context.Context, opts Options, path string) bool {
+	info, err := opts.FS.Lstat(path)
+	if err != nil {
+		fmt.Printf("warning: failed to stat %s: %v\n", path, err)
+		return false
+	}
+	age := time.Since(info.ModTime())
+	if age < opts.OrphanAge {
+		return false
+	}
+
+	dirLock, err := lock.Acquire(ctx, opts.FS, filepath.Join(path, workDirLockName), 0)
+	var heldErr *lock.HeldError
+	if errors.As(err, &heldErr) {
+		return false
+	}
+	if err != nil {
+		fmt.Printf("warning: failed to check whether %s is in use: %v\n", path, err)
+		return false
+	}
+	if err := dirLock.Release(); err != nil {
+		fmt.Printf("warning: failed to release lock: %v\n", err)
+	}
+
+	if err := opts.FS.RemoveAll(path); err != nil {
+		fmt.Printf("warning: failed to remove %s left by an earlier run: %v\n", path, err)
+		return false
+	}
+	fmt.Printf("Removed %s left by an earlier run (untouched for %s)\n", path, age.Round(time.Second))
+	return true
+}
+
+// lockWorkDir locks the temporary or staging directory dir for the running
+// update, so that no other run mistakes it for a leftover and removes it.
+func lockWorkDir(ctx context.Context, fsys fs.FS, dir string) (*lock.Lock, error) {
+	dirLock, err := lock.Acquire(ctx, fsys, filepath.Join(dir, workDirLockName), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", dir, err)
+	}
+	return dirLock, nil
+}
+
+// releaseWorkDir releases the lock taken by lockWorkDir.
+func releaseWorkDir(dirLock *lock.Lock) {
+	if err := dirLock.Release(); err != nil {
+		fmt.Printf("warning: failed to release lock: %v\n", err)
+	}
+}
@@ -0,0 +1,206 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/inventory"
+)
+
+// ConfigMode selects how a configuration file kept outside the installation
+// is made available to a new release.
+type ConfigMode string
+
+const (
+	// ConfigCopy copies the configuration file into the new release.
+	ConfigCopy ConfigMode = "copy"
+
+	// ConfigSymlink creates config.inc.php in the new release as a symlink to
+	// the configuration file.
+	ConfigSymlink ConfigMode = "symlink"
+
+	// ConfigInclude creates config.inc.php in the new release as a stub that
+	// includes the configuration file.
+	ConfigInclude ConfigMode = "include"
+)
+
+// configName is the file phpMyAdmin loads its configuration from, at the
+// root of the installation.
+const configName = "config.inc.php"
+
+// configRelPath returns the path of the configuration file relative to the
+// installation, and false when the file lives outside of it.
+func (opts Options) configRelPath() (string, bool) {
+	rel, err := filepath.Rel(absPath(opts.DestinationPath), absPath(opts.ConfigFilePath))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// absPath returns path made absolute, or path itself if that fails.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// checkConfig makes sure the configuration file exists, or that the inventory
+// renders, before anything is moved, so that an update never goes live
+// without it. A fresh installation creates a missing configuration file, from
+// opts.ConfigTemplate when set, which must then exist.
+func checkConfig(opts Options) error {
+	if opts.Inventory != "" {
+		_, err := renderConfig(opts)
+		return err
+	}
+	if opts.ConfigTemplate != "" {
+		info, err := opts.FS.Stat(opts.ConfigTemplate)
+		if err != nil {
+			return fmt.Errorf("config template not found: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("config template %s is not a regular file", opts.ConfigTemplate)
+		}
+	}
+	info, err := opts.FS.Stat(opts.ConfigFilePath)
+	if opts.Install && errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("config file not found: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("config file %s is not a regular file", opts.ConfigFilePath)
+	}
+	return nil
+}
+
+// restoreConfig makes the configuration file available to the new
+// installation tree toDir.
+//
+// A configuration file inside the installation is copied from the old
+// installation tree fromDir, at the same relative path, unless fromDir is
+// empty. One kept outside is copied, symlinked or included as config.inc.php
+// according to opts.ConfigMode.
+//
+// The blowfish_secret of the file the new tree uses is checked on the way:
+// the copy inside toDir, or the file kept outside, which is fixed before it is
+// copied so that every later release gets the same secret.
+func restoreConfig(opts Options, fromDir, toDir string) error {
+	if opts.Inventory != "" {
+		if err := installRenderedConfig(opts, fromDir, toDir); err != nil {
+			return fmt.Errorf("failed to render config file: %w", err)
+		}
+		return nil
+	}
+
+	rel, inside := opts.configRelPath()
+	if !inside {
+		if err := checkSecret(opts, opts.ConfigFilePath, opts.ConfigFilePath); err != nil {
+			return err
+		}
+	}
+	if err := installConfig(opts, fromDir, toDir); err != nil {
+		return fmt.Errorf("failed to restore config file: %w", err)
+	}
+	if inside && fromDir != "" {
+		return checkSecret(opts, filepath.Join(toDir, rel), opts.ConfigFilePath)
+	}
+	return nil
+}
+
+func installConfig(opts Options, fromDir, toDir string) error {
+	if rel, inside := opts.configRelPath(); inside {
+		if fromDir == "" {
+			return nil
+		}
+		return fs.CopyFile(opts.FS, filepath.Join(fromDir, rel), filepath.Join(toDir, rel))
+	}
+
+	configPath := absPath(opts.ConfigFilePath)
+	target := filepath.Join(toDir, configName)
+	switch opts.ConfigMode {
+	case ConfigSymlink:
+		if err := opts.FS.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := opts.FS.Symlink(configPath, target); err != nil {
+			return err
+		}
+		return fs.SyncDir(opts.FS, toDir)
+	case ConfigInclude:
+		if err := fs.WriteFile(opts.FS, target, includeStub(configPath), 0644); err != nil {
+			return err
+		}
+		return fs.SyncDir(opts.FS, toDir)
+	default:
+		return fs.CopyFile(opts.FS, configPath, target)
+	}
+}
+
+// renderConfig returns the config.inc.php rendered from opts.Inventory, with
+// its secrets resolved and its blowfish_secret checked.
+func renderConfig(opts Options) ([]byte, error) {
+	inv, err := inventory.Load(opts.FS, opts.Inventory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load inventory: %w", err)
+	}
+	data, err := inv.Render(opts.FS, opts.Inventory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render inventory %s: %w", opts.Inventory, err)
+	}
+	problem, err := secretProblem(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rendered config: %w", err)
+	}
+	if problem != "" {
+		return nil, fmt.Errorf("inventory %s: blowfish_secret %s, phpMyAdmin requires exactly %d bytes", opts.Inventory, problem, BlowfishSecretLength)
+	}
+	return data, nil
+}
+
+// installRenderedConfig writes the config.inc.php rendered from opts.Inventory
+// into the new installation tree toDir, with the mode of the configuration
+// file in the old tree fromDir, if any.
+func installRenderedConfig(opts Options, fromDir, toDir string) error {
+	data, err := renderConfig(opts)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fromDir != "" {
+		if info, err := opts.FS.Stat(filepath.Join(fromDir, configName)); err == nil && info.Mode().IsRegular() {
+			mode = info.Mode().Perm()
+		}
+	}
+
+	target := filepath.Join(toDir, configName)
+	if err := opts.FS.RemoveAll(target); err != nil {
+		return err
+	}
+	if err := fs.WriteFile(opts.FS, target, data, mode); err != nil {
+		return err
+	}
+	if err := opts.FS.Chmod(target, mode); err != nil {
+		return err
+	}
+	return fs.SyncDir(opts.FS, toDir)
+}
+
+// includeStub returns a config.inc.php that loads the configuration file at
+// configPath, e.g.
+//
+//	<?php
+//	// Generated by pma-up: the configuration is kept in /etc/phpmyadmin/config.inc.php.
+//	require '/etc/phpmyadmin/config.inc.php';
+func includeStub(configPath string) []byte {
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(configPath)
+	return fmt.Appendf(nil, "<?php\n// Generated by pma-up: the configuration is kept in %s.\nrequire '%s';\n",
+		strings.ReplaceAll(configPath, "\n", " "), quoted)
+}
@@ -0,0 +1,88 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/phpconfig"
+)
+
+// defaultsRelPath is where a release defines the defaults of every directive.
+var defaultsRelPath = filepath.Join("libraries", "config.default.php")
+
+// reportDirectives compares the directives set by the configuration file with
+// the defaults of the release extracted at releasePath and of the current
+// installation, and reports unknown, deprecated, removed and new directives.
+// It only reports: the update goes ahead whatever the outcome.
+func reportDirectives(opts Options, releaseVersion, releasePath string) {
+	report, err := compareDirectives(opts, releasePath)
+	if errors.Is(err, os.ErrNotExist) {
+		// Releases without the file define their defaults elsewhere.
+		return
+	}
+	if err != nil {
+		fmt.Printf("warning: cannot check configuration directives: %v\n", err)
+		return
+	}
+	if report.Empty() {
+		return
+	}
+
+	source := opts.ConfigFilePath
+	if opts.Inventory != "" {
+		source = "the configuration rendered from " + opts.Inventory
+	}
+	fmt.Printf("Configuration directives in %s checked against phpMyAdmin %s:\n", source, releaseVersion)
+	for _, line := range strings.Split(report.Describe(), "\n") {
+		fmt.Printf("  %s\n", line)
+	}
+	if len(report.Unknown)+len(report.Deprecated)+len(report.Dropped) > 0 {
+		fmt.Println("warning: review the configuration file; phpMyAdmin ignores unknown directives")
+	}
+}
+
+func compareDirectives(opts Options, releasePath string) (phpconfig.Report, error) {
+	newDefaults, err := parseDirectives(opts.FS, filepath.Join(releasePath, defaultsRelPath))
+	if err != nil {
+		return phpconfig.Report{}, err
+	}
+	var config []phpconfig.Directive
+	if opts.Inventory != "" {
+		data, err := renderConfig(opts)
+		if err != nil {
+			return phpconfig.Report{}, err
+		}
+		if config, err = phpconfig.Parse(data); err != nil {
+			return phpconfig.Report{}, fmt.Errorf("failed to parse rendered config: %w", err)
+		}
+	} else if config, err = parseDirectives(opts.FS, opts.ConfigFilePath); err != nil {
+		return phpconfig.Report{}, err
+	}
+
+	// The installed defaults tell directives the new release dropped from
+	// ones that never existed, and which directives are new.
+	var installedDefaults []phpconfig.Directive
+	if installedTree, err := fs.EvalSymlinks(opts.FS, opts.DestinationPath); err == nil {
+		installedDefaults, err = parseDirectives(opts.FS, filepath.Join(installedTree, defaultsRelPath))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("warning: cannot read the directives of the installed release: %v\n", err)
+		}
+	}
+	return phpconfig.Compare(config, installedDefaults, newDefaults), nil
+}
+
+func parseDirectives(fsys fs.FS, path string) ([]phpconfig.Directive, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	directives, err := phpconfig.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return directives, nil
+}
@@ -0,0 +1,335 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/phpconfig"
+	"github.com/jsas4coding/pma-up/internal/version"
+)
+
+// sampleConfigName is the example configuration shipped at the root of every
+// release, which fresh installations start from.
+const sampleConfigName = "config.sample.inc.php"
+
+// tmpDirName is the directory phpMyAdmin writes its caches and uploads to,
+// at the root of the installation.
+const tmpDirName = "tmp"
+
+// versionPattern matches phpMyAdmin release versions, e.g. 5.2.2 or 6.0.0-rc1.
+var versionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.]+)?$`)
+
+// targetVersion returns the release to install: opts.Version when pinned, the
+// latest release otherwise.
+func targetVersion(ctx context.Context, opts Options) (*version.PhpMyAdminVersion, error) {
+	if opts.Version != "" {
+		fmt.Printf("Pinned version: %s\n", opts.Version)
+		return &version.PhpMyAdminVersion{Version: opts.Version, URL: version.ArchiveURL(opts.Version)}, nil
+	}
+
+	latestVersion, err := version.FetchLatestVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch latest version: %w", err)
+	}
+	fmt.Printf("Latest version: %s (%s)\n", latestVersion.Version, latestVersion.Date)
+	return latestVersion, nil
+}
+
+// checkInstallTarget makes sure a fresh installation overwrites nothing: the
+// installation path must be missing or an empty directory. The releases
+// layout later replaces an empty directory with its symlink, which a mount
+// point cannot be.
+func checkInstallTarget(opts Options) error {
+	info, err := opts.FS.Lstat(opts.DestinationPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cannot install into %s: it exists and is not a directory", opts.DestinationPath)
+	}
+	entries, err := opts.FS.ReadDir(opts.DestinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to read destination: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("cannot install into %s: it is not empty; run without install mode to update it", opts.DestinationPath)
+	}
+
+	if opts.Layout != LayoutReleases {
+		return nil
+	}
+	mounted, err := fs.IsMountPoint(opts.FS, opts.DestinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to check for mount point: %w", err)
+	}
+	if mounted {
+		return fmt.Errorf("cannot install the releases layout into mount point %s: it must become a symlink", opts.DestinationPath)
+	}
+	return nil
+}
+
+// clearInstallTarget removes the empty directory a fresh installation with the
+// releases layout replaces with its symlink, right before the switch. It
+// returns the function recreating the directory should the switch fail, and
+// does nothing when the installation path is not an empty directory.
+func clearInstallTarget(fsys fs.FS, path string) (func(), error) {
+	empty, err := isEmptyDir(fsys, path)
+	if err != nil || !empty {
+		return func() {}, err
+	}
+	info, err := fsys.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if err := fsys.RemoveAll(path); err != nil {
+		return nil, fmt.Errorf("failed to remove empty destination: %w", err)
+	}
+	return func() {
+		if _, err := fsys.Lstat(path); errors.Is(err, os.ErrNotExist) {
+			if err := fsys.MkdirAll(path, info.Mode().Perm()); err != nil {
+				fmt.Printf("warning: failed to recreate %s: %v\n", path, err)
+			}
+		}
+	}, nil
+}
+
+// isEmptyDir reports whether path is a directory, not a symlink to one,
+// without entries.
+func isEmptyDir(fsys fs.FS, path string) (bool, error) {
+	info, err := fsys.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if !info.IsDir() {
+		return false, nil
+	}
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read destination: %w", err)
+	}
+	return len(entries) == 0, nil
+}
+
+// installFresh seeds the extracted release with its configuration file and
+// tmp directory and puts it at the missing or empty installation path.
+func installFresh(ctx context.Context, opts Options, p *progress, extractedContentPath string) error {
+	destinationPath := opts.DestinationPath
+
+	if err := restoreUserFiles(ctx, opts, "", extractedContentPath); err != nil {
+		return err
+	}
+	p.journal.Strategy = journal.StrategyInstall
+	if err := p.complete(journal.PhaseConfigRestored); err != nil {
+		return err
+	}
+
+	swapCtx, err := p.beginSwap(ctx)
+	if err != nil {
+		return err
+	}
+	exists, err := pathExists(opts.FS, destinationPath)
+	if err != nil {
+		return err
+	}
+	if exists {
+		// An empty directory, possibly a mount point, is filled in place.
+		err = fs.MirrorDir(swapCtx, opts.FS, extractedContentPath, destinationPath)
+	} else {
+		err = fs.MoveDir(swapCtx, opts.FS, extractedContentPath, destinationPath, opts.releaseCopyOptions(extractedContentPath))
+	}
+	if err != nil {
+		if cleanErr := emptyDir(opts.FS, destinationPath, exists); cleanErr != nil {
+			return fmt.Errorf("failed to install phpMyAdmin into %s: %w (cleaning up also failed: %v)", destinationPath, err, cleanErr)
+		}
+		p.restored = true
+		return fmt.Errorf("failed to install phpMyAdmin into %s: %w", destinationPath, err)
+	}
+	p.note(journal.PhaseSwapped)
+	fmt.Printf("Installed phpMyAdmin into %s\n", destinationPath)
+	return nil
+}
+
+// recoverInstall recovers a fresh installation. The installation path is
+// complete once the swap is recorded or the release was renamed into it, and
+// a partial copy is emptied otherwise.
+func recoverInstall(opts Options, j *journal.Journal) (bool, error) {
+	destExists, err := pathExists(opts.FS, opts.DestinationPath)
+	if err != nil {
+		return false, err
+	}
+	releaseExists, err := pathExists(opts.FS, j.Release)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case destExists && (j.Reached(journal.PhaseSwapped) || !releaseExists):
+		return true, nil
+	case destExists && j.Reached(journal.PhaseConfigRestored):
+		mounted, err := fs.IsMountPoint(opts.FS, opts.DestinationPath)
+		if err != nil {
+			return false, err
+		}
+		if err := emptyDir(opts.FS, opts.DestinationPath, mounted); err != nil {
+			return false, fmt.Errorf("failed to remove partial installation %s: %w", opts.DestinationPath, err)
+		}
+	}
+	return false, nil
+}
+
+// emptyDir removes the partial installation at path, or only its entries when
+// keep is set.
+func emptyDir(fsys fs.FS, path string, keep bool) error {
+	if !keep {
+		return fsys.RemoveAll(path)
+	}
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := fsys.RemoveAll(filepath.Join(path, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedInstallation prepares the first release of a fresh installation at
+// toDir: it creates the configuration file, unless it already exists, and
+// the tmp directory.
+func seedInstallation(opts Options, toDir string) error {
+	if opts.Inventory == "" {
+		if err := seedConfig(opts, toDir); err != nil {
+			return fmt.Errorf("failed to create config file: %w", err)
+		}
+	}
+	if err := setupTmpDir(opts, toDir); err != nil {
+		return fmt.Errorf("failed to set up %s directory: %w", tmpDirName, err)
+	}
+	return nil
+}
+
+// seedConfig creates the configuration file from opts.ConfigTemplate or, by
+// default, the sample configuration of the release at toDir, with a newly
+// generated blowfish_secret if it has none. A configuration file inside the
+// installation is created in toDir; one kept outside is created in place
+// unless it exists.
+func seedConfig(opts Options, toDir string) error {
+	target := opts.ConfigFilePath
+	if rel, inside := opts.configRelPath(); inside {
+		target = filepath.Join(toDir, rel)
+	}
+	if exists, err := pathExists(opts.FS, target); err != nil || exists {
+		return err
+	}
+
+	source := opts.ConfigTemplate
+	if source == "" {
+		source = filepath.Join(toDir, sampleConfigName)
+	}
+	data, err := fs.ReadFile(opts.FS, source)
+	if err != nil {
+		return err
+	}
+
+	problem, err := secretProblem(data)
+	if err != nil {
+		return fmt.Errorf("failed to check blowfish_secret of %s: %w", source, err)
+	}
+	if problem != "" {
+		secret, err := generateSecret(BlowfishSecretLength)
+		if err != nil {
+			return fmt.Errorf("failed to generate blowfish_secret: %w", err)
+		}
+		if data, err = phpconfig.SetString(data, blowfishSecretKey, secret); err != nil {
+			return fmt.Errorf("failed to set blowfish_secret: %w", err)
+		}
+	}
+
+	if err := opts.FS.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := fs.WriteFile(opts.FS, target, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Created %s from %s\n", opts.ConfigFilePath, source)
+	return fs.SyncDir(opts.FS, filepath.Dir(target))
+}
+
+// setupTmpDir creates the tmp directory of the installation at toDir, owned
+// by opts.TmpOwner when set, so that the web server can write to it.
+func setupTmpDir(opts Options, toDir string) error {
+	tmpDir := filepath.Join(toDir, tmpDirName)
+	if err := opts.FS.MkdirAll(tmpDir, 0750); err != nil {
+		return err
+	}
+	if err := opts.FS.Chmod(tmpDir, 0750); err != nil {
+		return err
+	}
+	if opts.TmpOwner == "" {
+		fmt.Printf("warning: %s/ must be writable by the web server; set its owner with the tmp owner option\n", tmpDirName)
+		return nil
+	}
+
+	uid, gid, err := lookupOwner(opts.TmpOwner)
+	if err != nil {
+		return err
+	}
+	if err := fs.Chown(opts.FS, tmpDir, uid, gid); err != nil {
+		return fmt.Errorf("failed to change owner to %s: %w", opts.TmpOwner, err)
+	}
+	return nil
+}
+
+// lookupOwner resolves an owner given as user or user:group, by name or
+// numeric ID. The group defaults to the user's primary group.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+	if userName == "" {
+		return 0, 0, fmt.Errorf("invalid owner %q: missing user", owner)
+	}
+
+	uid, err = strconv.Atoi(userName)
+	gid = -1
+	if err != nil {
+		u, lookupErr := user.Lookup(userName)
+		if lookupErr != nil {
+			return 0, 0, fmt.Errorf("invalid owner %q: %w", owner, lookupErr)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("invalid owner %q: user ID %s is not numeric", owner, u.Uid)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			gid = -1
+		}
+	}
+
+	if hasGroup && groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, lookupErr := user.LookupGroup(groupName)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("invalid owner %q: %w", owner, lookupErr)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("invalid owner %q: group ID %s is not numeric", owner, g.Gid)
+			}
+		}
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,100 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// lintRestored runs opts.PHPBinary -l on the configuration file and the
+// preserved PHP files restored into the new installation tree toDir, and
+// fails listing every file that does not parse, so that the release never
+// goes live with them.
+func lintRestored(ctx context.Context, opts Options, fromDir, toDir string) error {
+	paths, err := restoredPHPFiles(opts, fromDir, toDir)
+	if err != nil {
+		return fmt.Errorf("failed to list restored PHP files: %w", err)
+	}
+
+	binary := opts.PHPBinary
+	if binary == "" {
+		binary = DefaultPHPBinary
+	}
+	var problems []string
+	for _, path := range paths {
+		if err := opts.LintPHPFile(ctx, binary, path); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			name := path
+			if rel, err := filepath.Rel(toDir, path); err == nil && filepath.IsLocal(rel) {
+				name = rel
+			}
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("PHP syntax check of restored files failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	if len(paths) > 0 {
+		fmt.Printf("Checked the syntax of %d restored PHP files\n", len(paths))
+	}
+	return nil
+}
+
+// restoredPHPFiles returns the paths in toDir of the configuration file and
+// of the PHP files among the user files preserved from fromDir, plus the
+// configuration file outside the installation that an include stub requires.
+func restoredPHPFiles(opts Options, fromDir, toDir string) ([]string, error) {
+	var paths []string
+	add := func(path string) error {
+		info, err := opts.FS.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	}
+
+	configPath := filepath.Join(toDir, configName)
+	if rel, inside := opts.configRelPath(); inside {
+		configPath = filepath.Join(toDir, rel)
+	}
+	if err := add(configPath); err != nil {
+		return nil, err
+	}
+	if _, inside := opts.configRelPath(); !inside && opts.ConfigMode == ConfigInclude {
+		// The stub only requires the configuration file, which is kept
+		// outside the installation.
+		if err := add(absPath(opts.ConfigFilePath)); err != nil {
+			return nil, err
+		}
+	}
+	if fromDir == "" {
+		return paths, nil
+	}
+
+	err := walkPreserved(opts, fromDir, func(rel string, _ os.FileInfo) error {
+		return fs.Walk(opts.FS, filepath.Join(fromDir, rel), func(entryPath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.EqualFold(filepath.Ext(entryPath), ".php") {
+				return err
+			}
+			entryRel, err := filepath.Rel(fromDir, entryPath)
+			if err != nil {
+				return err
+			}
+			return add(filepath.Join(toDir, entryRel))
+		})
+	})
+	return paths, err
+}
@@ -0,0 +1,201 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/downloader"
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/manifest"
+	"github.com/jsas4coding/pma-up/internal/version"
+)
+
+// ModifiedPolicy selects what an update does with core files of the current
+// installation that were modified or removed locally.
+type ModifiedPolicy string
+
+const (
+	// ModifiedWarn lists the modified files and replaces them with the new
+	// release's. The originals remain in the backup.
+	ModifiedWarn ModifiedPolicy = "warn"
+
+	// ModifiedRefuse fails the update, before anything is moved, when core
+	// files were modified or when they cannot be checked.
+	ModifiedRefuse ModifiedPolicy = "refuse"
+
+	// ModifiedCarry applies the local changes to the new release for every
+	// file the new release leaves as it was, and reports the others.
+	ModifiedCarry ModifiedPolicy = "carry"
+
+	// ModifiedIgnore skips the check.
+	ModifiedIgnore ModifiedPolicy = "ignore"
+)
+
+// handleModified checks the current installation for locally modified core
+// files and applies opts.ModifiedFiles to them, updating the release extracted
+// at releasePath. Files changed by the local patches are not reported, as the
+// patches carry them.
+//
+// The pristine state of the installation comes from the manifest saved in it
+// by an earlier update or, lacking one, from the upstream archive of the
+// installed version, downloaded into the temporary directory of the run.
+func handleModified(ctx context.Context, opts Options, p *progress, release *manifest.Manifest, patched []string, releasePath string) error {
+	if opts.ModifiedFiles == ModifiedIgnore {
+		return nil
+	}
+	return checkModified(ctx, opts, p, release, patched, releasePath)
+}
+
+// checkModified finds the locally changed core files of the current
+// installation and applies opts.ModifiedFiles to them.
+func checkModified(ctx context.Context, opts Options, p *progress, release *manifest.Manifest, patched []string, releasePath string) error {
+	installedTree, err := fs.EvalSymlinks(opts.FS, opts.DestinationPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination: %w", err)
+	}
+
+	pristine, err := pristineManifest(ctx, opts, p, release, installedTree)
+	if err == nil {
+		var changes manifest.Changes
+		if changes, err = pristine.Diff(opts.FS, installedTree); err == nil {
+			return applyModifiedPolicy(opts, coreChanges(opts, changes, patched), pristine, release, installedTree, releasePath)
+		}
+	}
+	if ctx.Err() != nil || opts.ModifiedFiles == ModifiedRefuse {
+		return fmt.Errorf("failed to check for locally modified core files: %w", err)
+	}
+	fmt.Printf("warning: cannot check for locally modified core files: %v\n", err)
+	return nil
+}
+
+// pristineManifest returns the manifest of the installation at installedTree
+// as it was shipped.
+func pristineManifest(ctx context.Context, opts Options, p *progress, release *manifest.Manifest, installedTree string) (*manifest.Manifest, error) {
+	stored, err := manifest.Load(opts.FS, installedTree)
+	if err != nil || stored != nil {
+		return stored, err
+	}
+
+	installedVersion, err := version.DetectInstalled(opts.FS, installedTree)
+	if err != nil {
+		return nil, err
+	}
+	if installedVersion == release.Version {
+		return release, nil
+	}
+
+	fmt.Printf("Downloading phpMyAdmin %s to check for locally modified core files...\n", installedVersion)
+	zipPath, err := downloader.DownloadPhpMyAdmin(ctx, opts.FS, version.ArchiveURL(installedVersion), p.journal.TempDir, installedVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download phpMyAdmin %s: %w", installedVersion, err)
+	}
+	defer func() {
+		if removeErr := opts.FS.RemoveAll(zipPath); removeErr != nil {
+			fmt.Printf("warning: failed to remove %s: %v\n", zipPath, removeErr)
+		}
+	}()
+	return manifest.FromZip(opts.FS, zipPath, installedVersion)
+}
+
+// coreChanges returns changes without the files handled separately from core
+// files: the configuration file, the preserved user files and the files
+// changed by local patches.
+func coreChanges(opts Options, changes manifest.Changes, patched []string) manifest.Changes {
+	configRel, _ := opts.configRelPath()
+	keep := func(paths []string) []string {
+		var kept []string
+		for _, relPath := range paths {
+			if relPath != filepath.ToSlash(configRel) && !isPreserved(opts.Preserve, relPath) &&
+				!slices.Contains(patched, relPath) {
+				kept = append(kept, relPath)
+			}
+		}
+		return kept
+	}
+	return manifest.Changes{Modified: keep(changes.Modified), Removed: keep(changes.Removed)}
+}
+
+// isPreserved reports whether the slash-separated path rel, or one of the
+// directories holding it, matches one of patterns.
+func isPreserved(patterns []string, rel string) bool {
+	for ; rel != "." && rel != "/"; rel = path.Dir(rel) {
+		if matchesAny(patterns, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyModifiedPolicy reports the locally changed core files and applies
+// opts.ModifiedFiles to them.
+func applyModifiedPolicy(opts Options, changes manifest.Changes, pristine, release *manifest.Manifest, installedTree, releasePath string) error {
+	if changes.Empty() {
+		return nil
+	}
+
+	count := len(changes.Modified) + len(changes.Removed)
+	fmt.Printf("Found %d locally changed core files in phpMyAdmin %s:\n", count, pristine.Version)
+	for _, relPath := range changes.Modified {
+		fmt.Printf("  modified: %s\n", relPath)
+	}
+	for _, relPath := range changes.Removed {
+		fmt.Printf("  removed:  %s\n", relPath)
+	}
+
+	switch opts.ModifiedFiles {
+	case ModifiedRefuse:
+		return fmt.Errorf("refusing to update: %d core files were changed locally: %s",
+			count, strings.Join(append(changes.Modified, changes.Removed...), ", "))
+	case ModifiedCarry:
+		return carryChanges(opts.FS, changes, pristine, release, installedTree, releasePath)
+	}
+	fmt.Println("warning: these changes are discarded by the update; the changed files remain in the backup")
+	return nil
+}
+
+// carryChanges applies the local changes to the core files of installedTree
+// to the release at releasePath, for every file the release ships exactly as
+// the installed version did. Other changes are reported as conflicts and the
+// release's files are kept.
+func carryChanges(fsys fs.FS, changes manifest.Changes, pristine, release *manifest.Manifest, installedTree, releasePath string) error {
+	carryable := func(relPath string) bool {
+		sum, shipped := release.Files[relPath]
+		return shipped && sum == pristine.Files[relPath]
+	}
+
+	for _, relPath := range changes.Modified {
+		if !carryable(relPath) {
+			fmt.Printf("warning: not carrying over changes to %s: the new release changed it too\n", relPath)
+			continue
+		}
+		source := filepath.Join(installedTree, filepath.FromSlash(relPath))
+		if err := fs.CopyFile(fsys, source, filepath.Join(releasePath, filepath.FromSlash(relPath))); err != nil {
+			return fmt.Errorf("failed to carry over changes to %s: %w", relPath, err)
+		}
+		fmt.Printf("Carried over changes to %s\n", relPath)
+	}
+
+	for _, relPath := range changes.Removed {
+		if _, shipped := release.Files[relPath]; !shipped {
+			continue
+		}
+		if !carryable(relPath) {
+			fmt.Printf("warning: not carrying over removal of %s: the new release changed it\n", relPath)
+			continue
+		}
+		if err := fsys.RemoveAll(filepath.Join(releasePath, filepath.FromSlash(relPath))); err != nil {
+			return fmt.Errorf("failed to carry over removal of %s: %w", relPath, err)
+		}
+		fmt.Printf("Carried over removal of %s\n", relPath)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/manifest"
+	"github.com/jsas4coding/pma-up/internal/php"
+	"github.com/jsas4coding/pma-up/internal/release"
+)
+
+// Layout selects how a new phpMyAdmin release is put in place.
+type Layout string
+
+const (
+	// LayoutInPlace moves the current installation to a backup directory and
+	// moves the new release into the installation path.
+	LayoutInPlace Layout = "in-place"
+
+	// LayoutReleases keeps every release in its own versioned directory and
+	// turns the installation path into a symlink that is switched atomically.
+	LayoutReleases Layout = "releases"
+)
+
+// DefaultKeepReleases is the number of releases retained by the releases layout
+// when no retention is configured.
+const DefaultKeepReleases = 5
+
+// Options configures an update run.
+type Options struct {
+	DestinationPath   string         // Path where phpMyAdmin is installed
+	ConfigFilePath    string         // Path to the phpMyAdmin configuration file, config.inc.php in the installation when empty with Inventory or Install
+	ConfigMode        ConfigMode     // How a config file outside the installation reaches new releases, ConfigCopy when empty
+	Inventory         string         // TOML inventory config.inc.php is rendered from instead of restored, none when empty
+	Install           bool           // Install into a missing or empty destination instead of updating it
+	Version           string         // Release to install, the latest release when empty; for Rollback, version of the backup to restore
+	ConfigTemplate    string         // File a fresh installation's config file is created from, the release's config.sample.inc.php when empty
+	TmpOwner          string         // Owner, as user[:group], of a fresh installation's tmp directory; unchanged when empty
+	Layout            Layout         // Installation layout, LayoutInPlace when empty
+	ReleasesDir       string         // Releases root for LayoutReleases, release.DefaultRoot when empty
+	KeepReleases      int            // Releases retained by LayoutReleases, DefaultKeepReleases when zero, unlimited when negative
+	KeepBackups       int            // Backups of the installation retained, all when zero or negative
+	StagingDir        string         // Directory where releases are extracted, next to the installed tree when empty
+	Durable           bool           // Fsync downloaded, extracted and copied files and their directories before the swap
+	Hardlinks         bool           // Hard link instead of copying the files a release ships when copying its tree
+	Preserve          []string       // Globs of user files carried into new releases, relative to the installation; DefaultPreserve when nil
+	PatchesDir        string         // Directory of unified diffs applied to every new release, none when empty
+	ModifiedFiles     ModifiedPolicy // Handling of locally modified core files, ModifiedWarn when empty
+	FixBlowfishSecret bool           // Replace a missing or wrongly sized blowfish_secret with a generated one
+	PHPBinary         string         // PHP binary release requirements are checked against, DefaultPHPBinary when empty
+	SkipPHPCheck      bool           // Install releases without checking their PHP requirements
+	LintPHP           bool           // Syntax-check the restored config file and preserved PHP files with PHPBinary before the swap
+	CopyWorkers       int            // Files copied concurrently when copying trees, fs.DefaultWorkers when zero
+	FS                fs.FS          // Filesystem to update, fs.OS honoring Durable when nil
+
+	// ProbePHP inspects the PHP installation of a binary to check release
+	// requirements against; php.Probe when nil.
+	ProbePHP func(ctx context.Context, binary string) (*php.Host, error)
+
+	// LintPHPFile checks the syntax of a PHP file with a binary for LintPHP;
+	// php.Lint when nil.
+	LintPHPFile func(ctx context.Context, binary, path string) error
+
+	// LockTimeout is how long to wait for another run on the same destination
+	// to finish; zero fails at once and a negative value waits indefinitely.
+	LockTimeout time.Duration
+
+	// OrphanAge is how long a temporary or staging directory left by an
+	// earlier run must have been untouched before it is removed;
+	// DefaultOrphanAge when zero, and leftovers are kept when negative.
+	OrphanAge time.Duration
+}
+
+// withDefaults returns a copy of opts with defaults applied and validates it.
+func (opts Options) withDefaults() (Options, error) {
+	if opts.DestinationPath == "" {
+		return opts, fmt.Errorf("empty destination path")
+	}
+	if opts.Inventory != "" {
+		// The rendered configuration is the installation's config.inc.php.
+		inventoryConfig := filepath.Join(opts.DestinationPath, configName)
+		if opts.ConfigFilePath != "" && absPath(opts.ConfigFilePath) != absPath(inventoryConfig) {
+			return opts, fmt.Errorf("an inventory renders %s, config file path %s cannot be used with it", inventoryConfig, opts.ConfigFilePath)
+		}
+		opts.ConfigFilePath = inventoryConfig
+	}
+	if opts.Install && opts.ConfigFilePath == "" {
+		opts.ConfigFilePath = filepath.Join(opts.DestinationPath, configName)
+	}
+	if opts.ConfigFilePath == "" {
+		return opts, fmt.Errorf("empty config file path")
+	}
+
+	if opts.Version != "" && !versionPattern.MatchString(opts.Version) {
+		return opts, fmt.Errorf("invalid version %q", opts.Version)
+	}
+	if !opts.Install && (opts.ConfigTemplate != "" || opts.TmpOwner != "") {
+		return opts, fmt.Errorf("a config template and a tmp owner only apply to install mode")
+	}
+	if opts.ConfigTemplate != "" && opts.Inventory != "" {
+		return opts, fmt.Errorf("a config template cannot be used with an inventory")
+	}
+	if opts.TmpOwner != "" {
+		if _, _, err := lookupOwner(opts.TmpOwner); err != nil {
+			return opts, err
+		}
+	}
+
+	switch opts.Layout {
+	case "":
+		opts.Layout = LayoutInPlace
+	case LayoutInPlace, LayoutReleases:
+	default:
+		return opts, fmt.Errorf("unknown layout %q", opts.Layout)
+	}
+
+	switch opts.ConfigMode {
+	case "":
+		opts.ConfigMode = ConfigCopy
+	case ConfigCopy, ConfigSymlink, ConfigInclude:
+	default:
+		return opts, fmt.Errorf("unknown config mode %q", opts.ConfigMode)
+	}
+
+	switch opts.ModifiedFiles {
+	case "":
+		opts.ModifiedFiles = ModifiedWarn
+	case ModifiedWarn, ModifiedRefuse, ModifiedCarry, ModifiedIgnore:
+	default:
+		return opts, fmt.Errorf("unknown modified files policy %q", opts.ModifiedFiles)
+	}
+
+	if opts.Preserve == nil {
+		opts.Preserve = DefaultPreserve
+	}
+	if err := checkPreserve(opts.Preserve); err != nil {
+		return opts, err
+	}
+
+	if opts.ReleasesDir == "" {
+		opts.ReleasesDir = release.DefaultRoot(opts.DestinationPath)
+	}
+	if opts.KeepReleases == 0 {
+		opts.KeepReleases = DefaultKeepReleases
+	}
+	if opts.OrphanAge == 0 {
+		opts.OrphanAge = DefaultOrphanAge
+	}
+	if opts.FS == nil {
+		opts.FS = fs.OS{Durable: opts.Durable}
+	}
+	if opts.ProbePHP == nil {
+		opts.ProbePHP = php.Probe
+	}
+	if opts.LintPHPFile == nil {
+		opts.LintPHPFile = php.Lint
+	}
+
+	return opts, nil
+}
+
+// copyOptions returns how trees are copied and moved. Nothing is hard linked,
+// as the tree may be the live installation or hold files edited in place.
+// The staging and trash directories of moves hold a work directory lock, like
+// those of the run, so that cleanups leave them alone.
+func (opts Options) copyOptions() fs.CopyOptions {
+	return fs.CopyOptions{Workers: opts.CopyWorkers, LockDir: func(dir string) (func(), error) {
+		dirLock, err := lockWorkDir(context.Background(), opts.FS, dir)
+		if err != nil {
+			return nil, err
+		}
+		return func() { releaseWorkDir(dirLock) }, nil
+	}}
+}
+
+// releaseCopyOptions returns how the release tree at root is copied and
+// moved. With opts.Hardlinks, the files its manifest lists as shipped by the
+// release are hard linked; the configuration file and preserved user files
+// restored into it never are.
+func (opts Options) releaseCopyOptions(root string) fs.CopyOptions {
+	copyOpts := opts.copyOptions()
+	if !opts.Hardlinks {
+		return copyOpts
+	}
+	shipped, err := manifest.Load(opts.FS, root)
+	if err != nil {
+		fmt.Printf("warning: copying %s without hard links: %v\n", root, err)
+	}
+	if shipped == nil {
+		return copyOpts
+	}
+	configRel, _ := opts.configRelPath()
+	configRel = filepath.ToSlash(configRel)
+	copyOpts.Hardlink = func(rel string) bool {
+		_, listed := shipped.Files[rel]
+		return listed && rel != configRel && !isPreserved(opts.Preserve, rel)
+	}
+	return copyOpts
+}
@@ -0,0 +1,45 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jsas4coding/pma-up/internal/patch"
+)
+
+// loadPatches parses the local patches in opts.PatchesDir, if set, so that a
+// malformed patch fails the update before anything is downloaded.
+func loadPatches(opts Options) ([]*patch.Patch, error) {
+	if opts.PatchesDir == "" {
+		return nil, nil
+	}
+	patches, err := patch.Load(opts.FS, opts.PatchesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local patches: %w", err)
+	}
+	return patches, nil
+}
+
+// applyPatches applies the local patches to the release extracted at
+// releasePath and returns the relative paths of the files they changed.
+// Nothing is changed unless every hunk applies.
+func applyPatches(opts Options, patches []*patch.Patch, releaseVersion, releasePath string) ([]string, error) {
+	if len(patches) == 0 {
+		return nil, nil
+	}
+
+	report, changed, err := patch.Apply(opts.FS, releasePath, patches)
+	var applyErr *patch.ApplyError
+	if errors.As(err, &applyErr) {
+		return nil, fmt.Errorf("local patches do not apply to phpMyAdmin %s, installation left untouched: %w", releaseVersion, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply local patches: %w", err)
+	}
+
+	fmt.Printf("Applied %d local patches:\n", len(patches))
+	for _, result := range report {
+		fmt.Printf("  %s\n", result)
+	}
+	return changed, nil
+}
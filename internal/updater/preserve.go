@@ -0,0 +1,243 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// DefaultPreserve lists the user files and directories carried from the old
+// installation into new releases when no patterns are configured.
+var DefaultPreserve = []string{
+	"config.user.inc.php",
+	"config.header.inc.php",
+	"config.footer.inc.php",
+	"favicon.ico",
+	"robots.txt",
+	"themes/*",
+	"upload",
+	"save",
+}
+
+// checkPreserve validates preserve patterns: slash-separated globs in the
+// syntax of path.Match, relative to the installation and staying inside it.
+func checkPreserve(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid preserve pattern %q: %w", pattern, err)
+		}
+		if pattern == "" || path.IsAbs(pattern) || path.Clean(pattern) != pattern ||
+			pattern == ".." || strings.HasPrefix(pattern, "../") {
+			return fmt.Errorf("invalid preserve pattern %q: must be a clean path relative to the installation", pattern)
+		}
+	}
+	return nil
+}
+
+// restoreUserFiles carries the preserved user files and the configuration
+// file from the old installation tree fromDir into the new tree toDir. An
+// empty fromDir means there is no old tree, e.g. on the first release, which a
+// fresh installation seeds with a configuration file and a tmp directory.
+// With opts.LintPHP, the restored PHP files must then pass a syntax check.
+func restoreUserFiles(ctx context.Context, opts Options, fromDir, toDir string) error {
+	if fromDir != "" {
+		replaced, skipped, err := preserveFiles(ctx, opts, fromDir, toDir)
+		if err != nil {
+			return fmt.Errorf("failed to preserve user files: %w", err)
+		}
+		for _, conflict := range replaced {
+			fmt.Printf("warning: preserving %s in place of the version shipped by the new release\n", conflict)
+		}
+		for _, conflict := range skipped {
+			fmt.Printf("warning: not preserving %s: the new release ships a different kind of entry there\n", conflict)
+		}
+	} else if opts.Install {
+		if err := seedInstallation(opts, toDir); err != nil {
+			return err
+		}
+	}
+	if err := restoreConfig(opts, fromDir, toDir); err != nil {
+		return err
+	}
+	if opts.LintPHP {
+		return lintRestored(ctx, opts, fromDir, toDir)
+	}
+	return nil
+}
+
+// preserveFiles copies the entries of fromDir matching opts.Preserve into
+// toDir. A file or symlink the new release ships differently at the same path
+// is replaced by the preserved one, and a directory shipped by the new release
+// too is left as shipped. It returns, relative to the installation, the
+// entries that replaced the release's own and those it did not copy because
+// the release ships a different kind of entry at their path.
+func preserveFiles(ctx context.Context, opts Options, fromDir, toDir string) (replaced, skipped []string, err error) {
+	err = walkPreserved(opts, fromDir, func(rel string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		source, target := filepath.Join(fromDir, rel), filepath.Join(toDir, rel)
+
+		targetInfo, err := opts.FS.Lstat(target)
+		switch {
+		case err == nil:
+			same, err := sameEntry(opts.FS, source, info, target, targetInfo)
+			if err != nil || same {
+				return err
+			}
+			if info.IsDir() || targetInfo.IsDir() {
+				skipped = append(skipped, filepath.ToSlash(rel))
+				return nil
+			}
+			// The user's version wins over the release's.
+			if err := opts.FS.RemoveAll(target); err != nil {
+				return err
+			}
+			replaced = append(replaced, filepath.ToSlash(rel))
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+
+		if err := opts.FS.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			return fs.CopyDir(ctx, opts.FS, source, target, opts.copyOptions())
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, err := opts.FS.Readlink(source)
+			if err != nil {
+				return err
+			}
+			return opts.FS.Symlink(linkTarget, target)
+		case info.Mode().IsRegular():
+			return fs.CopyFile(opts.FS, source, target)
+		}
+		fmt.Printf("warning: not preserving %s: not a regular file, directory or symlink\n", filepath.ToSlash(rel))
+		return nil
+	})
+	return replaced, skipped, err
+}
+
+// preservedSize returns the total size of the regular files in fromDir that
+// preserveFiles may copy: an upper bound, as entries the new release ships
+// identically are not copied.
+func preservedSize(opts Options, fromDir string) (uint64, error) {
+	var size uint64
+	err := walkPreserved(opts, fromDir, func(rel string, info os.FileInfo) error {
+		switch {
+		case info.IsDir():
+			dirSize, err := fs.DirSize(opts.FS, filepath.Join(fromDir, rel))
+			size += dirSize
+			return err
+		case info.Mode().IsRegular():
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+// walkPreserved calls fn for every entry of the tree at root whose path
+// relative to root matches one of opts.Preserve, without descending into
+// matched directories. The configuration file is never reported, as it is
+// restored separately.
+func walkPreserved(opts Options, root string, fn func(rel string, info os.FileInfo) error) error {
+	configRel, _ := opts.configRelPath()
+	return fs.Walk(opts.FS, root, func(entryPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, entryPath)
+		if err != nil || rel == "." {
+			return err
+		}
+		if rel == configRel {
+			return nil
+		}
+
+		slashRel := filepath.ToSlash(rel)
+		if matchesAny(opts.Preserve, slashRel) {
+			if err := fn(rel, info); err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && !mayMatchBelow(opts.Preserve, slashRel) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// matchesAny reports whether the slash-separated path rel matches one of patterns.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatchBelow reports whether one of patterns may match an entry inside
+// the directory rel, so that the walk has to descend into it.
+func mayMatchBelow(patterns []string, dir string) bool {
+	dirElems := strings.Split(dir, "/")
+	for _, pattern := range patterns {
+		patternElems := strings.Split(pattern, "/")
+		if len(patternElems) <= len(dirElems) {
+			continue
+		}
+		prefix := strings.Join(patternElems[:len(dirElems)], "/")
+		if matched, _ := path.Match(prefix, dir); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEntry reports whether the entry at source needs no preserving because
+// the new release has the same at target: both directories, both regular
+// files with identical content, or both symlinks to the same target.
+func sameEntry(fsys fs.FS, source string, sourceInfo os.FileInfo, target string, targetInfo os.FileInfo) (bool, error) {
+	sourceType, targetType := sourceInfo.Mode().Type(), targetInfo.Mode().Type()
+	if sourceType != targetType {
+		return false, nil
+	}
+	switch {
+	case sourceInfo.IsDir():
+		return true, nil
+	case sourceType == os.ModeSymlink:
+		sourceLink, err := fsys.Readlink(source)
+		if err != nil {
+			return false, err
+		}
+		targetLink, err := fsys.Readlink(target)
+		return sourceLink == targetLink, err
+	case sourceInfo.Mode().IsRegular():
+		if sourceInfo.Size() != targetInfo.Size() {
+			return false, nil
+		}
+		sourceData, err := fs.ReadFile(fsys, source)
+		if err != nil {
+			return false, err
+		}
+		targetData, err := fs.ReadFile(fsys, target)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(sourceData, targetData), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,307 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/release"
+)
+
+// progress persists the journal of the running update.
+type progress struct {
+	fsys     fs.FS
+	path     string
+	journal  *journal.Journal
+	swapping bool // The swap started, so the installation may have been modified
+	restored bool // The failed update put the installation back as it was
+}
+
+// save writes the journal. Steps relying on it for recovery must not start
+// when it fails.
+func (p *progress) save() error {
+	if err := p.journal.Save(p.fsys, p.path); err != nil {
+		return fmt.Errorf("failed to record update progress: %w", err)
+	}
+	return nil
+}
+
+// complete records phase and saves the journal.
+func (p *progress) complete(phase journal.Phase) error {
+	p.journal.Complete(phase)
+	return p.save()
+}
+
+// note records phase for information only; recovery does not depend on it.
+func (p *progress) note(phase journal.Phase) {
+	if err := p.complete(phase); err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+}
+
+// settled reports whether a failed update left nothing for recovery to do:
+// no strategy was chosen, the installation was neither backed up nor swapped,
+// or the failure path already put it back.
+func (p *progress) settled() bool {
+	if p.journal.Strategy == "" || p.restored {
+		return true
+	}
+	return !p.swapping && !p.journal.Reached(journal.PhaseBackedUp) && !p.journal.Reached(journal.PhaseSwapped)
+}
+
+// recoverInterrupted finishes or rolls back the update recorded by the
+// journal at journalPath, left behind by a run that was interrupted.
+//
+// Recovery looks at what is actually on disk rather than trusting the last
+// recorded phase alone: an update is finished when the new release is already
+// live or complete with its configuration file, and rolled back otherwise.
+// Temporary and staging directories of the interrupted run are then removed.
+func recoverInterrupted(ctx context.Context, opts Options, journalPath string) error {
+	j, err := journal.Load(opts.FS, journalPath)
+	if err != nil || j == nil {
+		return err
+	}
+	fmt.Printf("Found update to %s started at %s interrupted after phase %q, recovering...\n",
+		j.Version, j.StartedAt.Local().Format("2006-01-02 15:04:05"), j.LastPhase())
+
+	var finished bool
+	switch j.Strategy {
+	case journal.StrategyInPlace:
+		finished, err = recoverInPlace(ctx, opts, j)
+	case journal.StrategySymlink:
+		finished, err = recoverSymlink(opts, j)
+	case journal.StrategyMount:
+		finished, err = recoverMount(ctx, opts, j)
+	case journal.StrategyReleases:
+		finished, err = recoverRelease(opts, j)
+	case journal.StrategyInstall:
+		finished, err = recoverInstall(opts, j)
+	case "":
+		// Interrupted before touching the installation.
+	default:
+		err = fmt.Errorf("unknown strategy %q", j.Strategy)
+	}
+	if err != nil {
+		return err
+	}
+
+	if finished {
+		fmt.Printf("Finished interrupted update to %s\n", j.Version)
+	} else {
+		fmt.Printf("Rolled back interrupted update to %s\n", j.Version)
+	}
+
+	for _, dir := range []string{j.StagingDir, j.TempDir} {
+		if dir == "" {
+			continue
+		}
+		if err := opts.FS.RemoveAll(dir); err != nil {
+			fmt.Printf("warning: failed to remove %s: %v\n", dir, err)
+		}
+	}
+	return journal.Remove(opts.FS, journalPath)
+}
+
+// recoverInPlace recovers an update that moves the installation to a backup
+// and moves the release into its place. Both moves end with a rename, so the
+// installation path is either the old tree, the new tree or missing. Which
+// tree it holds follows from the journal: the backup is recorded before the
+// release is moved in, while a move across filesystems may already have
+// copied the old tree to the backup path without removing it yet.
+func recoverInPlace(ctx context.Context, opts Options, j *journal.Journal) (bool, error) {
+	destinationPath := opts.DestinationPath
+	destExists, err := pathExists(opts.FS, destinationPath)
+	if err != nil {
+		return false, err
+	}
+	backupExists, err := pathExists(opts.FS, j.Backup)
+	if err != nil {
+		return false, err
+	}
+	releaseExists, err := pathExists(opts.FS, j.Release)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case destExists && j.Reached(journal.PhaseBackedUp):
+		// The release was swapped in.
+	case destExists:
+		// The installation was never moved; a copy of it may have been.
+		if err := opts.FS.RemoveAll(j.Backup); err != nil {
+			fmt.Printf("warning: failed to remove incomplete backup %s: %v\n", j.Backup, err)
+		}
+		return false, nil
+	case backupExists && releaseExists && j.Reached(journal.PhaseConfigRestored):
+		if err := fs.MoveDir(ctx, opts.FS, j.Release, destinationPath, opts.releaseCopyOptions(j.Release)); err != nil {
+			return false, fmt.Errorf("failed to move new phpMyAdmin to destination: %w", err)
+		}
+	case backupExists:
+		if err := fs.MoveDir(ctx, opts.FS, j.Backup, destinationPath, opts.copyOptions()); err != nil {
+			return false, fmt.Errorf("failed to restore %s: %w", j.Backup, err)
+		}
+		return false, nil
+	default:
+		return false, fmt.Errorf("installation %s is missing and neither its backup %s nor the new release is available",
+			destinationPath, j.Backup)
+	}
+
+	recoverBackupRecord(opts, j)
+	return true, nil
+}
+
+// recoverSymlink recovers an update that installs the release next to the
+// symlink target and switches the symlink, which always points somewhere valid.
+func recoverSymlink(opts Options, j *journal.Journal) (bool, error) {
+	current, err := fs.EvalSymlinks(opts.FS, opts.DestinationPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve destination symlink: %w", err)
+	}
+	targetExists, err := pathExists(opts.FS, j.Target)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case current == j.Target:
+		// The symlink was switched.
+	case targetExists && j.Reached(journal.PhaseConfigRestored):
+		if err := fs.ReplaceSymlink(opts.FS, j.Target, opts.DestinationPath); err != nil {
+			return false, fmt.Errorf("failed to repoint destination symlink: %w", err)
+		}
+	default:
+		removeIncomplete(opts.FS, j.Target)
+		return false, nil
+	}
+
+	recoverBackupRecord(opts, j)
+	return true, nil
+}
+
+// recoverMount recovers an update that copies the mounted installation to a
+// backup and synchronizes it in place, which may leave it half synchronized.
+func recoverMount(ctx context.Context, opts Options, j *journal.Journal) (bool, error) {
+	if !j.Reached(journal.PhaseBackedUp) {
+		// The installation was not modified yet; the backup may be partial.
+		removeIncomplete(opts.FS, j.Backup)
+		return false, nil
+	}
+
+	if !j.Reached(journal.PhaseSwapped) {
+		releaseExists, err := pathExists(opts.FS, j.Release)
+		if err != nil {
+			return false, err
+		}
+		if !releaseExists {
+			if err := fs.MirrorDir(ctx, opts.FS, j.Backup, opts.DestinationPath); err != nil {
+				return false, fmt.Errorf("failed to restore mounted destination from %s: %w", j.Backup, err)
+			}
+			return false, nil
+		}
+
+		if !j.Reached(journal.PhaseConfigRestored) {
+			if err := restoreUserFiles(ctx, opts, j.Backup, j.Release); err != nil {
+				return false, err
+			}
+		}
+		if err := fs.MirrorDir(ctx, opts.FS, j.Release, opts.DestinationPath); err != nil {
+			return false, fmt.Errorf("failed to synchronize mounted destination: %w", err)
+		}
+	}
+
+	recoverBackupRecord(opts, j)
+	return true, nil
+}
+
+// recoverRelease recovers an update of the releases layout, which may also
+// have been adopting a plain installation directory as the first release.
+func recoverRelease(opts Options, j *journal.Journal) (bool, error) {
+	layout := &release.Layout{FS: opts.FS, Root: opts.ReleasesDir, Link: opts.DestinationPath}
+
+	if j.Adopted != "" {
+		linkExists, err := pathExists(opts.FS, layout.Link)
+		if err != nil {
+			return false, err
+		}
+		adoptedExists, err := pathExists(opts.FS, j.Adopted)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case linkExists:
+		case adoptedExists:
+			if err := layout.Activate(filepath.Base(j.Adopted)); err != nil {
+				return false, err
+			}
+			fmt.Printf("Adopted existing installation as release %s\n", filepath.Base(j.Adopted))
+		default:
+			return false, fmt.Errorf("installation %s is missing and was not adopted as release %s",
+				layout.Link, j.Adopted)
+		}
+	}
+
+	if j.Target == "" {
+		return false, nil
+	}
+	name := filepath.Base(j.Target)
+	current, err := layout.Current()
+	if err != nil {
+		// A fresh installation keeps its empty directory until the switch.
+		if empty, _ := isEmptyDir(opts.FS, layout.Link); !empty {
+			return false, err
+		}
+	}
+	targetExists, err := pathExists(opts.FS, j.Target)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case current == name:
+	case targetExists && j.Reached(journal.PhaseConfigRestored):
+		restoreTarget, err := clearInstallTarget(opts.FS, layout.Link)
+		if err != nil {
+			return false, err
+		}
+		if err := layout.Activate(name); err != nil {
+			restoreTarget()
+			return false, err
+		}
+		fmt.Printf("Activated release %s\n", name)
+	default:
+		removeIncomplete(opts.FS, j.Target)
+		return false, nil
+	}
+
+	if j.Backup != "" {
+		recoverBackupRecord(opts, j)
+	}
+	return true, nil
+}
+
+// recoverBackupRecord records the backup of a finished interrupted update.
+func recoverBackupRecord(opts Options, j *journal.Journal) {
+	if err := recordBackup(opts.FS, opts.DestinationPath, j.Backup, j.InstalledVersion, j.BackupTime); err != nil {
+		fmt.Printf("warning: failed to record backup in index: %v\n", err)
+	}
+}
+
+// pathExists reports whether path exists on fsys, without following symlinks.
+// An empty path does not exist.
+func pathExists(fsys fs.FS, path string) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	_, err := fsys.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return true, nil
+}
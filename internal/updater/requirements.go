@@ -0,0 +1,54 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/php"
+)
+
+// DefaultPHPBinary is the PHP binary release requirements are checked
+// against when none is configured, looked up in PATH.
+const DefaultPHPBinary = "php"
+
+// checkRequirements refuses a release whose composer.json requires a PHP
+// version or extensions that opts.PHPBinary does not provide. A release that
+// declares no requirements is not checked, and neither is one when the default
+// PHP binary is not installed, e.g. on a host that only stores the files.
+func checkRequirements(ctx context.Context, opts Options, releaseVersion, releasePath string) error {
+	if opts.SkipPHPCheck {
+		return nil
+	}
+	req, err := php.ReadRequirements(opts.FS, releasePath)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("warning: phpMyAdmin %s does not declare its PHP requirements, not checking them\n", releaseVersion)
+		return nil
+	}
+	if err != nil {
+		fmt.Printf("warning: cannot check PHP requirements: %v\n", err)
+		return nil
+	}
+
+	binary := opts.PHPBinary
+	if binary == "" {
+		binary = DefaultPHPBinary
+	}
+	host, err := opts.ProbePHP(ctx, binary)
+	if err != nil {
+		if ctx.Err() == nil && opts.PHPBinary == "" && errors.Is(err, exec.ErrNotFound) {
+			fmt.Printf("warning: cannot check PHP requirements, %s is not installed: set the PHP binary to check them\n", binary)
+			return nil
+		}
+		return fmt.Errorf("failed to check PHP requirements: %w", err)
+	}
+
+	if problems := php.Check(req, host); len(problems) > 0 {
+		return fmt.Errorf("phpMyAdmin %s cannot run on the PHP installation of %s: %s", releaseVersion, binary, strings.Join(problems, "; "))
+	}
+	fmt.Printf("PHP %s meets the requirements of phpMyAdmin %s\n", host.Version, releaseVersion)
+	return nil
+}
@@ -0,0 +1,187 @@
+package updater
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/backup"
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/lock"
+	"github.com/jsas4coding/pma-up/internal/version"
+)
+
+// Rollback restores the newest backup of the installation recorded in the
+// backup index, or the newest one holding opts.Version when it is set. The
+// installation it replaces is recorded as a backup in turn, so that a second
+// rollback switches back.
+//
+// The backup is put in place the way an update puts a release in place: a
+// symlink installation path, as with the releases layout, is repointed at it,
+// a mount point is synchronized with it, and a plain directory is moved to a
+// new backup and replaced by it.
+//
+// Parameters:
+//   - ctx: cancels the rollback up to the swap; an interrupted update found
+//     on the way is recovered regardless.
+//   - opts: update options locating the installation; see Options for defaults.
+//
+// Returns:
+//   - error: non-nil if no matching backup is recorded or it cannot be put
+//     in place.
+func Rollback(ctx context.Context, opts Options) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	destinationPath := opts.DestinationPath
+
+	runLock, err := lock.Acquire(ctx, opts.FS, lock.Path(destinationPath), opts.LockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock destination: %w", err)
+	}
+	defer func() {
+		if releaseErr := runLock.Release(); releaseErr != nil {
+			fmt.Printf("warning: failed to release lock: %v\n", releaseErr)
+		}
+	}()
+
+	journalPath := journal.Path(destinationPath)
+	if err := recoverInterrupted(context.WithoutCancel(ctx), opts, journalPath); err != nil {
+		return fmt.Errorf("failed to recover interrupted update: %w", err)
+	}
+
+	info, err := opts.FS.Lstat(destinationPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("destination %s does not exist", destinationPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat destination: %w", err)
+	}
+	active, err := fs.EvalSymlinks(opts.FS, destinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination: %w", err)
+	}
+
+	idx, err := backup.Load(opts.FS, backup.IndexPath(destinationPath))
+	if err != nil {
+		return err
+	}
+	entry, err := rollbackTarget(opts, idx, absPath(active))
+	if err != nil {
+		return err
+	}
+
+	installedVersion, err := version.DetectInstalled(opts.FS, destinationPath)
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+
+	// The backup takes the place of the release of an update, so that an
+	// interrupted rollback is recovered like one.
+	p := &progress{fsys: opts.FS, path: journalPath, journal: &journal.Journal{
+		Version:   entry.Version,
+		Release:   entry.Path,
+		StartedAt: time.Now().UTC(),
+	}}
+	if err := restoreBackup(ctx, opts, p, info, entry, installedVersion); err != nil {
+		if p.settled() || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			if removeErr := journal.Remove(opts.FS, journalPath); removeErr != nil {
+				fmt.Printf("warning: %v\n", removeErr)
+			}
+		}
+		return err
+	}
+	if err := journal.Remove(opts.FS, journalPath); err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+
+	fmt.Printf("Rolled back %s to phpMyAdmin %s from the backup taken at %s\n", destinationPath,
+		cmp.Or(entry.Version, "(unknown version)"), entry.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// rollbackTarget returns the newest backup of the installation recorded in
+// idx, holding opts.Version when it is set, that still exists and is not the
+// active tree.
+func rollbackTarget(opts Options, idx *backup.Index, active string) (backup.Entry, error) {
+	for _, entry := range idx.ForSource(absPath(opts.DestinationPath)) {
+		if entry.Path == active || (opts.Version != "" && entry.Version != opts.Version) {
+			continue
+		}
+		info, err := opts.FS.Stat(entry.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return backup.Entry{}, fmt.Errorf("failed to stat backup %s: %w", entry.Path, err)
+		}
+		if info.IsDir() {
+			return entry, nil
+		}
+	}
+
+	if opts.Version != "" {
+		return backup.Entry{}, fmt.Errorf("no backup of phpMyAdmin %s recorded for %s", opts.Version, opts.DestinationPath)
+	}
+	return backup.Entry{}, fmt.Errorf("no backup recorded for %s to roll back to", opts.DestinationPath)
+}
+
+// restoreBackup puts the backup described by entry in place of the
+// installation, whose path has the given info and which holds installedVersion.
+func restoreBackup(ctx context.Context, opts Options, p *progress, info os.FileInfo, entry backup.Entry, installedVersion string) error {
+	destinationPath := opts.DestinationPath
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		currentTarget, err := fs.EvalSymlinks(opts.FS, destinationPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve destination symlink: %w", err)
+		}
+		if _, err := p.beginSwap(ctx); err != nil {
+			return err
+		}
+		switchTime := time.Now()
+		if err := fs.ReplaceSymlink(opts.FS, entry.Path, destinationPath); err != nil {
+			return fmt.Errorf("failed to repoint destination symlink: %w", err)
+		}
+		fmt.Printf("Repointed %s to %s\n", destinationPath, entry.Path)
+
+		if err := recordBackup(opts.FS, destinationPath, currentTarget, installedVersion, switchTime); err != nil {
+			fmt.Printf("warning: failed to record backup in index: %v\n", err)
+		}
+		forgetBackup(opts, entry.Path)
+		return nil
+	}
+
+	mounted, err := fs.IsMountPoint(opts.FS, destinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to check for mount point: %w", err)
+	}
+	if mounted {
+		// The backup is copied, so it stays a backup.
+		return mirrorInPlace(ctx, opts, p, entry.Path, installedVersion)
+	}
+
+	if err := swapInPlace(ctx, opts, p, entry.Path, installedVersion); err != nil {
+		return err
+	}
+	forgetBackup(opts, entry.Path)
+	return nil
+}
+
+// forgetBackup drops the backup at path, which is now the installation, from
+// the backup index. Failures are only reported as warnings.
+func forgetBackup(opts Options, path string) {
+	indexPath := backup.IndexPath(opts.DestinationPath)
+	idx, err := backup.Load(opts.FS, indexPath)
+	if err == nil && idx.Remove(path) {
+		err = idx.Save(opts.FS, indexPath)
+	}
+	if err != nil {
+		fmt.Printf("warning: failed to remove restored backup %s from index: %v\n", path, err)
+	}
+}
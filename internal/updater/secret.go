@@ -0,0 +1,162 @@
+package updater
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/phpconfig"
+)
+
+// BlowfishSecretLength is the length, in bytes, phpMyAdmin requires of
+// $cfg['blowfish_secret'] to encrypt cookies without a warning: the key size
+// of sodium's secretbox.
+const BlowfishSecretLength = 32
+
+// secretAlphabet holds the characters of generated secrets: printable, and
+// needing no escaping in a PHP string literal.
+const secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&()*+,-./:;<=>?@[]^_{|}~"
+
+var blowfishSecretKey = phpconfig.Key{"blowfish_secret"}
+
+// checkSecret checks $cfg['blowfish_secret'] in the configuration file at
+// configPath, called name in messages. A missing secret, or one not exactly
+// BlowfishSecretLength bytes long, is reported and, with
+// opts.FixBlowfishSecret, replaced by a generated one after the original file
+// is backed up next to the installation's backups.
+func checkSecret(opts Options, configPath, name string) error {
+	// Rewrite the file a symlinked configuration points to, not the link.
+	configPath, err := fs.EvalSymlinks(opts.FS, configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve config file: %w", err)
+	}
+	data, err := fs.ReadFile(opts.FS, configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	problem, err := secretProblem(data)
+	if err != nil {
+		fmt.Printf("warning: cannot check blowfish_secret in %s: %v\n", name, err)
+		return nil
+	}
+	if problem == "" {
+		return nil
+	}
+	if !opts.FixBlowfishSecret {
+		fmt.Printf("warning: %s: blowfish_secret %s; phpMyAdmin requires exactly %d bytes (see -fix-blowfish-secret)\n",
+			name, problem, BlowfishSecretLength)
+		return nil
+	}
+
+	secret, err := generateSecret(BlowfishSecretLength)
+	if err != nil {
+		return fmt.Errorf("failed to generate blowfish_secret: %w", err)
+	}
+	fixed, err := phpconfig.SetString(data, blowfishSecretKey, secret)
+	if err != nil {
+		return fmt.Errorf("failed to update blowfish_secret: %w", err)
+	}
+	backupPath := configBackupPath(opts.DestinationPath, configPath, time.Now())
+	if err := fs.CopyFile(opts.FS, configPath, backupPath); err != nil {
+		return fmt.Errorf("failed to back up config file: %w", err)
+	}
+	if err := rewriteFile(opts.FS, configPath, fixed); err != nil {
+		return fmt.Errorf("failed to update blowfish_secret: %w", err)
+	}
+	fmt.Printf("Generated a new blowfish_secret in %s (blowfish_secret %s); the original is saved as %s\n",
+		name, problem, backupPath)
+	return nil
+}
+
+// configBackupPath returns where the configuration file at configPath is saved
+// at t before its secret is replaced: a hidden file next to the installation
+// at destinationPath and its backups, so that it is never inside the tree the
+// web server serves. It keeps the .php extension, so that a server exposing
+// the directory anyway runs it rather than serving the old secret.
+func configBackupPath(destinationPath, configPath string, t time.Time) string {
+	dir, base := filepath.Split(filepath.Clean(destinationPath))
+	ext := filepath.Ext(configPath)
+	name := strings.TrimSuffix(filepath.Base(configPath), ext)
+	return filepath.Join(dir, fmt.Sprintf(".%s.%s.pma-up-backup-%s%s", base, name, t.Format("20060102150405"), ext))
+}
+
+// secretProblem describes what is wrong with the blowfish_secret set by the
+// PHP source data, or returns "" when it is fine.
+func secretProblem(data []byte) (string, error) {
+	directives, err := phpconfig.Parse(data)
+	if err != nil {
+		return "", err
+	}
+
+	var secret *phpconfig.Directive
+	for i := range directives {
+		if slices.Equal(directives[i].Key, blowfishSecretKey) {
+			secret = &directives[i]
+		}
+	}
+	if secret == nil {
+		return "is not set", nil
+	}
+	value, ok := secret.StringValue()
+	if !ok {
+		return "", fmt.Errorf("line %d: value %s is not a constant string", secret.Line, secret.Expr)
+	}
+	switch {
+	case value == "":
+		return "is empty", nil
+	case len(value) < BlowfishSecretLength:
+		return fmt.Sprintf("is too short (%d bytes)", len(value)), nil
+	case len(value) > BlowfishSecretLength:
+		return fmt.Sprintf("is too long (%d bytes)", len(value)), nil
+	}
+	return "", nil
+}
+
+// generateSecret returns a random string of n characters from secretAlphabet.
+func generateSecret(n int) (string, error) {
+	secret := make([]byte, n)
+	size := big.NewInt(int64(len(secretAlphabet)))
+	for i := range secret {
+		index, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		secret[i] = secretAlphabet[index.Int64()]
+	}
+	return string(secret), nil
+}
+
+// rewriteFile replaces the content of the file at path with data, keeping its
+// mode. The data is written to a temporary file renamed over path, so that
+// path is never seen partially written.
+func rewriteFile(fsys fs.FS, path string, data []byte) error {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".pma-up-tmp"
+	if err := fs.WriteFile(fsys, tmpPath, data, info.Mode().Perm()); err != nil {
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	if err := fsys.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	if err := fsys.Rename(tmpPath, path); err != nil {
+		_ = fsys.RemoveAll(tmpPath)
+		return err
+	}
+	return fs.SyncDir(fsys, filepath.Dir(path))
+}
@@ -0,0 +1,195 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsas4coding/pma-up/internal/extractor"
+	"github.com/jsas4coding/pma-up/internal/fs"
+)
+
+// spacePlan accumulates the disk space an update needs on each filesystem it
+// writes to.
+type spacePlan struct {
+	fsys  fs.FS
+	needs []*spaceNeed
+}
+
+// spaceNeed is the space needed on one filesystem.
+type spaceNeed struct {
+	usage fs.Usage
+	path  string
+	bytes uint64
+	what  []string
+}
+
+// add records that bytes are needed for what on the filesystem holding path.
+func (p *spacePlan) add(path string, bytes uint64, what string) error {
+	usage, err := fs.DiskUsage(p.fsys, path)
+	if err != nil {
+		return err
+	}
+
+	for _, need := range p.needs {
+		if need.usage.Device == usage.Device {
+			need.bytes += bytes
+			need.what = append(need.what, what)
+			return nil
+		}
+	}
+	p.needs = append(p.needs, &spaceNeed{usage: usage, path: path, bytes: bytes, what: []string{what}})
+	return nil
+}
+
+// addMove records the bytes a tree moved from source into the directory
+// parent needs there, which is nothing unless the move crosses filesystems
+// and turns into a copy.
+func (p *spacePlan) addMove(source, parent string, bytes uint64, what string) error {
+	sourceUsage, err := fs.DiskUsage(p.fsys, source)
+	if err != nil {
+		return err
+	}
+	parentUsage, err := fs.DiskUsage(p.fsys, parent)
+	if err != nil {
+		return err
+	}
+	if sourceUsage.Device == parentUsage.Device {
+		return nil
+	}
+	return p.add(parent, bytes, what)
+}
+
+// check fails with fs.ErrNoSpace when a filesystem lacks the space needed on it.
+func (p *spacePlan) check() error {
+	for _, need := range p.needs {
+		if need.bytes > need.usage.Free {
+			return fmt.Errorf("%w on the filesystem of %s: %s needed for the %s, %s available",
+				fs.ErrNoSpace, need.path, fs.HumanSize(need.bytes), strings.Join(need.what, " and "),
+				fs.HumanSize(need.usage.Free))
+		}
+	}
+	return nil
+}
+
+// journalReserve is the space kept for the update journal, which is written
+// next to the installation along with its temporary copy.
+const journalReserve = 8 << 10
+
+// checkDiskSpace makes sure the downloaded release at zipPath can be extracted
+// into stagingDir and put in place, including any tree that has to be copied
+// rather than renamed, and that the journal at journalPath can be written,
+// before anything is written.
+func checkDiskSpace(opts Options, journalPath, zipPath, stagingDir string) error {
+	err := planDiskSpace(opts, journalPath, zipPath, stagingDir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		fmt.Printf("warning: free disk space cannot be checked on this platform\n")
+		return nil
+	}
+	return err
+}
+
+func planDiskSpace(opts Options, journalPath, zipPath, stagingDir string) error {
+	releaseSize, err := extractor.UncompressedSize(opts.FS, zipPath)
+	if err != nil {
+		return fmt.Errorf("failed to read release size: %w", err)
+	}
+
+	plan := &spacePlan{fsys: opts.FS}
+	if err := plan.add(stagingDir, releaseSize, "extracted release"); err != nil {
+		return err
+	}
+	if err := planInstall(opts, plan, stagingDir, releaseSize); err != nil {
+		return err
+	}
+	// The journal written so far already takes part of its reserve.
+	reserve := uint64(journalReserve)
+	if info, err := opts.FS.Stat(journalPath); err == nil {
+		reserve -= min(reserve, uint64(info.Size()))
+	}
+	if err := plan.add(filepath.Dir(journalPath), reserve, "update journal"); err != nil {
+		return err
+	}
+	return plan.check()
+}
+
+// planInstall records the space putting a release of releaseSize bytes in
+// place needs, following the strategy Run will pick for the layout and the
+// kind of installation path.
+func planInstall(opts Options, plan *spacePlan, stagingDir string, releaseSize uint64) error {
+	destinationPath := opts.DestinationPath
+	info, err := opts.FS.Lstat(destinationPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	isSymlink := info.Mode()&os.ModeSymlink != 0
+
+	// Preserved user files are copied from the installed tree into the new
+	// release, wherever it is when the configuration file is restored.
+	installedTree := destinationPath
+	if isSymlink {
+		if installedTree, err = fs.EvalSymlinks(opts.FS, destinationPath); err != nil {
+			return err
+		}
+	}
+	preserved, err := preservedSize(opts, installedTree)
+	if err != nil {
+		return err
+	}
+	addPreserved := func(releaseParent string) error {
+		if preserved == 0 {
+			return nil
+		}
+		return plan.add(releaseParent, preserved, "preserved user files")
+	}
+
+	if opts.Layout == LayoutReleases {
+		if err := plan.addMove(stagingDir, opts.ReleasesDir, releaseSize, "release copy"); err != nil {
+			return err
+		}
+		if err := addPreserved(opts.ReleasesDir); err != nil {
+			return err
+		}
+		if isSymlink {
+			return nil
+		}
+		installedSize, err := fs.DirSize(opts.FS, destinationPath)
+		if err != nil {
+			return err
+		}
+		return plan.addMove(destinationPath, opts.ReleasesDir, installedSize, "adopted installation copy")
+	}
+
+	if isSymlink {
+		if err := addPreserved(filepath.Dir(installedTree)); err != nil {
+			return err
+		}
+		return plan.addMove(stagingDir, filepath.Dir(installedTree), releaseSize, "release copy")
+	}
+
+	if err := addPreserved(stagingDir); err != nil {
+		return err
+	}
+
+	mounted, err := fs.IsMountPoint(opts.FS, destinationPath)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return plan.addMove(stagingDir, filepath.Dir(destinationPath), releaseSize, "release copy")
+	}
+
+	installedSize, err := fs.DirSize(opts.FS, destinationPath)
+	if err != nil {
+		return err
+	}
+	if err := plan.add(filepath.Dir(destinationPath), installedSize, "backup copy"); err != nil {
+		return err
+	}
+	return plan.add(destinationPath, releaseSize, "synchronized release")
+}
@@ -0,0 +1,104 @@
+package updater
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jsas4coding/pma-up/internal/backup"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/release"
+	"github.com/jsas4coding/pma-up/internal/version"
+)
+
+// Status writes a report of the installation to w: the phpMyAdmin version it
+// serves, its releases when it uses the releases layout, an interrupted update
+// awaiting recovery, and its backups as recorded in the backup index.
+//
+// Parameters:
+//   - opts: update options locating the installation; see Options for defaults.
+//   - w: destination of the report.
+//
+// Returns:
+//   - error: non-nil if the installation, its releases or its backup index
+//     cannot be read.
+func Status(opts Options, w io.Writer) error {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	destinationPath := opts.DestinationPath
+
+	info, err := opts.FS.Lstat(destinationPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Fprintf(w, "Installation: %s (missing)\n", destinationPath)
+	case err != nil:
+		return fmt.Errorf("failed to stat destination: %w", err)
+	default:
+		installed, err := version.DetectInstalled(opts.FS, destinationPath)
+		if err != nil {
+			installed = "unknown version"
+		}
+		fmt.Fprintf(w, "Installation: %s (phpMyAdmin %s)\n", destinationPath, installed)
+	}
+
+	if info != nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := writeReleases(opts, w); err != nil {
+			return err
+		}
+	}
+
+	j, err := journal.Load(opts.FS, journal.Path(destinationPath))
+	if err != nil {
+		return err
+	}
+	if j != nil {
+		fmt.Fprintf(w, "Interrupted update to %s after phase %q, recovered by the next run\n", j.Version, j.LastPhase())
+	}
+
+	idx, err := backup.Load(opts.FS, backup.IndexPath(destinationPath))
+	if err != nil {
+		return err
+	}
+	entries := idx.ForSource(absPath(destinationPath))
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "Backups: none")
+		return nil
+	}
+	fmt.Fprintln(w, "Backups, newest first:")
+	for _, entry := range entries {
+		state := ""
+		if _, err := opts.FS.Lstat(entry.Path); errors.Is(err, os.ErrNotExist) {
+			state = " (missing)"
+		}
+		fmt.Fprintf(w, "  %s  %s  %s  %d bytes  %s%s\n", entry.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			cmp.Or(entry.Version, "unknown"), entry.Path, entry.Size, entry.Checksum, state)
+	}
+	return nil
+}
+
+// writeReleases lists the releases of the releases layout, marking the active one.
+func writeReleases(opts Options, w io.Writer) error {
+	layout := &release.Layout{FS: opts.FS, Root: opts.ReleasesDir, Link: opts.DestinationPath}
+	current, err := layout.Current()
+	if err != nil {
+		// A symlink outside the releases root is the symlink strategy.
+		return nil
+	}
+	names, err := layout.Releases()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Releases in %s, oldest first:\n", opts.ReleasesDir)
+	for _, name := range names {
+		marker := " "
+		if name == current {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s %s\n", marker, name)
+	}
+	return nil
+}
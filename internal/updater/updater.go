@@ -4,17 +4,29 @@
 package updater
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/jsas4coding/pma-up/internal/backup"
 	"github.com/jsas4coding/pma-up/internal/downloader"
 	"github.com/jsas4coding/pma-up/internal/extractor"
 	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/lock"
+	"github.com/jsas4coding/pma-up/internal/manifest"
+	"github.com/jsas4coding/pma-up/internal/patch"
+	"github.com/jsas4coding/pma-up/internal/release"
 	"github.com/jsas4coding/pma-up/internal/version"
 )
 
+// ToolVersion is the pma-up version recorded in the backup index.
+// Release builds override it through -ldflags.
+var ToolVersion = "dev"
+
 // RunUpdate performs the phpMyAdmin update process.
 //
 // It downloads the latest phpMyAdmin release, extracts its content, backs up
@@ -28,64 +40,771 @@ import (
 // Returns:
 //   - error: non-nil if any operation fails during the update process.
 func RunUpdate(destinationPath, configFilePath string) error {
-	fmt.Println("Starting phpMyAdmin update process...")
+	return Run(context.Background(), Options{
+		DestinationPath: destinationPath,
+		ConfigFilePath:  configFilePath,
+	})
+}
 
-	latestVersion, err := version.FetchLatestVersion()
+// Run performs the phpMyAdmin update process configured by opts.
+//
+// It downloads and extracts the latest phpMyAdmin release, or opts.Version,
+// and puts it in place according to the selected layout, preserving the
+// configuration file and the user files matching opts.Preserve. With
+// opts.Install, it instead installs the release into a missing or empty
+// destination, creating its configuration file and tmp directory.
+//
+// Cancelling ctx stops the update cleanly as long as the installation has not
+// been touched. Once the swap has started it runs to completion, so that the
+// installation is never left half replaced.
+//
+// Parameters:
+//   - ctx: cancels the update up to the swap.
+//   - opts: update options; see Options for defaults.
+//
+// Returns:
+//   - error: non-nil if any operation fails during the update process, or if
+//     ctx is cancelled before the swap.
+func Run(ctx context.Context, opts Options) error {
+	opts, err := opts.withDefaults()
 	if err != nil {
-		return fmt.Errorf("failed to fetch latest version: %w", err)
+		return fmt.Errorf("invalid options: %w", err)
 	}
-	fmt.Printf("Latest version: %s (%s)\n", latestVersion.Version, latestVersion.Date)
 
-	tempDir, err := os.MkdirTemp("", "pma-up-*")
+	if opts.Install {
+		fmt.Println("Starting phpMyAdmin installation...")
+		// The lock and journal live next to the installation path.
+		if err := opts.FS.MkdirAll(filepath.Dir(opts.DestinationPath), 0755); err != nil {
+			return fmt.Errorf("failed to create parent of destination: %w", err)
+		}
+	} else {
+		fmt.Println("Starting phpMyAdmin update process...")
+	}
+
+	runLock, err := lock.Acquire(ctx, opts.FS, lock.Path(opts.DestinationPath), opts.LockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock destination: %w", err)
+	}
+	defer func() {
+		if releaseErr := runLock.Release(); releaseErr != nil {
+			fmt.Printf("warning: failed to release lock: %v\n", releaseErr)
+		}
+	}()
+
+	// Recovery brings the installation back to a consistent state and is
+	// never interrupted halfway.
+	journalPath := journal.Path(opts.DestinationPath)
+	if err := recoverInterrupted(context.WithoutCancel(ctx), opts, journalPath); err != nil {
+		return fmt.Errorf("failed to recover interrupted update: %w", err)
+	}
+	removeOrphans(ctx, opts)
+
+	if opts.Install {
+		if err := checkInstallTarget(opts); err != nil {
+			return err
+		}
+	} else if _, err := opts.FS.Lstat(opts.DestinationPath); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("destination %s does not exist; use install mode to install phpMyAdmin there", opts.DestinationPath)
+	}
+	if err := checkConfig(opts); err != nil {
+		return err
+	}
+	patches, err := loadPatches(opts)
+	if err != nil {
+		return err
+	}
+
+	latestVersion, err := targetVersion(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	tempDir, err := opts.FS.MkdirTemp("", "pma-up-*")
 	if err != nil {
 		return fmt.Errorf("failed to create temporary directory: %w", err)
 	}
 	defer func() {
-		if removeErr := os.RemoveAll(tempDir); removeErr != nil {
+		if removeErr := opts.FS.RemoveAll(tempDir); removeErr != nil {
 			fmt.Printf("warning: failed to remove temp directory: %v\n", removeErr)
 		}
 	}()
+	tempLock, err := lockWorkDir(ctx, opts.FS, tempDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock temporary directory: %w", err)
+	}
+	defer releaseWorkDir(tempLock)
+
+	stagingDir, err := createStagingDir(opts)
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() {
+		if removeErr := opts.FS.RemoveAll(stagingDir); removeErr != nil {
+			fmt.Printf("warning: failed to remove staging directory: %v\n", removeErr)
+		}
+	}()
+	stagingLock, err := lockWorkDir(ctx, opts.FS, stagingDir)
+	if err != nil {
+		return fmt.Errorf("failed to lock staging directory: %w", err)
+	}
+	defer releaseWorkDir(stagingLock)
+
+	p := &progress{fsys: opts.FS, path: journalPath, journal: &journal.Journal{
+		Version:    latestVersion.Version,
+		TempDir:    tempDir,
+		StagingDir: stagingDir,
+		StartedAt:  time.Now().UTC(),
+	}}
+	if err := p.save(); err != nil {
+		return err
+	}
+
+	if err := applyRelease(ctx, opts, p, latestVersion, patches); err != nil {
+		// Cancellation only ever stops the update before the swap, and other
+		// failures up to the swap, or undone by the failure path, leave
+		// nothing for the next run to recover either.
+		if p.settled() || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			if removeErr := journal.Remove(opts.FS, journalPath); removeErr != nil {
+				fmt.Printf("warning: %v\n", removeErr)
+			}
+		}
+		return err
+	}
+
+	if err := journal.Remove(opts.FS, journalPath); err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+	if err := pruneBackups(opts); err != nil {
+		fmt.Printf("warning: failed to prune backups: %v\n", err)
+	}
+
+	if opts.Install {
+		fmt.Println("phpMyAdmin installation completed successfully.")
+	} else {
+		fmt.Println("phpMyAdmin update process completed successfully.")
+	}
+	return nil
+}
+
+// applyRelease fetches the given release, checks the host's PHP meets its
+// requirements, applies the local patches to it, checks the current
+// installation for locally modified core files, unless installing afresh, and
+// puts the release in place according to the selected layout, journaling its
+// progress in p.
+func applyRelease(ctx context.Context, opts Options, p *progress, latestVersion *version.PhpMyAdminVersion, patches []*patch.Patch) error {
+	extractedContentPath, err := fetchRelease(ctx, opts, p, latestVersion)
+	if err != nil {
+		return err
+	}
+	if err := checkRequirements(ctx, opts, latestVersion.Version, extractedContentPath); err != nil {
+		return err
+	}
+
+	// The manifest describes the release as shipped, before local patches.
+	release, err := manifest.Build(opts.FS, extractedContentPath, latestVersion.Version)
+	if err != nil {
+		return fmt.Errorf("failed to build release manifest: %w", err)
+	}
+	patched, err := applyPatches(opts, patches, latestVersion.Version, extractedContentPath)
+	if err != nil {
+		return err
+	}
+	if !opts.Install {
+		if err := handleModified(ctx, opts, p, release, patched, extractedContentPath); err != nil {
+			return err
+		}
+	}
+	reportDirectives(opts, latestVersion.Version, extractedContentPath)
+	if err := release.Save(opts.FS, extractedContentPath); err != nil {
+		return err
+	}
+
+	switch {
+	case opts.Layout == LayoutReleases:
+		return installRelease(ctx, opts, p, latestVersion, extractedContentPath)
+	case opts.Install:
+		return installFresh(ctx, opts, p, extractedContentPath)
+	default:
+		return replaceInPlace(ctx, opts, p, latestVersion, extractedContentPath)
+	}
+}
 
-	zipFilePath, err := downloader.DownloadPhpMyAdmin(latestVersion.URL, tempDir, latestVersion.Version)
+// createStagingDir creates a fresh directory to extract the new release into.
+//
+// Unless opts.StagingDir is set, it is a hidden sibling of the directory the
+// release will finally live in, so that putting the release in place is a
+// rename on the same filesystem rather than a copy.
+func createStagingDir(opts Options) (string, error) {
+	parent := opts.StagingDir
+	if parent != "" {
+		if err := opts.FS.MkdirAll(parent, 0755); err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		if parent, err = installParent(opts); err != nil {
+			return "", err
+		}
+	}
+
+	return opts.FS.MkdirTemp(parent, fs.StagingPattern(opts.DestinationPath))
+}
+
+// installParent returns the directory in which the new release tree will be placed.
+func installParent(opts Options) (string, error) {
+	if opts.Layout == LayoutReleases {
+		if err := opts.FS.MkdirAll(opts.ReleasesDir, 0755); err != nil {
+			return "", err
+		}
+		return opts.ReleasesDir, nil
+	}
+
+	info, err := opts.FS.Lstat(opts.DestinationPath)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.EvalSymlinks(opts.FS, opts.DestinationPath)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Dir(target), nil
+	}
+
+	return filepath.Dir(opts.DestinationPath), nil
+}
+
+// fetchRelease downloads the given release into the temporary directory of
+// the journal, checks there is enough disk space to install it, extracts it
+// into its staging directory and returns the path of the extracted phpMyAdmin tree.
+func fetchRelease(ctx context.Context, opts Options, p *progress, latestVersion *version.PhpMyAdminVersion) (string, error) {
+	fsys := opts.FS
+	stagingDir := p.journal.StagingDir
+	zipFilePath, err := downloader.DownloadPhpMyAdmin(ctx, fsys, latestVersion.URL, p.journal.TempDir, latestVersion.Version)
 	if err != nil {
-		return fmt.Errorf("failed to download phpMyAdmin: %w", err)
+		return "", fmt.Errorf("failed to download phpMyAdmin: %w", err)
 	}
 
-	extractDir := filepath.Join(tempDir, "extracted")
-	if err := os.MkdirAll(extractDir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create extraction directory: %w", err)
+	if err := checkDiskSpace(opts, p.path, zipFilePath, stagingDir); err != nil {
+		if errors.Is(err, fs.ErrNoSpace) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to check free disk space: %w", err)
+	}
+	if err := p.complete(journal.PhaseDownloaded); err != nil {
+		return "", err
 	}
 
-	if err := extractor.ExtractZip(zipFilePath, extractDir); err != nil {
-		return fmt.Errorf("failed to extract phpMyAdmin: %w", err)
+	extractDir := filepath.Join(stagingDir, "extracted")
+	if err := fsys.MkdirAll(extractDir, os.ModePerm); err != nil {
+		return "", fmt.Errorf("failed to create extraction directory: %w", err)
 	}
 
-	subDirs, err := os.ReadDir(extractDir)
+	if err := extractor.ExtractZip(ctx, fsys, zipFilePath, extractDir); err != nil {
+		return "", fmt.Errorf("failed to extract phpMyAdmin: %w", err)
+	}
+
+	subDirs, err := fsys.ReadDir(extractDir)
 	if err != nil {
-		return fmt.Errorf("failed to read extraction directory: %w", err)
+		return "", fmt.Errorf("failed to read extraction directory: %w", err)
 	}
 	if len(subDirs) != 1 {
-		return fmt.Errorf("unexpected extracted directory structure")
+		return "", fmt.Errorf("unexpected extracted directory structure")
+	}
+
+	p.journal.Release = filepath.Join(extractDir, subDirs[0].Name())
+	if err := p.complete(journal.PhaseExtracted); err != nil {
+		return "", err
+	}
+	return p.journal.Release, nil
+}
+
+// replaceInPlace puts the extracted release at the installation path, choosing
+// the strategy that suits what the installation path is:
+//   - a symlink: the release gets its own directory and the link is repointed;
+//   - a mount point: the mounted directory is synchronized in place;
+//   - a plain directory: it is moved to a backup and replaced.
+func replaceInPlace(ctx context.Context, opts Options, p *progress, latestVersion *version.PhpMyAdminVersion, extractedContentPath string) error {
+	info, err := opts.FS.Lstat(opts.DestinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat destination: %w", err)
 	}
 
-	extractedContentPath := filepath.Join(extractDir, subDirs[0].Name())
+	if info.Mode()&os.ModeSymlink != 0 {
+		return replaceSymlinkTarget(ctx, opts, p, latestVersion, extractedContentPath)
+	}
+
+	mounted, err := fs.IsMountPoint(opts.FS, opts.DestinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to check for mount point: %w", err)
+	}
+	if mounted {
+		return syncMountPoint(ctx, opts, p, extractedContentPath)
+	}
+
+	return moveIntoPlace(ctx, opts, p, extractedContentPath)
+}
+
+// moveIntoPlace restores the configuration file and preserved user files into
+// the extracted release, backs up the current installation and moves the
+// release into the installation path.
+func moveIntoPlace(ctx context.Context, opts Options, p *progress, extractedContentPath string) error {
+	destinationPath := opts.DestinationPath
 
-	backupPath := fmt.Sprintf("%s_backup_%d", destinationPath, time.Now().Unix())
-	if err := fs.MoveDir(destinationPath, backupPath); err != nil {
+	installedVersion, err := version.DetectInstalled(opts.FS, destinationPath)
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+
+	if err := restoreUserFiles(ctx, opts, destinationPath, extractedContentPath); err != nil {
+		return err
+	}
+
+	return swapInPlace(ctx, opts, p, extractedContentPath, installedVersion)
+}
+
+// swapInPlace moves the installation, holding installedVersion, to a backup
+// and moves the complete tree at releasePath into its place, journaling both
+// moves in p and recording the backup.
+func swapInPlace(ctx context.Context, opts Options, p *progress, releasePath, installedVersion string) error {
+	destinationPath := opts.DestinationPath
+
+	backupTime := time.Now()
+	backupPath := newBackupPath(opts.FS, destinationPath, backupTime)
+	p.journal.Strategy = journal.StrategyInPlace
+	p.journal.InstalledVersion = installedVersion
+	p.journal.Backup, p.journal.BackupTime = backupPath, backupTime
+	if err := p.complete(journal.PhaseConfigRestored); err != nil {
+		return err
+	}
+
+	swapCtx, err := p.beginSwap(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fs.MoveDir(swapCtx, opts.FS, destinationPath, backupPath, opts.copyOptions()); err != nil {
+		// A failed move leaves the installation where it was, unless undoing it failed.
+		if exists, _ := pathExists(opts.FS, backupPath); !exists {
+			p.restored, _ = pathExists(opts.FS, destinationPath)
+		}
 		return fmt.Errorf("failed to backup existing phpMyAdmin: %w", err)
 	}
+	// Recovery tells the old tree from the new one at the installation path
+	// by this phase, so the release is not moved in unless it is recorded.
+	if err := p.complete(journal.PhaseBackedUp); err != nil {
+		if restoreErr := fs.MoveDir(swapCtx, opts.FS, backupPath, destinationPath, opts.copyOptions()); restoreErr != nil {
+			return fmt.Errorf("%w (restoring %s also failed: %v)", err, backupPath, restoreErr)
+		}
+		p.restored = true
+		return err
+	}
+
+	if err := fs.MoveDir(swapCtx, opts.FS, releasePath, destinationPath, opts.releaseCopyOptions(releasePath)); err != nil {
+		if restoreErr := fs.MoveDir(swapCtx, opts.FS, backupPath, destinationPath, opts.copyOptions()); restoreErr != nil {
+			return fmt.Errorf("failed to move new phpMyAdmin to destination: %w (restoring %s also failed: %v)",
+				err, backupPath, restoreErr)
+		}
+		p.restored = true
+		return fmt.Errorf("failed to move new phpMyAdmin to destination, previous installation restored: %w", err)
+	}
+	p.note(journal.PhaseSwapped)
+
+	if err := recordBackup(opts.FS, destinationPath, backupPath, installedVersion, backupTime); err != nil {
+		fmt.Printf("warning: failed to record backup in index: %v\n", err)
+	}
+
+	return nil
+}
+
+// replaceSymlinkTarget installs the extracted release as a new directory next
+// to the current symlink target, restores the configuration file and
+// preserved user files into it and atomically repoints the symlink. The previous target is kept as the backup.
+func replaceSymlinkTarget(ctx context.Context, opts Options, p *progress, latestVersion *version.PhpMyAdminVersion, extractedContentPath string) error {
+	destinationPath := opts.DestinationPath
+
+	currentTarget, err := fs.EvalSymlinks(opts.FS, destinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination symlink: %w", err)
+	}
+
+	installedVersion, err := version.DetectInstalled(opts.FS, currentTarget)
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
 
-	if err := fs.MoveDir(extractedContentPath, destinationPath); err != nil {
-		return fmt.Errorf("failed to move new phpMyAdmin to destination: %w", err)
+	switchTime := time.Now()
+	newTarget := filepath.Join(filepath.Dir(currentTarget),
+		fmt.Sprintf("%s-%s", filepath.Base(destinationPath), latestVersion.Version))
+	if _, err := opts.FS.Lstat(newTarget); err == nil {
+		newTarget = fmt.Sprintf("%s_%d", newTarget, switchTime.Unix())
 	}
 
-	originalConfigPath := filepath.Join(backupPath, filepath.Base(configFilePath))
-	newConfigPath := filepath.Join(destinationPath, filepath.Base(configFilePath))
+	p.journal.Strategy = journal.StrategySymlink
+	p.journal.InstalledVersion = installedVersion
+	p.journal.Target = newTarget
+	p.journal.Backup, p.journal.BackupTime = currentTarget, switchTime
+	if err := p.save(); err != nil {
+		return err
+	}
 
-	if err := fs.CopyFile(originalConfigPath, newConfigPath); err != nil {
-		return fmt.Errorf("failed to restore config file: %w", err)
+	if err := fs.MoveDir(ctx, opts.FS, extractedContentPath, newTarget, opts.releaseCopyOptions(extractedContentPath)); err != nil {
+		return fmt.Errorf("failed to move new phpMyAdmin next to symlink target: %w", err)
 	}
 
-	fmt.Println("phpMyAdmin update process completed successfully.")
+	if err := restoreUserFiles(ctx, opts, currentTarget, newTarget); err != nil {
+		abandonRelease(opts.FS, p, newTarget)
+		return err
+	}
+	if err := p.complete(journal.PhaseConfigRestored); err != nil {
+		abandonRelease(opts.FS, p, newTarget)
+		return err
+	}
+	if _, err := p.beginSwap(ctx); err != nil {
+		abandonRelease(opts.FS, p, newTarget)
+		return err
+	}
+
+	if err := fs.ReplaceSymlink(opts.FS, newTarget, destinationPath); err != nil {
+		// The symlink may already point at the new target if only making
+		// the switch durable failed.
+		if resolved, _ := fs.EvalSymlinks(opts.FS, destinationPath); resolved != newTarget {
+			abandonRelease(opts.FS, p, newTarget)
+		}
+		return fmt.Errorf("failed to repoint destination symlink: %w", err)
+	}
+	p.note(journal.PhaseSwapped)
+	fmt.Printf("Repointed %s to %s\n", destinationPath, newTarget)
+
+	if err := recordBackup(opts.FS, destinationPath, currentTarget, installedVersion, switchTime); err != nil {
+		fmt.Printf("warning: failed to record backup in index: %v\n", err)
+	}
+
+	return nil
+}
+
+// syncMountPoint updates an installation path that is a mount point and
+// therefore cannot be renamed. The configuration file and preserved user files
+// are restored into the extracted release, the mounted tree is copied to a
+// backup, and the mounted directory is then synchronized with it in place.
+func syncMountPoint(ctx context.Context, opts Options, p *progress, extractedContentPath string) error {
+	destinationPath := opts.DestinationPath
+
+	installedVersion, err := version.DetectInstalled(opts.FS, destinationPath)
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+	}
+
+	// The mounted directory is untouched until the mirror, so the release is
+	// completed and checked from it before any backup is taken.
+	if err := restoreUserFiles(ctx, opts, destinationPath, extractedContentPath); err != nil {
+		return err
+	}
+
+	return mirrorInPlace(ctx, opts, p, extractedContentPath, installedVersion)
+}
+
+// mirrorInPlace copies the mounted installation, holding installedVersion, to
+// a backup, records it and synchronizes the mounted directory with the
+// complete tree at releasePath, journaling both steps in p.
+func mirrorInPlace(ctx context.Context, opts Options, p *progress, releasePath, installedVersion string) error {
+	destinationPath := opts.DestinationPath
+
+	backupTime := time.Now()
+	backupPath := newBackupPath(opts.FS, destinationPath, backupTime)
+	p.journal.Strategy = journal.StrategyMount
+	p.journal.InstalledVersion = installedVersion
+	p.journal.Backup, p.journal.BackupTime = backupPath, backupTime
+	if err := p.complete(journal.PhaseConfigRestored); err != nil {
+		return err
+	}
+
+	if err := fs.CopyDir(ctx, opts.FS, destinationPath, backupPath, opts.copyOptions()); err != nil {
+		if removeErr := opts.FS.RemoveAll(backupPath); removeErr != nil {
+			fmt.Printf("warning: failed to remove partial backup %s: %v\n", backupPath, removeErr)
+		}
+		return fmt.Errorf("failed to backup existing phpMyAdmin: %w", err)
+	}
+	// Until the mounted directory is modified, recovery may simply discard
+	// the backup; afterwards it relies on it.
+	if err := p.complete(journal.PhaseBackedUp); err != nil {
+		return err
+	}
+
+	if err := recordBackup(opts.FS, destinationPath, backupPath, installedVersion, backupTime); err != nil {
+		fmt.Printf("warning: failed to record backup in index: %v\n", err)
+	}
+
+	swapCtx, err := p.beginSwap(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fs.MirrorDir(swapCtx, opts.FS, releasePath, destinationPath); err != nil {
+		return fmt.Errorf("failed to synchronize mounted destination: %w", err)
+	}
+	p.note(journal.PhaseSwapped)
+	fmt.Printf("Synchronized mounted destination %s in place\n", destinationPath)
+
 	return nil
 }
+
+// beginSwap marks the start of the swap, after which the installation is
+// modified. It fails if ctx is already cancelled; otherwise it returns the
+// context the swap runs under, which is never cancelled, so that a swap in
+// progress always completes.
+func (p *progress) beginSwap(ctx context.Context) (context.Context, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("update cancelled before the swap, installation left untouched: %w", err)
+	}
+	p.swapping = true
+	return context.WithoutCancel(ctx), nil
+}
+
+// abandonRelease removes a release directory that never went live. The
+// journal first stops vouching for the release, so that recovery never
+// activates a partially removed tree.
+func abandonRelease(fsys fs.FS, p *progress, path string) {
+	if p.journal.Reached(journal.PhaseConfigRestored) {
+		p.journal.Revoke(journal.PhaseConfigRestored)
+		if err := p.save(); err != nil {
+			fmt.Printf("warning: keeping release %s for recovery: %v\n", path, err)
+			return
+		}
+	}
+	p.restored = removeIncomplete(fsys, path)
+}
+
+// removeIncomplete removes a release directory that never went live and
+// reports whether it is gone.
+func removeIncomplete(fsys fs.FS, path string) bool {
+	if err := fsys.RemoveAll(path); err != nil {
+		fmt.Printf("warning: failed to remove incomplete release %s: %v\n", path, err)
+		return false
+	}
+	return true
+}
+
+// installRelease moves the extracted release into its own release directory,
+// restores the configuration file and preserved user files into it,
+// atomically switches the installation symlink to it and prunes old releases.
+//
+// An installation path that is still a plain directory is first adopted as
+// the initial release, which is the only moment it is briefly missing.
+func installRelease(ctx context.Context, opts Options, p *progress, latestVersion *version.PhpMyAdminVersion, extractedContentPath string) error {
+	layout := &release.Layout{FS: opts.FS, Root: opts.ReleasesDir, Link: opts.DestinationPath}
+
+	if err := opts.FS.MkdirAll(layout.Root, 0755); err != nil {
+		return fmt.Errorf("failed to create releases directory: %w", err)
+	}
+
+	p.journal.Strategy = journal.StrategyReleases
+	var current string
+	if !opts.Install {
+		// A fresh installation has no release to adopt or carry files from.
+		if err := adoptInstallation(ctx, opts, p, layout); err != nil {
+			return err
+		}
+		var err error
+		if current, err = layout.Current(); err != nil {
+			return err
+		}
+	}
+
+	switchTime := time.Now()
+	name := release.NewName(latestVersion.Version, switchTime)
+	p.journal.Target = layout.Path(name)
+	if current != "" {
+		// The active release is recorded as the backup once replaced.
+		installedVersion, err := version.DetectInstalled(opts.FS, layout.Path(current))
+		if err != nil {
+			fmt.Printf("warning: %v\n", err)
+		}
+		p.journal.InstalledVersion = installedVersion
+		p.journal.Backup, p.journal.BackupTime = layout.Path(current), switchTime
+	}
+	if err := p.save(); err != nil {
+		return err
+	}
+
+	if err := fs.MoveDir(ctx, opts.FS, extractedContentPath, layout.Path(name), opts.releaseCopyOptions(extractedContentPath)); err != nil {
+		return fmt.Errorf("failed to move new phpMyAdmin to release directory: %w", err)
+	}
+
+	if err := restoreUserFiles(ctx, opts, p.journal.Backup, layout.Path(name)); err != nil {
+		abandonRelease(opts.FS, p, layout.Path(name))
+		return err
+	}
+	if err := p.complete(journal.PhaseConfigRestored); err != nil {
+		abandonRelease(opts.FS, p, layout.Path(name))
+		return err
+	}
+	if _, err := p.beginSwap(ctx); err != nil {
+		abandonRelease(opts.FS, p, layout.Path(name))
+		return err
+	}
+
+	restoreTarget, err := clearInstallTarget(opts.FS, layout.Link)
+	if err != nil {
+		abandonRelease(opts.FS, p, layout.Path(name))
+		return err
+	}
+	if err := layout.Activate(name); err != nil {
+		// The release may already be active if only making the switch
+		// durable failed.
+		if active, _ := layout.Current(); active != name {
+			abandonRelease(opts.FS, p, layout.Path(name))
+			restoreTarget()
+		}
+		return err
+	}
+	p.note(journal.PhaseSwapped)
+	fmt.Printf("Activated release %s\n", name)
+
+	if p.journal.Backup != "" {
+		if err := recordBackup(opts.FS, opts.DestinationPath, p.journal.Backup, p.journal.InstalledVersion, switchTime); err != nil {
+			fmt.Printf("warning: failed to record backup in index: %v\n", err)
+		}
+	}
+
+	removed, err := layout.Prune(opts.KeepReleases)
+	if err != nil {
+		fmt.Printf("warning: failed to prune old releases: %v\n", err)
+	}
+	for _, pruned := range removed {
+		fmt.Printf("Pruned release %s\n", pruned)
+	}
+
+	return nil
+}
+
+// adoptInstallation turns an installation path that is a plain directory into
+// the first release of layout.
+func adoptInstallation(ctx context.Context, opts Options, p *progress, layout *release.Layout) error {
+	info, err := opts.FS.Lstat(layout.Link)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.Mode()&os.ModeSymlink != 0) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat destination: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("destination is neither a directory nor a symlink: %s", layout.Link)
+	}
+
+	mounted, err := fs.IsMountPoint(opts.FS, layout.Link)
+	if err != nil {
+		return fmt.Errorf("failed to check for mount point: %w", err)
+	}
+	if mounted {
+		return fmt.Errorf("destination %s is a mount point and cannot become a release symlink; use the in-place layout", layout.Link)
+	}
+
+	installedVersion, err := version.DetectInstalled(opts.FS, layout.Link)
+	if err != nil {
+		fmt.Printf("warning: %v\n", err)
+		installedVersion = "unknown"
+	}
+
+	name := release.NewName(installedVersion, info.ModTime())
+	p.journal.Adopted = layout.Path(name)
+	if err := p.save(); err != nil {
+		return err
+	}
+
+	// The installation is briefly missing while it is adopted.
+	swapCtx, err := p.beginSwap(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fs.MoveDir(swapCtx, opts.FS, layout.Link, layout.Path(name), opts.copyOptions()); err != nil {
+		return fmt.Errorf("failed to adopt existing phpMyAdmin as release: %w", err)
+	}
+
+	if err := layout.Activate(name); err != nil {
+		// Move the installation back unless the symlink was created.
+		if _, lstatErr := opts.FS.Lstat(layout.Link); errors.Is(lstatErr, os.ErrNotExist) {
+			if restoreErr := fs.MoveDir(swapCtx, opts.FS, layout.Path(name), layout.Link, opts.copyOptions()); restoreErr != nil {
+				return fmt.Errorf("%w (restoring %s also failed: %v)", err, layout.Link, restoreErr)
+			}
+			p.restored = true
+		}
+		return err
+	}
+	fmt.Printf("Adopted existing installation as release %s\n", name)
+
+	return nil
+}
+
+// pruneBackups drops the backup index entries of backups that no longer
+// exist and removes the oldest backups beyond opts.KeepBackups.
+func pruneBackups(opts Options) error {
+	indexPath := backup.IndexPath(opts.DestinationPath)
+	idx, err := backup.Load(opts.FS, indexPath)
+	if err != nil || len(idx.Backups) == 0 {
+		return err
+	}
+	active, err := fs.EvalSymlinks(opts.FS, opts.DestinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve destination: %w", err)
+	}
+
+	before := len(idx.Backups)
+	removed, pruneErr := idx.Prune(opts.FS, absPath(opts.DestinationPath), opts.KeepBackups, absPath(active))
+	for _, entry := range removed {
+		fmt.Printf("Pruned backup %s\n", entry.Path)
+	}
+	if len(idx.Backups) != before {
+		if err := idx.Save(opts.FS, indexPath); err != nil {
+			return err
+		}
+	}
+	return pruneErr
+}
+
+// newBackupPath returns the path of a backup of destinationPath taken at t,
+// made unique when an earlier backup was taken within the same second.
+func newBackupPath(fsys fs.FS, destinationPath string, t time.Time) string {
+	backupPath := fmt.Sprintf("%s_backup_%d", destinationPath, t.Unix())
+	for n := 2; ; n++ {
+		if _, err := fsys.Lstat(backupPath); err != nil {
+			return backupPath
+		}
+		backupPath = fmt.Sprintf("%s_backup_%d_%d", destinationPath, t.Unix(), n)
+	}
+}
+
+// recordBackup adds the freshly taken backup to the backup index of destinationPath.
+//
+// A backup that is already recorded, e.g. by an interrupted run, is left as is.
+func recordBackup(fsys fs.FS, destinationPath, backupPath, installedVersion string, createdAt time.Time) error {
+	absBackupPath, err := filepath.Abs(backupPath)
+	if err != nil {
+		return err
+	}
+	absSourcePath, err := filepath.Abs(destinationPath)
+	if err != nil {
+		return err
+	}
+
+	indexPath := backup.IndexPath(destinationPath)
+	idx, err := backup.Load(fsys, indexPath)
+	if err != nil {
+		return err
+	}
+	if idx.Has(absBackupPath) {
+		return nil
+	}
+
+	size, checksum, err := backup.Describe(fsys, backupPath)
+	if err != nil {
+		return err
+	}
+
+	idx.Add(backup.Entry{
+		Path:        absBackupPath,
+		Version:     installedVersion,
+		CreatedAt:   createdAt.UTC(),
+		SourcePath:  absSourcePath,
+		Size:        size,
+		Checksum:    checksum,
+		ToolVersion: ToolVersion,
+	})
+
+	return idx.Save(fsys, indexPath)
+}
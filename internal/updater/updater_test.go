@@ -2,14 +2,31 @@ package updater
 
 import (
 	"archive/zip"
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/jsas4coding/pma-up/internal/backup"
+	"github.com/jsas4coding/pma-up/internal/fs"
+	"github.com/jsas4coding/pma-up/internal/journal"
+	"github.com/jsas4coding/pma-up/internal/lock"
+	"github.com/jsas4coding/pma-up/internal/manifest"
+	"github.com/jsas4coding/pma-up/internal/php"
+	"github.com/jsas4coding/pma-up/internal/release"
 	"github.com/jsas4coding/pma-up/internal/version"
 )
 
@@ -28,6 +45,11 @@ func TestRunUpdate_Success(t *testing.T) {
 		t.Fatalf("failed to create existing config: %v", err)
 	}
 
+	// Simulated package.json revealing the installed version
+	if err := os.WriteFile(filepath.Join(existingPmaDir, "package.json"), []byte(`{"version":"5.2.1"}`), 0644); err != nil {
+		t.Fatalf("failed to create package.json: %v", err)
+	}
+
 	// Create mock zip archive
 	mockZipPath := filepath.Join(tempDir, "mock_update.zip")
 	files := map[string]string{
@@ -69,6 +91,10 @@ func TestRunUpdate_Success(t *testing.T) {
 	version.VersionURL = versionServer.URL
 	defer func() { version.VersionURL = originalVersionURL }()
 
+	originalArchiveURL := version.ArchiveURLFormat
+	version.ArchiveURLFormat = downloadServer.URL + "/%s.zip"
+	defer func() { version.ArchiveURLFormat = originalArchiveURL }()
+
 	// Execute real update
 	if err := RunUpdate(existingPmaDir, existingConfigPath); err != nil {
 		t.Fatalf("RunUpdate failed: %v", err)
@@ -93,6 +119,23 @@ func TestRunUpdate_Success(t *testing.T) {
 	if string(configData) != "existing config" {
 		t.Errorf("config file not restored correctly: got '%s'", string(configData))
 	}
+
+	// Verify backup recorded in the index
+	idx, err := backup.Load(fs.OS{}, backup.IndexPath(existingPmaDir))
+	if err != nil {
+		t.Fatalf("failed to load backup index: %v", err)
+	}
+	entries := idx.ForSource(existingPmaDir)
+	if len(entries) == 0 {
+		t.Fatalf("expected backup entry in index")
+	}
+	entry := entries[0]
+	if entry.Version != "5.2.1" || entry.ToolVersion != ToolVersion || entry.Checksum == "" {
+		t.Errorf("unexpected backup entry: %+v", entry)
+	}
+	if _, err := os.Stat(filepath.Join(entry.Path, "package.json")); err != nil {
+		t.Errorf("indexed backup path does not hold the old installation: %v", err)
+	}
 }
 
 func TestRunUpdate_FailureScenarios(t *testing.T) {
@@ -289,3 +332,2073 @@ func createTestZip(t *testing.T, zipPath string, files map[string]string) error
 	}
 	return nil
 }
+
+func TestRun_ReleasesLayout(t *testing.T) {
+	tempDir := t.TempDir()
+
+	existingPmaDir := filepath.Join(tempDir, "phpmyadmin")
+	if err := os.MkdirAll(existingPmaDir, os.ModePerm); err != nil {
+		t.Fatalf("failed to create existing phpMyAdmin dir: %v", err)
+	}
+	existingConfigPath := filepath.Join(existingPmaDir, "config.inc.php")
+	if err := os.WriteFile(existingConfigPath, []byte("existing config"), 0644); err != nil {
+		t.Fatalf("failed to create existing config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(existingPmaDir, "package.json"), []byte(`{"version":"5.2.1"}`), 0644); err != nil {
+		t.Fatalf("failed to create package.json: %v", err)
+	}
+
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+
+	opts := Options{
+		DestinationPath: existingPmaDir,
+		ConfigFilePath:  existingConfigPath,
+		Layout:          LayoutReleases,
+		KeepReleases:    2,
+	}
+
+	// The first run adopts the plain directory, the following ones only add releases.
+	for run := 0; run < 3; run++ {
+		if err := Run(context.Background(), opts); err != nil {
+			t.Fatalf("Run %d failed: %v", run, err)
+		}
+		time.Sleep(1100 * time.Millisecond)
+	}
+
+	layout := &release.Layout{FS: fs.OS{}, Root: release.DefaultRoot(existingPmaDir), Link: existingPmaDir}
+	current, err := layout.Current()
+	if err != nil {
+		t.Fatalf("failed to read current release: %v", err)
+	}
+	if !strings.HasSuffix(current, "-5.2.2") {
+		t.Errorf("unexpected current release: %s", current)
+	}
+
+	names, err := layout.Releases()
+	if err != nil {
+		t.Fatalf("failed to list releases: %v", err)
+	}
+	if len(names) != 2 {
+		t.Errorf("expected 2 retained releases, got %v", names)
+	}
+
+	data, err := os.ReadFile(filepath.Join(existingPmaDir, "file.txt"))
+	if err != nil || string(data) != "new version" {
+		t.Errorf("unexpected release content %q (%v)", data, err)
+	}
+	configData, err := os.ReadFile(existingConfigPath)
+	if err != nil || string(configData) != "existing config" {
+		t.Errorf("config not carried into release: %q (%v)", configData, err)
+	}
+
+	// Pruned releases stay in the backup index until the next prune, but
+	// rollback skips them.
+	if err := Rollback(context.Background(), opts); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	previous, err := layout.Current()
+	if err != nil || previous == current || !slices.Contains(names, previous) {
+		t.Fatalf("expected the other retained release %v active, got %q (%v)", names, previous, err)
+	}
+	configData, err = os.ReadFile(filepath.Join(layout.Path(previous), "config.inc.php"))
+	if err != nil || string(configData) != "existing config" {
+		t.Errorf("previous release lost its config: %q (%v)", configData, err)
+	}
+}
+
+func TestRun_InvalidOptions(t *testing.T) {
+	if err := Run(context.Background(), Options{DestinationPath: "/tmp/pma", ConfigFilePath: "/tmp/pma/config.inc.php", Layout: "bogus"}); err == nil {
+		t.Errorf("expected error for unknown layout")
+	}
+	if err := Run(context.Background(), Options{ConfigFilePath: "/tmp/pma/config.inc.php"}); err == nil {
+		t.Errorf("expected error for empty destination")
+	}
+}
+
+// setupMockRelease serves a zip built from files as the latest phpMyAdmin release
+// and points version.VersionURL at it for the duration of the test.
+func setupMockRelease(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	mockZipPath := filepath.Join(t.TempDir(), "mock_release.zip")
+	if err := createTestZip(t, mockZipPath, files); err != nil {
+		t.Fatalf("failed to create test zip: %v", err)
+	}
+
+	downloadServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, mockZipPath)
+	}))
+	t.Cleanup(downloadServer.Close)
+
+	versionTxt := fmt.Sprintf("5.2.2\n2025-01-21\n%s/phpMyAdmin-5.2.2-all-languages.zip\n", downloadServer.URL)
+	versionServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if _, writeErr := fmt.Fprint(w, versionTxt); writeErr != nil {
+			t.Errorf("failed to write versionTxt: %v", writeErr)
+		}
+	}))
+	t.Cleanup(versionServer.Close)
+
+	originalVersionURL := version.VersionURL
+	version.VersionURL = versionServer.URL
+	t.Cleanup(func() { version.VersionURL = originalVersionURL })
+
+	// Every archive served is the mock release, including the pristine
+	// archive of the installed version.
+	originalArchiveURL := version.ArchiveURLFormat
+	version.ArchiveURLFormat = downloadServer.URL + "/%s.zip"
+	t.Cleanup(func() { version.ArchiveURLFormat = originalArchiveURL })
+}
+
+func TestRun_SymlinkDestination(t *testing.T) {
+	tempDir := t.TempDir()
+
+	optDir := filepath.Join(tempDir, "opt")
+	currentTarget := filepath.Join(optDir, "phpmyadmin-5.2.1")
+	if err := os.MkdirAll(currentTarget, os.ModePerm); err != nil {
+		t.Fatalf("failed to create symlink target: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(currentTarget, "config.inc.php"), []byte("existing config"), 0644); err != nil {
+		t.Fatalf("failed to create existing config: %v", err)
+	}
+
+	link := filepath.Join(tempDir, "www", "phpmyadmin")
+	if err := os.MkdirAll(filepath.Dir(link), os.ModePerm); err != nil {
+		t.Fatalf("failed to create web root: %v", err)
+	}
+	if err := os.Symlink(currentTarget, link); err != nil {
+		t.Fatalf("failed to create destination symlink: %v", err)
+	}
+
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+
+	if err := RunUpdate(link, filepath.Join(link, "config.inc.php")); err != nil {
+		t.Fatalf("RunUpdate failed: %v", err)
+	}
+
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("destination is no longer a symlink: %v", err)
+	}
+	if target != filepath.Join(optDir, "phpmyadmin-5.2.2") {
+		t.Errorf("unexpected symlink target: %s", target)
+	}
+
+	configData, err := os.ReadFile(filepath.Join(link, "config.inc.php"))
+	if err != nil || string(configData) != "existing config" {
+		t.Errorf("config not restored into new target: %q (%v)", configData, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(currentTarget, "config.inc.php")); err != nil {
+		t.Errorf("expected previous target to be kept: %v", err)
+	}
+
+	idx, err := backup.Load(fs.OS{}, backup.IndexPath(link))
+	if err != nil {
+		t.Fatalf("failed to load backup index: %v", err)
+	}
+	if entries := idx.ForSource(link); len(entries) == 0 || entries[0].Path != currentTarget {
+		t.Errorf("expected previous target recorded as backup, got %+v", entries)
+	}
+}
+
+func TestRun_MountPointDestination(t *testing.T) {
+	mem := memInstallation(t, "/var/www/phpmyadmin")
+	if err := fs.WriteFile(mem, "/var/www/phpmyadmin/stale.php", []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to create stale file: %v", err)
+	}
+	if err := mem.Mount("/var/www/phpmyadmin"); err != nil {
+		t.Fatalf("failed to mount destination: %v", err)
+	}
+
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+
+	opts := Options{DestinationPath: "/var/www/phpmyadmin", ConfigFilePath: "/var/www/phpmyadmin/config.inc.php", FS: mem}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if mounted, err := mem.IsMountPoint("/var/www/phpmyadmin"); err != nil || !mounted {
+		t.Errorf("expected mounted directory to be updated in place, got %v (%v)", mounted, err)
+	}
+	if _, err := mem.Stat("/var/www/phpmyadmin/stale.php"); !os.IsNotExist(err) {
+		t.Errorf("expected stale file to be removed, got %v", err)
+	}
+	data, err := fs.ReadFile(mem, "/var/www/phpmyadmin/file.txt")
+	if err != nil || string(data) != "new version" {
+		t.Errorf("unexpected release content %q (%v)", data, err)
+	}
+	configData, err := fs.ReadFile(mem, "/var/www/phpmyadmin/config.inc.php")
+	if err != nil || string(configData) != "existing config" {
+		t.Errorf("config not preserved: %q (%v)", configData, err)
+	}
+
+	entries, err := mem.ReadDir("/var/www")
+	if err != nil {
+		t.Fatalf("failed to read web root: %v", err)
+	}
+	backups := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "phpmyadmin_backup_") {
+			backups = append(backups, "/var/www/"+entry.Name())
+		}
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup copy, got %v", backups)
+	}
+	if _, err := mem.Stat(backups[0] + "/stale.php"); err != nil {
+		t.Errorf("expected backup to hold the previous tree: %v", err)
+	}
+}
+
+func TestRun_RestoresBackupWhenSwapFails(t *testing.T) {
+	tempDir := t.TempDir()
+
+	existingPmaDir := filepath.Join(tempDir, "phpmyadmin")
+	if err := os.MkdirAll(existingPmaDir, os.ModePerm); err != nil {
+		t.Fatalf("failed to create existing phpMyAdmin dir: %v", err)
+	}
+	configPath := filepath.Join(existingPmaDir, "config.inc.php")
+	if err := os.WriteFile(configPath, []byte("existing config"), 0644); err != nil {
+		t.Fatalf("failed to create existing config: %v", err)
+	}
+
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+
+	fsys := &fs.FaultFS{FS: fs.OS{}, Fail: func(op string, paths ...string) error {
+		if op == "Rename" && paths[1] == existingPmaDir && !strings.HasPrefix(filepath.Base(paths[0]), "phpmyadmin_backup_") {
+			return fmt.Errorf("simulated copy failure")
+		}
+		return nil
+	}}
+
+	err := Run(context.Background(), Options{DestinationPath: existingPmaDir, ConfigFilePath: configPath, FS: fsys})
+	if err == nil || !strings.Contains(err.Error(), "previous installation restored") {
+		t.Fatalf("expected restored installation error, got %v", err)
+	}
+
+	configData, err := os.ReadFile(configPath)
+	if err != nil || string(configData) != "existing config" {
+		t.Errorf("expected previous installation back in place, got %q (%v)", configData, err)
+	}
+	backups, _ := filepath.Glob(existingPmaDir + "_backup_*")
+	if len(backups) != 0 {
+		t.Errorf("expected no leftover backups, found %v", backups)
+	}
+	if _, err := os.Stat(backup.IndexPath(existingPmaDir)); !os.IsNotExist(err) {
+		t.Errorf("expected no backup recorded for a failed update, got %v", err)
+	}
+}
+
+func TestRun_StagingDirectory(t *testing.T) {
+	tempDir := t.TempDir()
+
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+
+	customStaging := filepath.Join(tempDir, "staging")
+	cases := map[string]struct {
+		webRoot        string
+		stagingDir     string
+		expectedParent string
+	}{
+		"default sibling": {filepath.Join(tempDir, "www1"), "", filepath.Join(tempDir, "www1")},
+		"configured":      {filepath.Join(tempDir, "www2"), customStaging, customStaging},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			existingPmaDir := filepath.Join(tc.webRoot, "phpmyadmin")
+			if err := os.MkdirAll(existingPmaDir, os.ModePerm); err != nil {
+				t.Fatalf("failed to create existing phpMyAdmin dir: %v", err)
+			}
+			configPath := filepath.Join(existingPmaDir, "config.inc.php")
+			if err := os.WriteFile(configPath, []byte("existing config"), 0644); err != nil {
+				t.Fatalf("failed to create existing config: %v", err)
+			}
+
+			var releaseSource string
+			fsys := &fs.FaultFS{FS: fs.OS{}, Fail: func(op string, paths ...string) error {
+				if op == "Rename" && paths[1] == existingPmaDir {
+					releaseSource = paths[0]
+				}
+				return nil
+			}}
+
+			opts := Options{DestinationPath: existingPmaDir, ConfigFilePath: configPath, StagingDir: tc.stagingDir, FS: fsys}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			stagingDir := filepath.Dir(filepath.Dir(releaseSource))
+			if filepath.Dir(stagingDir) != tc.expectedParent {
+				t.Errorf("expected release extracted below %s, got %s", tc.expectedParent, releaseSource)
+			}
+			if !strings.HasPrefix(filepath.Base(stagingDir), ".phpmyadmin.pma-up-staging-") {
+				t.Errorf("unexpected staging directory name: %s", stagingDir)
+			}
+			if _, err := os.Stat(stagingDir); !os.IsNotExist(err) {
+				t.Errorf("expected staging directory to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+// memInstallation returns an in-memory filesystem holding a phpMyAdmin 5.2.1
+// installation with its configuration file at dest.
+func memInstallation(t *testing.T, dest string) *fs.Mem {
+	t.Helper()
+
+	mem := fs.NewMem()
+	if err := mem.MkdirAll(dest, 0755); err != nil {
+		t.Fatalf("failed to create installation: %v", err)
+	}
+	files := map[string]string{
+		"config.inc.php": "existing config",
+		"package.json":   `{"version":"5.2.1"}`,
+		"old.php":        "old version",
+	}
+	for name, content := range files {
+		if err := fs.WriteFile(mem, filepath.Join(dest, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	return mem
+}
+
+// installedRelease reports which release dest serves on fsys: "5.2.1", "5.2.2",
+// or a description of the inconsistency found.
+func installedRelease(fsys fs.FS, dest string) string {
+	config, err := fs.ReadFile(fsys, filepath.Join(dest, "config.inc.php"))
+	if err != nil || string(config) != "existing config" {
+		return fmt.Sprintf("config not in place: %q (%v)", config, err)
+	}
+
+	_, oldErr := fsys.Stat(filepath.Join(dest, "old.php"))
+	_, newErr := fsys.Stat(filepath.Join(dest, "file.txt"))
+	switch {
+	case oldErr == nil && newErr != nil:
+		return "5.2.1"
+	case oldErr != nil && newErr == nil:
+		return "5.2.2"
+	}
+	return fmt.Sprintf("mixed or missing tree (old: %v, new: %v)", oldErr, newErr)
+}
+
+func TestRun_InMemoryFailureAtEveryStep(t *testing.T) {
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":       "new version",
+		"phpMyAdmin-5.2.2-all-languages/config.inc.php": "should be replaced",
+	})
+	const dest = "/var/www/phpmyadmin"
+
+	for _, layout := range []Layout{LayoutInPlace, LayoutReleases} {
+		t.Run(string(layout), func(t *testing.T) {
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", Layout: layout}
+
+			// A clean run tells how many filesystem operations an update takes.
+			steps := 0
+			opts.FS = &fs.FaultFS{FS: memInstallation(t, dest), Fail: func(string, ...string) error {
+				steps++
+				return nil
+			}}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := installedRelease(opts.FS, dest); got != "5.2.2" {
+				t.Fatalf("expected new release after a clean run, got %s", got)
+			}
+
+			for step := 1; step <= steps; step++ {
+				mem := memInstallation(t, dest)
+				calls := 0
+				var injected string
+				opts.FS = &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+					if calls++; calls == step {
+						injected = fmt.Sprintf("%s %v", op, paths)
+						return fmt.Errorf("injected failure")
+					}
+					return nil
+				}}
+
+				err := Run(context.Background(), opts)
+				got := installedRelease(mem, dest)
+				if err == nil && got != "5.2.2" {
+					t.Errorf("step %d (%s): update reported success but serves %s", step, injected, got)
+				}
+				if got != "5.2.1" && got != "5.2.2" {
+					t.Errorf("step %d (%s): inconsistent installation after %v: %s", step, injected, err, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_NotEnoughDiskSpace(t *testing.T) {
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":       "new version",
+		"phpMyAdmin-5.2.2-all-languages/config.inc.php": "should be replaced",
+	})
+	const dest = "/var/www/phpmyadmin"
+	const installedSize, releaseSize = 45, 29
+
+	tests := []struct {
+		name     string
+		mount    bool
+		capacity uint64 // Capacity of the root filesystem
+		fits     bool
+	}{
+		{"release does not fit", false, installedSize + releaseSize + journalReserve - 1, false},
+		{"release fits", false, installedSize + releaseSize + journalReserve, true},
+		{"backup copy does not fit", true, releaseSize + installedSize + journalReserve - 1, false},
+		{"backup copy fits", true, releaseSize + installedSize + journalReserve, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			// The archive is downloaded to a separate filesystem.
+			if err := mem.MkdirAll("/tmp", 0755); err != nil {
+				t.Fatalf("failed to create temp dir: %v", err)
+			}
+			for _, mount := range []string{"/tmp", dest} {
+				if mount == dest && !tt.mount {
+					continue
+				}
+				if err := mem.Mount(mount); err != nil {
+					t.Fatalf("failed to mount %s: %v", mount, err)
+				}
+			}
+			if err := mem.SetCapacity("/", tt.capacity); err != nil {
+				t.Fatalf("failed to set capacity: %v", err)
+			}
+
+			// Lock holder records are optional; keep them out of the capacity.
+			fsys := &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+				if op == "Write" && strings.HasSuffix(paths[0], ".lock") {
+					return errors.New("no space left")
+				}
+				return nil
+			}}
+
+			err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: fsys})
+			if tt.fits {
+				if err != nil {
+					t.Fatalf("Run failed: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, fs.ErrNoSpace) {
+				t.Fatalf("expected not enough space error, got %v", err)
+			}
+			if got := installedRelease(mem, dest); got != "5.2.1" {
+				t.Errorf("expected installation untouched, got %s", got)
+			}
+			entries, _ := mem.ReadDir("/var/www")
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), "staging") || strings.Contains(entry.Name(), "_backup_") {
+					t.Errorf("unexpected leftover %s", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestRun_DestinationLocked(t *testing.T) {
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+	const dest = "/var/www/phpmyadmin"
+	mem := memInstallation(t, dest)
+
+	held, err := lock.Acquire(context.Background(), mem, lock.Path(dest), 0)
+	if err != nil {
+		t.Fatalf("failed to take lock: %v", err)
+	}
+
+	opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: mem}
+	var heldErr *lock.HeldError
+	if err := Run(context.Background(), opts); !errors.As(err, &heldErr) || heldErr.Holder.PID != os.Getpid() {
+		t.Fatalf("expected run to be refused naming the lock holder, got %v", err)
+	}
+	if got := installedRelease(mem, dest); got != "5.2.1" {
+		t.Errorf("expected installation untouched, got %s", got)
+	}
+
+	if err := held.Release(); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed after the lock was released: %v", err)
+	}
+}
+
+func TestRecoverInterrupted_CrashAtEveryStep(t *testing.T) {
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":       "new version",
+		"phpMyAdmin-5.2.2-all-languages/config.inc.php": "should be replaced",
+	})
+	const dest = "/var/www/phpmyadmin"
+
+	tests := []struct {
+		name   string
+		layout Layout
+		setup  func(t *testing.T) *fs.Mem
+		exdev  bool // Moving the installation to its backup crosses filesystems
+	}{
+		{"in-place", LayoutInPlace, func(t *testing.T) *fs.Mem { return memInstallation(t, dest) }, false},
+		{"in-place across filesystems", LayoutInPlace, func(t *testing.T) *fs.Mem { return memInstallation(t, dest) }, true},
+		{"symlink", LayoutInPlace, func(t *testing.T) *fs.Mem {
+			mem := memInstallation(t, dest+"-5.2.1")
+			if err := mem.Symlink(dest+"-5.2.1", dest); err != nil {
+				t.Fatalf("failed to create symlink: %v", err)
+			}
+			return mem
+		}, false},
+		{"mount", LayoutInPlace, func(t *testing.T) *fs.Mem {
+			mem := memInstallation(t, dest)
+			if err := mem.Mount(dest); err != nil {
+				t.Fatalf("failed to mount destination: %v", err)
+			}
+			return mem
+		}, false},
+		{"releases", LayoutReleases, func(t *testing.T) *fs.Mem { return memInstallation(t, dest) }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", Layout: tt.layout}
+			crossDevice := func(op string, paths ...string) error {
+				if tt.exdev && op == "Rename" && paths[0] == dest && strings.HasPrefix(paths[1], dest+"_backup_") {
+					return &os.LinkError{Op: "rename", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+				}
+				return nil
+			}
+
+			// Trees are copied concurrently, so the operations are counted under a lock.
+			var mu sync.Mutex
+			steps := 0
+			opts.FS = &fs.FaultFS{FS: tt.setup(t), Fail: func(op string, paths ...string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				steps++
+				return crossDevice(op, paths...)
+			}}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			for step := 1; step <= steps; step++ {
+				mem := tt.setup(t)
+				// The process dies at step: no filesystem operation succeeds after it.
+				calls := 0
+				var crashed string
+				opts.FS = &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+					mu.Lock()
+					defer mu.Unlock()
+					if calls++; calls >= step {
+						if crashed == "" {
+							crashed = fmt.Sprintf("%s %v", op, paths)
+						}
+						return fmt.Errorf("crashed")
+					}
+					return crossDevice(op, paths...)
+				}}
+				_ = Run(context.Background(), opts)
+				j, err := journal.Load(mem, journal.Path(dest))
+				if err != nil {
+					t.Fatalf("step %d (%s): failed to load journal: %v", step, crashed, err)
+				}
+				journaled := j != nil
+				// Once the installation was touched, a release journaled complete with its
+				// configuration is put in place rather than thrown away.
+				want := "5.2.1 or 5.2.2"
+				if journaled && j.Reached(journal.PhaseConfigRestored) && installedRelease(mem, dest) != "5.2.1" {
+					want = "5.2.2"
+				}
+
+				recoverOpts, err := Options{DestinationPath: dest, ConfigFilePath: opts.ConfigFilePath, Layout: tt.layout, FS: mem}.withDefaults()
+				if err != nil {
+					t.Fatalf("invalid options: %v", err)
+				}
+				if err := recoverInterrupted(context.Background(), recoverOpts, journal.Path(dest)); err != nil {
+					t.Errorf("step %d (%s): recovery failed: %v", step, crashed, err)
+					continue
+				}
+				if got := installedRelease(mem, dest); !strings.Contains(want, got) {
+					t.Errorf("step %d (%s): expected %s after recovery, got %s", step, crashed, want, got)
+				}
+				if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("step %d (%s): expected journal removed, got %v", step, crashed, err)
+				}
+				if journaled && j.Strategy == journal.StrategyInPlace && installedRelease(mem, dest) == "5.2.1" {
+					// A rolled back update leaves no backup behind, even a complete copy.
+					if _, err := mem.Lstat(j.Backup); !errors.Is(err, os.ErrNotExist) {
+						t.Errorf("step %d (%s): expected backup %s removed, got %v", step, crashed, j.Backup, err)
+					}
+					if idx, _ := backup.Load(mem, backup.IndexPath(dest)); idx != nil && idx.Has(j.Backup) {
+						t.Errorf("step %d (%s): expected backup %s not recorded", step, crashed, j.Backup)
+					}
+				}
+
+				// Whatever recovery did not remove is left to the orphan cleanup.
+				recoverOpts.OrphanAge = time.Nanosecond
+				removeOrphans(context.Background(), recoverOpts)
+				for _, dir := range []string{"/var/www", recoverOpts.ReleasesDir} {
+					entries, _ := mem.ReadDir(dir)
+					for _, entry := range entries {
+						if strings.Contains(entry.Name(), "staging") || strings.Contains(entry.Name(), "trash") {
+							t.Errorf("step %d (%s): unexpected leftover %s", step, crashed, entry.Name())
+						}
+					}
+				}
+				if entries, _ := mem.ReadDir(mem.TempDir()); len(entries) != 0 {
+					t.Errorf("step %d (%s): unexpected leftovers in temporary directory: %v", step, crashed, entries)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_Cancellation(t *testing.T) {
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":       "new version",
+		"phpMyAdmin-5.2.2-all-languages/config.inc.php": "should be replaced",
+	})
+	const dest = "/var/www/phpmyadmin"
+
+	tests := []struct {
+		name     string
+		mount    bool
+		cancelAt func(op string, paths ...string) bool
+		want     string
+	}{
+		{"during extraction", false, func(op string, paths ...string) bool {
+			return op == "Write" && strings.Contains(paths[0], "staging")
+		}, "5.2.1"},
+		{"during the swap", false, func(op string, paths ...string) bool {
+			return op == "Rename" && paths[0] == dest
+		}, "5.2.2"},
+		{"during the mount point synchronization", true, func(op string, paths ...string) bool {
+			return op == "Rename" && strings.HasPrefix(paths[1], dest+"/")
+		}, "5.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			if tt.mount {
+				if err := mem.Mount(dest); err != nil {
+					t.Fatalf("failed to mount destination: %v", err)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			fsys := &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+				if tt.cancelAt(op, paths...) {
+					cancel()
+				}
+				return nil
+			}}
+
+			err := Run(ctx, Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: fsys})
+			if tt.want == "5.2.2" && err != nil {
+				t.Fatalf("expected a started swap to complete, got %v", err)
+			}
+			if tt.want == "5.2.1" && !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected cancellation, got %v", err)
+			}
+			if got := installedRelease(mem, dest); got != tt.want {
+				t.Errorf("expected %s after cancellation, got %s", tt.want, got)
+			}
+			if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected no journal left, got %v", err)
+			}
+			entries, _ := mem.ReadDir("/var/www")
+			for _, entry := range entries {
+				if strings.Contains(entry.Name(), "staging") {
+					t.Errorf("unexpected leftover %s", entry.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	orphans := []string{
+		"/tmp/pma-up-77",
+		"/var/www/.phpmyadmin.pma-up-staging-5",
+		"/var/www/.phpmyadmin_backup_1.pma-up-trash-9",
+		"/var/www/phpmyadmin-releases/.5.2.2-20250601T030000Z.pma-up-staging-3",
+	}
+	inUse := "/var/www/.other.pma-up-staging-6"
+	unrelated := []string{"/tmp/other", "/tmp/pma-up-cache", "/var/www/.phpmyadmin.pma-up-staging-notes"}
+
+	setup := func(t *testing.T) (*fs.Mem, *lock.Lock) {
+		mem := memInstallation(t, dest)
+		for _, dir := range slices.Concat(orphans, []string{inUse}, unrelated) {
+			if err := mem.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("failed to create %s: %v", dir, err)
+			}
+			if err := fs.WriteFile(mem, filepath.Join(dir, "file.txt"), []byte("leftover"), 0644); err != nil {
+				t.Fatalf("failed to populate %s: %v", dir, err)
+			}
+		}
+		held, err := lockWorkDir(context.Background(), mem, inUse)
+		if err != nil {
+			t.Fatalf("failed to lock %s: %v", inUse, err)
+		}
+		return mem, held
+	}
+	exists := func(mem *fs.Mem, path string) bool {
+		_, err := mem.Lstat(path)
+		return err == nil
+	}
+
+	t.Run("removes old leftovers not in use", func(t *testing.T) {
+		mem, held := setup(t)
+		defer releaseWorkDir(held)
+
+		opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", OrphanAge: time.Nanosecond, FS: mem}
+		if err := Cleanup(context.Background(), opts); err != nil {
+			t.Fatalf("Cleanup failed: %v", err)
+		}
+		for _, dir := range orphans {
+			if exists(mem, dir) {
+				t.Errorf("expected %s removed", dir)
+			}
+		}
+		for _, dir := range append([]string{inUse}, unrelated...) {
+			if !exists(mem, dir) {
+				t.Errorf("expected %s kept", dir)
+			}
+		}
+		if got := installedRelease(mem, dest); got != "5.2.1" {
+			t.Errorf("expected installation untouched, got %s", got)
+		}
+	})
+
+	t.Run("keeps recent leftovers", func(t *testing.T) {
+		mem, held := setup(t)
+		defer releaseWorkDir(held)
+
+		opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: mem}
+		if err := Cleanup(context.Background(), opts); err != nil {
+			t.Fatalf("Cleanup failed: %v", err)
+		}
+		for _, dir := range orphans {
+			if !exists(mem, dir) {
+				t.Errorf("expected recent %s kept", dir)
+			}
+		}
+	})
+}
+
+func TestCleanup_MoveInProgress(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+	mem := memInstallation(t, dest)
+	// Releases extracted on another filesystem are copied into place.
+	if err := mem.MkdirAll("/srv/staging", 0755); err != nil {
+		t.Fatalf("failed to create staging directory: %v", err)
+	}
+	if err := mem.Mount("/srv/staging"); err != nil {
+		t.Fatalf("failed to mount staging directory: %v", err)
+	}
+
+	// A cleanup of another installation in the same directory runs while
+	// the release is copied.
+	other := Options{DestinationPath: "/var/www/other", ReleasesDir: "/var/www/other-releases", OrphanAge: time.Nanosecond, FS: mem}
+	var once sync.Once
+	var moveDir string
+	fsys := &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+		if op == "OpenFile" && strings.HasPrefix(paths[0], "/var/www/.phpmyadmin.pma-up-staging-") && filepath.Base(paths[0]) != workDirLockName {
+			once.Do(func() {
+				moveDir, _, _ = strings.Cut(strings.TrimPrefix(paths[0], "/var/www/"), "/")
+				removeOrphans(context.Background(), other)
+			})
+		}
+		return nil
+	}}
+
+	opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", StagingDir: "/srv/staging", FS: fsys}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if moveDir == "" {
+		t.Fatal("expected the release to be copied through a staging directory")
+	}
+	if got := installedRelease(mem, dest); got != "5.2.2" {
+		t.Errorf("expected release 5.2.2 installed, got %s", got)
+	}
+	if _, err := mem.Lstat(filepath.Join("/var/www", moveDir)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %s removed after the move, got %v", moveDir, err)
+	}
+}
+func TestRun_ExternalConfig(t *testing.T) {
+	const (
+		dest       = "/var/www/phpmyadmin"
+		configPath = "/etc/phpmyadmin/config.inc.php"
+	)
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":       "new version",
+		"phpMyAdmin-5.2.2-all-languages/config.inc.php": "should be replaced",
+	})
+
+	tests := []struct {
+		name   string
+		mode   ConfigMode
+		layout Layout
+		check  func(t *testing.T, mem *fs.Mem, config string)
+	}{
+		{"copy", "", LayoutInPlace, func(t *testing.T, mem *fs.Mem, config string) {
+			if config != "external config" {
+				t.Errorf("expected external config copied, got %q", config)
+			}
+		}},
+		{"symlink", ConfigSymlink, LayoutInPlace, func(t *testing.T, mem *fs.Mem, config string) {
+			if target, err := mem.Readlink(dest + "/config.inc.php"); err != nil || target != configPath {
+				t.Errorf("expected config symlinked to %s, got %q (%v)", configPath, target, err)
+			}
+		}},
+		{"include", ConfigInclude, LayoutInPlace, func(t *testing.T, mem *fs.Mem, config string) {
+			if !strings.HasPrefix(config, "<?php\n") || !strings.Contains(config, "require '"+configPath+"';") {
+				t.Errorf("expected include stub, got %q", config)
+			}
+		}},
+		{"symlink with releases layout", ConfigSymlink, LayoutReleases, func(t *testing.T, mem *fs.Mem, config string) {
+			if target, err := mem.Readlink(dest + "/config.inc.php"); err != nil || target != configPath {
+				t.Errorf("expected config symlinked to %s, got %q (%v)", configPath, target, err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			if err := mem.RemoveAll(dest + "/config.inc.php"); err != nil {
+				t.Fatalf("failed to remove config: %v", err)
+			}
+			if err := mem.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+				t.Fatalf("failed to create config directory: %v", err)
+			}
+			if err := fs.WriteFile(mem, configPath, []byte("external config"), 0640); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+
+			opts := Options{DestinationPath: dest, ConfigFilePath: configPath, ConfigMode: tt.mode, Layout: tt.layout, FS: mem}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			data, err := fs.ReadFile(mem, dest+"/file.txt")
+			if err != nil || string(data) != "new version" {
+				t.Errorf("unexpected release content %q (%v)", data, err)
+			}
+			config, err := fs.ReadFile(mem, dest+"/config.inc.php")
+			if err != nil {
+				t.Fatalf("config not in place: %v", err)
+			}
+			tt.check(t, mem, string(config))
+		})
+	}
+}
+
+func TestRun_MissingConfig(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	mem := memInstallation(t, dest)
+
+	for _, configPath := range []string{"/etc/phpmyadmin/config.inc.php", "/etc"} {
+		err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: configPath, FS: mem})
+		if err == nil {
+			t.Errorf("expected error for config %s", configPath)
+		}
+		if got := installedRelease(mem, dest); got != "5.2.1" {
+			t.Errorf("expected installation untouched, got %s", got)
+		}
+	}
+
+	if err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", ConfigMode: "hardlink", FS: mem}); err == nil {
+		t.Error("expected error for unknown config mode")
+	}
+}
+
+func TestPreserveFiles(t *testing.T) {
+	mem := fs.NewMem()
+	files := map[string]string{
+		"/old/config.inc.php":               "existing config",
+		"/old/config.user.inc.php":          "user config",
+		"/old/favicon.ico":                  "custom icon",
+		"/old/robots.txt":                   "User-agent: *",
+		"/old/themes/custom/theme.json":     "custom theme",
+		"/old/themes/pmahomme/theme.json":   "edited shipped theme",
+		"/old/themes/legacy":                "theme file",
+		"/old/upload/dump.sql":              "dump",
+		"/old/libraries/classes/Config.php": "old code",
+		"/new/favicon.ico":                  "shipped icon",
+		"/new/robots.txt":                   "User-agent: *",
+		"/new/themes/pmahomme/theme.json":   "shipped theme",
+		"/new/themes/legacy/theme.json":     "shipped legacy theme",
+		"/new/libraries/classes/Config.php": "new code",
+	}
+	for name, content := range files {
+		if err := mem.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := fs.WriteFile(mem, name, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	opts, err := Options{DestinationPath: "/old", ConfigFilePath: "/old/config.inc.php", FS: mem}.withDefaults()
+	if err != nil {
+		t.Fatalf("invalid options: %v", err)
+	}
+	replaced, skipped, err := preserveFiles(context.Background(), opts, "/old", "/new")
+	if err != nil {
+		t.Fatalf("preserveFiles failed: %v", err)
+	}
+	if !slices.Equal(replaced, []string{"favicon.ico"}) {
+		t.Errorf("unexpected replaced files: %v", replaced)
+	}
+	if !slices.Equal(skipped, []string{"themes/legacy"}) {
+		t.Errorf("unexpected skipped files: %v", skipped)
+	}
+
+	// Preserved files win over those the release ships, but a shipped
+	// directory is never replaced.
+	want := map[string]string{
+		"/new/config.user.inc.php":          "user config",
+		"/new/favicon.ico":                  "custom icon",
+		"/new/robots.txt":                   "User-agent: *",
+		"/new/themes/custom/theme.json":     "custom theme",
+		"/new/themes/pmahomme/theme.json":   "shipped theme",
+		"/new/themes/legacy/theme.json":     "shipped legacy theme",
+		"/new/upload/dump.sql":              "dump",
+		"/new/libraries/classes/Config.php": "new code",
+	}
+	for name, content := range want {
+		if data, err := fs.ReadFile(mem, name); err != nil || string(data) != content {
+			t.Errorf("unexpected %s: %q (%v)", name, data, err)
+		}
+	}
+	if _, err := mem.Lstat("/new/config.inc.php"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected config left to restoreConfig, got %v", err)
+	}
+
+	size, err := preservedSize(opts, "/old")
+	if err != nil || size != uint64(len("user config")+len("custom icon")+len("User-agent: *")+
+		len("custom theme")+len("edited shipped theme")+len("theme file")+len("dump")) {
+		t.Errorf("unexpected preserved size %d (%v)", size, err)
+	}
+}
+
+func TestRun_PreservesUserFiles(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":    "new version",
+		"phpMyAdmin-5.2.2-all-languages/favicon.ico": "shipped icon",
+	})
+
+	for _, layout := range []Layout{LayoutInPlace, LayoutReleases} {
+		t.Run(string(layout), func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			for name, content := range map[string]string{"favicon.ico": "custom icon", "custom/notes.txt": "notes"} {
+				if err := mem.MkdirAll(filepath.Dir(filepath.Join(dest, name)), 0755); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+				if err := fs.WriteFile(mem, filepath.Join(dest, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", Layout: layout,
+				Preserve: []string{"favicon.ico", "custom"}, FS: mem}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			if got := installedRelease(mem, dest); got != "5.2.2" {
+				t.Errorf("expected 5.2.2 installed, got %s", got)
+			}
+			if data, err := fs.ReadFile(mem, dest+"/custom/notes.txt"); err != nil || string(data) != "notes" {
+				t.Errorf("expected custom directory preserved, got %q (%v)", data, err)
+			}
+			if data, err := fs.ReadFile(mem, dest+"/favicon.ico"); err != nil || string(data) != "custom icon" {
+				t.Errorf("expected custom favicon kept over the shipped one, got %q (%v)", data, err)
+			}
+		})
+	}
+
+	if err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php",
+		Preserve: []string{"../etc/*"}}); err == nil {
+		t.Error("expected error for preserve pattern outside the installation")
+	}
+}
+
+func TestRun_PreservedFilesNotHardlinked(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+	mem := memInstallation(t, dest)
+	if err := mem.MkdirAll(dest+"/upload", 0755); err != nil {
+		t.Fatalf("failed to create upload directory: %v", err)
+	}
+	if err := fs.WriteFile(mem, dest+"/upload/dump.sql", []byte("original"), 0644); err != nil {
+		t.Fatalf("failed to write upload: %v", err)
+	}
+
+	opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", Hardlinks: true, FS: mem}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// Preserved files are modified in place, unlike release files.
+	if err := fs.WriteFile(mem, dest+"/upload/dump.sql", []byte("modified"), 0644); err != nil {
+		t.Fatalf("failed to modify upload: %v", err)
+	}
+	idx, err := backup.Load(mem, backup.IndexPath(dest))
+	if err != nil || len(idx.Backups) != 1 {
+		t.Fatalf("expected one backup recorded, got %+v (%v)", idx, err)
+	}
+	data, err := fs.ReadFile(mem, filepath.Join(idx.Backups[0].Path, "upload/dump.sql"))
+	if err != nil || string(data) != "original" {
+		t.Errorf("expected backup unchanged, got %q (%v)", data, err)
+	}
+}
+
+func TestRun_HardlinksOnlyShippedFiles(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+
+	tests := []struct {
+		name  string
+		mount bool
+		want  []string // Files linked into the installation
+	}{
+		// Moving the release in across filesystems copies it, and the
+		// configuration and user files restored into it are not shipped.
+		{name: "release copied into place", want: []string{"config.sample.inc.php", "file.txt", "package.json"}},
+		// The backup of a mounted installation is a copy of the live tree.
+		{name: "backup of a mounted installation", mount: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			if err := mem.MkdirAll(dest+"/upload", 0755); err != nil {
+				t.Fatalf("failed to create upload directory: %v", err)
+			}
+			if err := fs.WriteFile(mem, dest+"/upload/dump.sql", []byte("dump"), 0644); err != nil {
+				t.Fatalf("failed to write upload: %v", err)
+			}
+			if tt.mount {
+				if err := mem.Mount(dest); err != nil {
+					t.Fatalf("failed to mount destination: %v", err)
+				}
+			}
+
+			var mu sync.Mutex
+			var linked []string
+			fsys := &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+				switch {
+				case op == "Rename" && paths[1] == dest && strings.Contains(paths[0], "/extracted/"):
+					return &os.LinkError{Op: "rename", Old: paths[0], New: paths[1], Err: syscall.EXDEV}
+				case op == "Link":
+					mu.Lock()
+					defer mu.Unlock()
+					linked = append(linked, paths[1])
+				}
+				return nil
+			}}
+
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", Hardlinks: true, FS: fsys}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := installedRelease(mem, dest); got != "5.2.2" {
+				t.Errorf("expected 5.2.2 installed, got %s", got)
+			}
+
+			var got []string
+			for _, path := range linked {
+				got = append(got, filepath.Base(path))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expected %v hard linked, got %v", tt.want, linked)
+			}
+		})
+	}
+}
+
+func TestRun_ModifiedCoreFiles(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	release := map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":        "new version",
+		"phpMyAdmin-5.2.2-all-languages/unchanged.php":   "pristine",
+		"phpMyAdmin-5.2.2-all-languages/changed.php":     "upstream 5.2.2",
+		"phpMyAdmin-5.2.2-all-languages/setup/index.php": "setup",
+	}
+	pristine := map[string]string{
+		"unchanged.php":   "pristine",
+		"changed.php":     "upstream 5.2.1",
+		"setup/index.php": "setup",
+	}
+
+	// The pristine archive of the installed version, for installations
+	// without a manifest.
+	pristineZip := filepath.Join(t.TempDir(), "pristine.zip")
+	pristineFiles := map[string]string{}
+	for name, content := range pristine {
+		pristineFiles["phpMyAdmin-5.2.1-all-languages/"+name] = content
+	}
+	if err := createTestZip(t, pristineZip, pristineFiles); err != nil {
+		t.Fatalf("failed to create pristine zip: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		policy      ModifiedPolicy
+		noManifest  bool
+		wantErr     bool
+		wantVersion string
+		want        map[string]string // Expected content, "" for removed
+	}{
+		{"warn", "", false, false, "5.2.2", map[string]string{"unchanged.php": "pristine", "changed.php": "upstream 5.2.2", "setup/index.php": "setup"}},
+		{"ignore", ModifiedIgnore, false, false, "5.2.2", map[string]string{"unchanged.php": "pristine", "setup/index.php": "setup"}},
+		{"refuse", ModifiedRefuse, false, true, "5.2.1", map[string]string{"unchanged.php": "patched", "changed.php": "patched", "setup/index.php": ""}},
+		{"refuse from upstream archive", ModifiedRefuse, true, true, "5.2.1", map[string]string{"unchanged.php": "patched"}},
+		{"carry", ModifiedCarry, false, false, "5.2.2", map[string]string{"unchanged.php": "patched", "changed.php": "upstream 5.2.2", "setup/index.php": ""}},
+		{"carry from upstream archive", ModifiedCarry, true, false, "5.2.2", map[string]string{"unchanged.php": "patched", "changed.php": "upstream 5.2.2", "setup/index.php": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupMockRelease(t, release)
+			archiveServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeFile(w, r, pristineZip)
+			}))
+			t.Cleanup(archiveServer.Close)
+			originalArchiveURL := version.ArchiveURLFormat
+			version.ArchiveURLFormat = archiveServer.URL + "/%s.zip"
+			t.Cleanup(func() { version.ArchiveURLFormat = originalArchiveURL })
+
+			mem := memInstallation(t, dest)
+			for name, content := range pristine {
+				if err := mem.MkdirAll(filepath.Dir(filepath.Join(dest, name)), 0755); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+				if err := fs.WriteFile(mem, filepath.Join(dest, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+			if !tt.noManifest {
+				m, err := manifest.Build(mem, dest, "5.2.1")
+				if err != nil {
+					t.Fatalf("failed to build manifest: %v", err)
+				}
+				if err := m.Save(mem, dest); err != nil {
+					t.Fatalf("failed to save manifest: %v", err)
+				}
+			}
+
+			for _, name := range []string{"unchanged.php", "changed.php"} {
+				if err := fs.WriteFile(mem, filepath.Join(dest, name), []byte("patched"), 0644); err != nil {
+					t.Fatalf("failed to patch %s: %v", name, err)
+				}
+			}
+			if err := mem.RemoveAll(dest + "/setup/index.php"); err != nil {
+				t.Fatalf("failed to remove setup: %v", err)
+			}
+
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", ModifiedFiles: tt.policy, FS: mem}
+			err := Run(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := installedRelease(mem, dest); got != tt.wantVersion {
+				t.Errorf("expected %s installed, got %s", tt.wantVersion, got)
+			}
+			for name, content := range tt.want {
+				data, err := fs.ReadFile(mem, filepath.Join(dest, name))
+				switch {
+				case content == "" && !errors.Is(err, os.ErrNotExist):
+					t.Errorf("expected %s removed, got %q (%v)", name, data, err)
+				case content != "" && (err != nil || string(data) != content):
+					t.Errorf("expected %s to be %q, got %q (%v)", name, content, data, err)
+				}
+			}
+
+			if tt.wantErr {
+				if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected no journal left after the refused update, got %v", err)
+				}
+				return
+			}
+			m, err := manifest.Load(mem, dest)
+			if err != nil || m == nil || m.Version != "5.2.2" {
+				t.Fatalf("expected manifest of 5.2.2 saved, got %+v (%v)", m, err)
+			}
+			if _, ok := m.Files["unchanged.php"]; !ok {
+				t.Errorf("expected manifest to list release files, got %v", m.Files)
+			}
+		})
+	}
+
+	if err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php",
+		ModifiedFiles: "revert"}); err == nil {
+		t.Error("expected error for unknown modified files policy")
+	}
+}
+
+func TestRun_LocalPatches(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":  "new version",
+		"phpMyAdmin-5.2.2-all-languages/login.php": "<?php\n$banner = '';\nlogin($banner);\n",
+	})
+
+	bannerPatch := "--- a/login.php\n+++ b/login.php\n@@ -1,3 +1,3 @@\n <?php\n-$banner = '';\n+$banner = 'Authorized use only';\n login($banner);\n"
+	tests := []struct {
+		name    string
+		patch   string
+		wantErr string
+	}{
+		{"applies", bannerPatch, ""},
+		{"does not apply", strings.Replace(bannerPatch, " login($banner);", " login();", 1), "hunk #1 at line 1 FAILED"},
+		{"malformed", "--- a/login.php\n", "failed to load local patches"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			if err := mem.MkdirAll("/etc/pma-up/patches", 0755); err != nil {
+				t.Fatalf("failed to create patches directory: %v", err)
+			}
+			if err := fs.WriteFile(mem, "/etc/pma-up/patches/banner.patch", []byte(tt.patch), 0644); err != nil {
+				t.Fatalf("failed to write patch: %v", err)
+			}
+
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", PatchesDir: "/etc/pma-up/patches", FS: mem}
+			err := Run(context.Background(), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if got := installedRelease(mem, dest); got != "5.2.1" {
+					t.Errorf("expected installation left untouched, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			data, err := fs.ReadFile(mem, dest+"/login.php")
+			if err != nil || string(data) != "<?php\n$banner = 'Authorized use only';\nlogin($banner);\n" {
+				t.Errorf("expected patched release, got %q (%v)", data, err)
+			}
+			m, err := manifest.Load(mem, dest)
+			if err != nil || m == nil {
+				t.Fatalf("expected manifest, got %v", err)
+			}
+			if changes, err := m.Diff(mem, dest); err != nil || !slices.Equal(changes.Modified, []string{"login.php"}) {
+				t.Errorf("expected manifest to describe the release before patching, got %+v (%v)", changes, err)
+			}
+		})
+	}
+}
+
+func TestCompareDirectives(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	mem := memInstallation(t, dest)
+	files := map[string]string{
+		dest + "/config.inc.php":                    "<?php\n$cfg['ShowPhpInfo'] = true;\n$cfg['ShowPhpInfoo'] = true;\n",
+		dest + "/libraries/config.default.php":      "<?php\n$cfg['ShowPhpInfo'] = false;\n",
+		"/tmp/release/libraries/config.default.php": "<?php\n$cfg['ShowGitRevision'] = true;\n",
+	}
+	for name, content := range files {
+		if err := mem.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := fs.WriteFile(mem, name, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: mem}
+	report, err := compareDirectives(opts, "/tmp/release")
+	if err != nil {
+		t.Fatalf("compareDirectives failed: %v", err)
+	}
+	if len(report.Dropped) != 1 || report.Dropped[0].Key.String() != "$cfg['ShowPhpInfo']" ||
+		len(report.Unknown) != 1 || report.Unknown[0].Key.String() != "$cfg['ShowPhpInfoo']" ||
+		len(report.New) != 1 || report.New[0].String() != "$cfg['ShowGitRevision']" {
+		t.Errorf("unexpected report:\n%s", report.Describe())
+	}
+
+	// Releases without config.default.php are not checked.
+	if _, err := compareDirectives(opts, "/tmp/other"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not-exist error, got %v", err)
+	}
+}
+
+func TestRun_BlowfishSecret(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+
+	const shortConfig = "<?php\n$cfg['blowfish_secret'] = 'too short';\n$cfg['Servers'][1]['host'] = 'db';\n"
+	tests := []struct {
+		name       string
+		configPath string
+		config     string
+		fix        bool
+		wantFixed  bool
+	}{
+		{"reported only", dest + "/config.inc.php", shortConfig, false, false},
+		{"fixed", dest + "/config.inc.php", shortConfig, true, true},
+		{"fixed outside the installation", "/etc/phpmyadmin/config.inc.php", "<?php\n$cfg['Servers'][1]['host'] = 'db';\n", true, true},
+		{"not a constant", dest + "/config.inc.php", "<?php\n$cfg['blowfish_secret'] = getenv('PMA_SECRET');\n", true, false},
+		{"too long", dest + "/config.inc.php", "<?php\n$cfg['blowfish_secret'] = '" + strings.Repeat("s", BlowfishSecretLength+1) + "';\n$cfg['Servers'][1]['host'] = 'db';\n", true, true},
+		{"exact length", dest + "/config.inc.php", "<?php\n$cfg['blowfish_secret'] = '" + strings.Repeat("s", BlowfishSecretLength) + "';\n", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			if err := mem.MkdirAll(filepath.Dir(tt.configPath), 0755); err != nil {
+				t.Fatalf("failed to create config directory: %v", err)
+			}
+			if err := mem.RemoveAll(tt.configPath); err != nil {
+				t.Fatalf("failed to remove config: %v", err)
+			}
+			if err := fs.WriteFile(mem, tt.configPath, []byte(tt.config), 0640); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+
+			opts := Options{DestinationPath: dest, ConfigFilePath: tt.configPath, FixBlowfishSecret: tt.fix, FS: mem}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			installed, err := fs.ReadFile(mem, dest+"/config.inc.php")
+			if err != nil {
+				t.Fatalf("failed to read installed config: %v", err)
+			}
+			// The original is saved next to the installation, never inside
+			// it or next to the config file.
+			dirs := []string{filepath.Dir(dest), dest}
+			if filepath.Dir(tt.configPath) != dest {
+				dirs = append(dirs, filepath.Dir(tt.configPath))
+			}
+			var backups []string
+			for _, dir := range dirs {
+				entries, err := mem.ReadDir(dir)
+				if err != nil {
+					t.Fatalf("failed to list %s: %v", dir, err)
+				}
+				for _, entry := range entries {
+					if strings.Contains(entry.Name(), "pma-up-backup-") {
+						backups = append(backups, filepath.Join(dir, entry.Name()))
+					}
+				}
+			}
+
+			if !tt.wantFixed {
+				if string(installed) != tt.config || len(backups) != 0 {
+					t.Errorf("expected config untouched, got %q and backups %v", installed, backups)
+				}
+				return
+			}
+
+			if problem, err := secretProblem(installed); err != nil || problem != "" {
+				t.Errorf("expected a valid secret, got %q (%v) in %q", problem, err, installed)
+			}
+			if !strings.Contains(string(installed), "$cfg['Servers'][1]['host'] = 'db';") {
+				t.Errorf("expected the rest of the config kept, got %q", installed)
+			}
+			if source, err := fs.ReadFile(mem, tt.configPath); err != nil || string(source) != string(installed) {
+				t.Errorf("expected the config file itself fixed, got %q (%v)", source, err)
+			}
+			if info, err := mem.Stat(dest + "/config.inc.php"); err != nil || info.Mode().Perm() != 0640 {
+				t.Errorf("expected config mode kept, got %v (%v)", info, err)
+			}
+			if len(backups) != 1 || !strings.HasPrefix(backups[0], "/var/www/.phpmyadmin.config.inc.pma-up-backup-") ||
+				filepath.Ext(backups[0]) != ".php" {
+				t.Fatalf("expected one backup next to the installation, got %v", backups)
+			}
+			if original, err := fs.ReadFile(mem, backups[0]); err != nil || string(original) != tt.config {
+				t.Errorf("expected the original config backed up, got %q (%v)", original, err)
+			}
+		})
+	}
+}
+
+func TestRun_Inventory(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt": "new version",
+	})
+	secret := strings.Repeat("s", BlowfishSecretLength)
+
+	tests := []struct {
+		name      string
+		inventory string
+		env       string
+		wantErr   string
+	}{
+		{"renders", "blowfish_secret_env = 'PMA_SECRET'\n[[servers]]\nhost = 'db1'\n", secret, ""},
+		{"missing secret", "blowfish_secret_env = 'PMA_SECRET_UNSET'\n[[servers]]\nhost = 'db1'\n", secret, "PMA_SECRET_UNSET is not set"},
+		{"short secret", "blowfish_secret_env = 'PMA_SECRET'\n[[servers]]\nhost = 'db1'\n", "short", "blowfish_secret is too short"},
+		{"invalid", "blowfish_secret_env = 'PMA_SECRET'\n", secret, "at least one server is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PMA_SECRET", tt.env)
+			mem := memInstallation(t, dest)
+			if err := mem.MkdirAll("/etc/pma-up", 0755); err != nil {
+				t.Fatalf("failed to create inventory directory: %v", err)
+			}
+			if err := fs.WriteFile(mem, "/etc/pma-up/inventory.toml", []byte(tt.inventory), 0600); err != nil {
+				t.Fatalf("failed to write inventory: %v", err)
+			}
+
+			opts := Options{DestinationPath: dest, Inventory: "/etc/pma-up/inventory.toml", FS: mem}
+			err := Run(context.Background(), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if got := installedRelease(mem, dest); got != "5.2.1" {
+					t.Errorf("expected installation left untouched, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			config, err := fs.ReadFile(mem, dest+"/config.inc.php")
+			if err != nil || !strings.Contains(string(config), "$cfg['blowfish_secret'] = '"+secret+"';") ||
+				!strings.Contains(string(config), "$cfg['Servers'][$i]['host'] = 'db1';") {
+				t.Errorf("expected rendered config, got %q (%v)", config, err)
+			}
+			if _, err := mem.Stat(dest + "/file.txt"); err != nil {
+				t.Errorf("expected new release installed: %v", err)
+			}
+		})
+	}
+
+	err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: "/etc/phpmyadmin/config.inc.php", Inventory: "/etc/pma-up/inventory.toml", FS: fs.NewMem()})
+	if err == nil || !strings.Contains(err.Error(), "cannot be used with it") {
+		t.Errorf("expected error for config file path with an inventory, got %v", err)
+	}
+}
+
+// freshRelease is the release fresh installations are tested with: its
+// sample configuration has the empty blowfish_secret phpMyAdmin ships.
+var freshRelease = map[string]string{
+	"phpMyAdmin-5.2.2-all-languages/file.txt":              "new version",
+	"phpMyAdmin-5.2.2-all-languages/package.json":          `{"version":"5.2.2"}`,
+	"phpMyAdmin-5.2.2-all-languages/config.sample.inc.php": "<?php\n$cfg['blowfish_secret'] = '';\n$cfg['Servers'][1]['host'] = 'localhost';\n",
+}
+
+func TestRun_Install(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+	template := "<?php\n$cfg['blowfish_secret'] = '" + strings.Repeat("t", BlowfishSecretLength) + "';\n"
+
+	tests := []struct {
+		name   string
+		opts   Options
+		setup  func(t *testing.T, mem *fs.Mem)
+		config string // Path of the config file created, and its content when not from the sample
+		want   string
+	}{
+		{name: "missing destination", config: dest + "/config.inc.php"},
+		{name: "empty mount point", setup: func(t *testing.T, mem *fs.Mem) {
+			if err := mem.MkdirAll(dest, 0755); err != nil {
+				t.Fatalf("failed to create destination: %v", err)
+			}
+			if err := mem.Mount(dest); err != nil {
+				t.Fatalf("failed to mount destination: %v", err)
+			}
+		}, config: dest + "/config.inc.php"},
+		{name: "releases layout", opts: Options{Layout: LayoutReleases}, setup: func(t *testing.T, mem *fs.Mem) {
+			if err := mem.MkdirAll(dest, 0755); err != nil {
+				t.Fatalf("failed to create destination: %v", err)
+			}
+		}, config: dest + "/config.inc.php"},
+		{name: "template", opts: Options{ConfigFilePath: "/etc/phpmyadmin/config.inc.php", ConfigTemplate: "/etc/pma-up/config.php"},
+			config: "/etc/phpmyadmin/config.inc.php", want: template},
+		{name: "existing external config", opts: Options{ConfigFilePath: "/etc/phpmyadmin/config.inc.php"}, setup: func(t *testing.T, mem *fs.Mem) {
+			if err := fs.WriteFile(mem, "/etc/phpmyadmin/config.inc.php", []byte(template), 0640); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+		}, config: "/etc/phpmyadmin/config.inc.php", want: template},
+		{name: "tmp owner", opts: Options{TmpOwner: "123:456"}, config: dest + "/config.inc.php"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := fs.NewMem()
+			for _, dir := range []string{"/etc/phpmyadmin", "/etc/pma-up"} {
+				if err := mem.MkdirAll(dir, 0755); err != nil {
+					t.Fatalf("failed to create %s: %v", dir, err)
+				}
+			}
+			if err := fs.WriteFile(mem, "/etc/pma-up/config.php", []byte(template), 0644); err != nil {
+				t.Fatalf("failed to write template: %v", err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, mem)
+			}
+
+			opts := tt.opts
+			opts.DestinationPath, opts.Install, opts.FS = dest, true, mem
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			for _, name := range []string{"file.txt", "config.inc.php"} {
+				if _, err := mem.Stat(filepath.Join(dest, name)); err != nil {
+					t.Errorf("expected %s installed: %v", name, err)
+				}
+			}
+			config, err := fs.ReadFile(mem, tt.config)
+			if err != nil {
+				t.Fatalf("expected config created: %v", err)
+			}
+			if tt.want != "" {
+				if string(config) != tt.want {
+					t.Errorf("expected config %q, got %q", tt.want, config)
+				}
+			} else if problem, err := secretProblem(config); problem != "" || err != nil ||
+				!strings.Contains(string(config), "$cfg['Servers'][1]['host'] = 'localhost';") {
+				t.Errorf("expected sample config with a generated secret, got %q (%s, %v)", config, problem, err)
+			}
+
+			info, err := mem.Stat(dest + "/tmp")
+			if err != nil || !info.IsDir() || info.Mode().Perm() != 0750 {
+				t.Fatalf("expected tmp directory with mode 0750, got %v (%v)", info, err)
+			}
+			if opts.TmpOwner != "" {
+				if uid, gid, err := mem.Owner(dest + "/tmp"); err != nil || uid != 123 || gid != 456 {
+					t.Errorf("expected tmp owned by 123:456, got %d:%d (%v)", uid, gid, err)
+				}
+			}
+			if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected journal removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRun_InstallRefused(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+
+	mem := memInstallation(t, dest)
+	err := Run(context.Background(), Options{DestinationPath: dest, Install: true, FS: mem})
+	if err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("expected error for a non-empty destination, got %v", err)
+	}
+	if got := installedRelease(mem, dest); got != "5.2.1" {
+		t.Errorf("expected installation untouched, got %s", got)
+	}
+
+	err = Run(context.Background(), Options{DestinationPath: "/var/www/missing", ConfigFilePath: "/var/www/missing/config.inc.php", FS: mem})
+	if err == nil || !strings.Contains(err.Error(), "use install mode") {
+		t.Errorf("expected update of a missing destination to suggest install mode, got %v", err)
+	}
+
+	for _, opts := range []Options{
+		{Version: "latest"},
+		{ConfigTemplate: "/etc/pma-up/config.php"},
+		{Install: true, ConfigTemplate: "/etc/pma-up/missing.php"},
+	} {
+		opts.DestinationPath, opts.ConfigFilePath, opts.FS = "/srv/pma", "/srv/pma/config.inc.php", mem
+		if err := Run(context.Background(), opts); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+	if _, err := mem.Lstat("/srv/pma"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing installed, got %v", err)
+	}
+
+	// The empty directory the releases layout replaces survives a failure.
+	if err := mem.MkdirAll("/srv/empty", 0755); err != nil {
+		t.Fatalf("failed to create destination: %v", err)
+	}
+	err = Run(context.Background(), Options{DestinationPath: "/srv/empty", Install: true, Layout: LayoutReleases, LintPHP: true, FS: mem,
+		LintPHPFile: func(context.Context, string, string) error { return errors.New("PHP Parse error") }})
+	if err == nil || !strings.Contains(err.Error(), "PHP Parse error") {
+		t.Errorf("expected lint failure, got %v", err)
+	}
+	if empty, err := isEmptyDir(mem, "/srv/empty"); err != nil || !empty {
+		t.Errorf("expected empty destination kept, got %v (%v)", empty, err)
+	}
+}
+
+func TestRun_PinnedVersion(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+	// A pinned release is downloaded without looking up the latest one.
+	version.VersionURL = "http://127.0.0.1:0/version.txt"
+
+	var downloaded string
+	mem := fs.NewMem()
+	opts := Options{DestinationPath: dest, Install: true, Version: "5.2.2", FS: &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+		for _, path := range paths {
+			if strings.HasSuffix(path, ".zip") {
+				downloaded = filepath.Base(path)
+			}
+		}
+		return nil
+	}}}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(downloaded, "5.2.2") {
+		t.Errorf("expected release 5.2.2 downloaded, got %q", downloaded)
+	}
+	if _, err := mem.Stat(dest + "/file.txt"); err != nil {
+		t.Errorf("expected release installed: %v", err)
+	}
+}
+
+func TestRecoverInterrupted_InstallCrashAtEveryStep(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+
+	emptyDir := func(t *testing.T) *fs.Mem {
+		mem := fs.NewMem()
+		if err := mem.MkdirAll(dest, 0755); err != nil {
+			t.Fatalf("failed to create destination: %v", err)
+		}
+		return mem
+	}
+	tests := []struct {
+		name   string
+		layout Layout
+		setup  func(t *testing.T) *fs.Mem
+	}{
+		{"missing destination", LayoutInPlace, func(t *testing.T) *fs.Mem { return fs.NewMem() }},
+		{"empty mount point", LayoutInPlace, func(t *testing.T) *fs.Mem {
+			mem := emptyDir(t)
+			if err := mem.Mount(dest); err != nil {
+				t.Fatalf("failed to mount destination: %v", err)
+			}
+			return mem
+		}},
+		{"empty directory with releases layout", LayoutReleases, emptyDir},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := Options{DestinationPath: dest, Install: true, Layout: tt.layout}
+
+			var mu sync.Mutex
+			steps := 0
+			opts.FS = &fs.FaultFS{FS: tt.setup(t), Fail: func(string, ...string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				steps++
+				return nil
+			}}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			for step := 1; step <= steps; step++ {
+				mem := tt.setup(t)
+				calls := 0
+				var crashed string
+				opts.FS = &fs.FaultFS{FS: mem, Fail: func(op string, paths ...string) error {
+					mu.Lock()
+					defer mu.Unlock()
+					if calls++; calls >= step {
+						if crashed == "" {
+							crashed = fmt.Sprintf("%s %v", op, paths)
+						}
+						return fmt.Errorf("crashed")
+					}
+					return nil
+				}}
+				_ = Run(context.Background(), opts)
+
+				recoverOpts, err := Options{DestinationPath: dest, Install: true, Layout: tt.layout, FS: mem}.withDefaults()
+				if err != nil {
+					t.Fatalf("invalid options: %v", err)
+				}
+				if err := recoverInterrupted(context.Background(), recoverOpts, journal.Path(dest)); err != nil {
+					t.Errorf("step %d (%s): recovery failed: %v", step, crashed, err)
+					continue
+				}
+
+				// The installation is either complete or absent, in which case
+				// installing again succeeds.
+				if _, err := mem.Stat(dest + "/file.txt"); err == nil {
+					if _, err := mem.Stat(dest + "/config.inc.php"); err != nil {
+						t.Errorf("step %d (%s): expected config with the installed release: %v", step, crashed, err)
+					}
+					continue
+				}
+				if err := checkInstallTarget(recoverOpts); err != nil {
+					t.Errorf("step %d (%s): expected an installable destination after recovery: %v", step, crashed, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_PHPRequirements(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":      "new version",
+		"phpMyAdmin-5.2.2-all-languages/composer.json": `{"require": {"php": "^8.1", "ext-mysqli": "*", "ext-iconv": "*"}}`,
+	})
+	tests := []struct {
+		name    string
+		opts    Options
+		host    *php.Host
+		err     error
+		wantErr string
+	}{
+		{name: "compatible", host: &php.Host{Version: "8.2.7", Extensions: []string{"iconv", "mysqli"}}},
+		{name: "old PHP", host: &php.Host{Version: "7.4.33", Extensions: []string{"iconv", "mysqli"}},
+			wantErr: "PHP 7.4.33 is installed, but ^8.1 is required"},
+		{name: "missing extension", host: &php.Host{Version: "8.2.7", Extensions: []string{"iconv"}},
+			wantErr: "required PHP extensions are not loaded: mysqli"},
+		{name: "skipped", opts: Options{SkipPHPCheck: true}, host: &php.Host{Version: "7.4.33"}},
+		{name: "default binary not installed", err: &exec.Error{Name: "php", Err: exec.ErrNotFound}},
+		{name: "configured binary not installed", opts: Options{PHPBinary: "/usr/bin/php8.2"},
+			err: &exec.Error{Name: "/usr/bin/php8.2", Err: exec.ErrNotFound}, wantErr: "failed to check PHP requirements"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var probed string
+			mem := memInstallation(t, dest)
+			opts := tt.opts
+			opts.DestinationPath, opts.ConfigFilePath, opts.FS = dest, dest+"/config.inc.php", mem
+			opts.ProbePHP = func(_ context.Context, binary string) (*php.Host, error) {
+				probed = binary
+				return tt.host, tt.err
+			}
+
+			err := Run(context.Background(), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if got := installedRelease(mem, dest); got != "5.2.1" {
+					t.Errorf("expected installation untouched, got %s", got)
+				}
+				if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected no journal left for recovery, got %v", err)
+				}
+				entries, _ := mem.ReadDir(filepath.Dir(dest))
+				for _, entry := range entries {
+					if strings.HasPrefix(entry.Name(), filepath.Base(dest)+"_backup_") {
+						t.Errorf("expected no backup taken, got %s", entry.Name())
+					}
+				}
+				if idx, err := backup.Load(mem, backup.IndexPath(dest)); err != nil || len(idx.ForSource(dest)) > 0 {
+					t.Errorf("expected no backup recorded, got %v (%v)", idx, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got := installedRelease(mem, dest); got != "5.2.2" {
+				t.Errorf("expected release 5.2.2 installed, got %s", got)
+			}
+			if want := cmp.Or(opts.PHPBinary, DefaultPHPBinary); !opts.SkipPHPCheck && probed != want {
+				t.Errorf("expected %s probed, got %q", want, probed)
+			}
+		})
+	}
+}
+
+func TestRun_LintPHP(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, map[string]string{
+		"phpMyAdmin-5.2.2-all-languages/file.txt":                  "new version",
+		"phpMyAdmin-5.2.2-all-languages/themes/pmahomme/theme.php": "<?php shipped",
+	})
+	tests := []struct {
+		name    string
+		opts    Options
+		mount   bool
+		files   map[string]string
+		config  string // content of a config file outside the installation
+		wantErr string
+	}{
+		{name: "valid", opts: Options{LintPHP: true}},
+		{name: "broken config", opts: Options{LintPHP: true}, files: map[string]string{"config.inc.php": "syntax error"},
+			wantErr: "config.inc.php: PHP Parse error"},
+		{name: "broken preserved file", opts: Options{LintPHP: true}, files: map[string]string{"themes/custom/layout.php": "syntax error"},
+			wantErr: "themes/custom/layout.php: PHP Parse error"},
+		{name: "releases layout", opts: Options{LintPHP: true, Layout: LayoutReleases}, files: map[string]string{"config.inc.php": "syntax error"},
+			wantErr: "config.inc.php: PHP Parse error"},
+		{name: "mount point", opts: Options{LintPHP: true}, mount: true, files: map[string]string{"config.inc.php": "syntax error"},
+			wantErr: "config.inc.php: PHP Parse error"},
+		{name: "broken included config", opts: Options{LintPHP: true, ConfigMode: ConfigInclude}, config: "syntax error",
+			wantErr: "/etc/phpmyadmin/config.inc.php: PHP Parse error"},
+		{name: "disabled", files: map[string]string{"config.inc.php": "syntax error"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := memInstallation(t, dest)
+			files := map[string]string{
+				"config.user.inc.php":      "<?php user",
+				"themes/custom/layout.php": "<?php theme",
+				"themes/custom/theme.json": "{}",
+			}
+			maps.Copy(files, tt.files)
+			for name, content := range files {
+				path := filepath.Join(dest, name)
+				if err := mem.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("failed to create %s: %v", filepath.Dir(path), err)
+				}
+				if err := fs.WriteFile(mem, path, []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			if tt.mount {
+				if err := mem.Mount(dest); err != nil {
+					t.Fatalf("failed to mount %s: %v", dest, err)
+				}
+			}
+			configFilePath := dest + "/config.inc.php"
+			if tt.config != "" {
+				configFilePath = "/etc/phpmyadmin/config.inc.php"
+				if err := mem.MkdirAll(filepath.Dir(configFilePath), 0755); err != nil {
+					t.Fatalf("failed to create config directory: %v", err)
+				}
+				if err := fs.WriteFile(mem, configFilePath, []byte(tt.config), 0644); err != nil {
+					t.Fatalf("failed to write config: %v", err)
+				}
+			}
+
+			var linted []string
+			opts := tt.opts
+			opts.DestinationPath, opts.ConfigFilePath, opts.FS = dest, configFilePath, mem
+			opts.LintPHPFile = func(_ context.Context, binary, path string) error {
+				linted = append(linted, path)
+				data, err := fs.ReadFile(mem, path)
+				if err != nil {
+					return err
+				}
+				if strings.Contains(string(data), "syntax error") {
+					return fmt.Errorf("PHP Parse error:  syntax error in %s on line 1", path)
+				}
+				return nil
+			}
+			err := Run(context.Background(), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if _, err := mem.Stat(dest + "/old.php"); err != nil {
+					t.Errorf("expected installation untouched: %v", err)
+				}
+				if _, err := mem.Stat(dest + "/file.txt"); err == nil {
+					t.Error("expected new release not installed")
+				}
+				if entries, _ := mem.ReadDir(release.DefaultRoot(dest)); tt.opts.Layout == LayoutReleases && len(entries) != 1 {
+					t.Errorf("expected only the adopted release left, got %v", entries)
+				}
+				if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected no journal left for recovery, got %v", err)
+				}
+				entries, _ := mem.ReadDir(filepath.Dir(dest))
+				for _, entry := range entries {
+					if strings.HasPrefix(entry.Name(), filepath.Base(dest)+"_backup_") {
+						t.Errorf("expected no backup taken, got %s", entry.Name())
+					}
+				}
+				if idx, err := backup.Load(mem, backup.IndexPath(dest)); err != nil || len(idx.ForSource(dest)) > 0 {
+					t.Errorf("expected no backup recorded, got %v (%v)", idx, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if _, err := mem.Stat(dest + "/file.txt"); err != nil {
+				t.Errorf("expected new release installed: %v", err)
+			}
+
+			var want []string
+			if opts.LintPHP {
+				want = []string{"config.inc.php", "config.user.inc.php", "themes/custom/layout.php"}
+			}
+			for i, path := range linted {
+				// Files are checked in the staging tree, before the swap.
+				_, linted[i], _ = strings.Cut(path, "phpMyAdmin-5.2.2-all-languages/")
+			}
+			slices.Sort(linted)
+			if !slices.Equal(linted, want) {
+				t.Errorf("expected %v checked, got %v", want, linted)
+			}
+		})
+	}
+}
+
+func TestRun_KeepBackups(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+	mem := memInstallation(t, dest)
+
+	// An earlier backup, and one deleted by hand since.
+	const oldBackup = dest + "_backup_100"
+	if err := mem.MkdirAll(oldBackup, 0755); err != nil {
+		t.Fatalf("failed to create backup: %v", err)
+	}
+	idx := &backup.Index{}
+	idx.Add(backup.Entry{Path: dest + "_backup_50", Version: "5.1.0", CreatedAt: time.Unix(50, 0), SourcePath: dest})
+	idx.Add(backup.Entry{Path: oldBackup, Version: "5.2.0", CreatedAt: time.Unix(100, 0), SourcePath: dest})
+	if err := idx.Save(mem, backup.IndexPath(dest)); err != nil {
+		t.Fatalf("failed to save backup index: %v", err)
+	}
+
+	if err := Run(context.Background(), Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", KeepBackups: 1, FS: mem}); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	idx, err := backup.Load(mem, backup.IndexPath(dest))
+	if err != nil {
+		t.Fatalf("failed to load backup index: %v", err)
+	}
+	if len(idx.Backups) != 1 || idx.Backups[0].Version != "5.2.1" {
+		t.Fatalf("expected only the new backup recorded, got %+v", idx.Backups)
+	}
+	if _, err := mem.Stat(oldBackup); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected old backup removed, got %v", err)
+	}
+	if _, err := mem.Stat(idx.Backups[0].Path); err != nil {
+		t.Errorf("expected new backup kept: %v", err)
+	}
+
+	var out strings.Builder
+	if err := Status(Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: mem}, &out); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	for _, want := range []string{"Installation: " + dest + " (phpMyAdmin 5.2.2)", "Backups, newest first:", "5.2.1  " + idx.Backups[0].Path} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected status to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestStatus_ReleasesLayout(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+	mem := fs.NewMem()
+	opts := Options{DestinationPath: dest, Install: true, Layout: LayoutReleases, FS: mem}
+	if err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var out strings.Builder
+	if err := Status(Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", FS: mem}, &out); err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	current, err := (&release.Layout{FS: mem, Root: release.DefaultRoot(dest), Link: dest}).Current()
+	if err != nil || current == "" {
+		t.Fatalf("expected an active release, got %q (%v)", current, err)
+	}
+	for _, want := range []string{"(phpMyAdmin 5.2.2)", "* " + current, "Backups: none"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected status to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRollback(t *testing.T) {
+	const dest = "/var/www/phpmyadmin"
+	setupMockRelease(t, freshRelease)
+
+	tests := []struct {
+		name   string
+		layout Layout
+		setup  func(t *testing.T) *fs.Mem
+	}{
+		{"in-place", LayoutInPlace, func(t *testing.T) *fs.Mem { return memInstallation(t, dest) }},
+		{"symlink", LayoutInPlace, func(t *testing.T) *fs.Mem {
+			mem := memInstallation(t, dest+"-5.2.1")
+			if err := mem.Symlink(dest+"-5.2.1", dest); err != nil {
+				t.Fatalf("failed to create symlink: %v", err)
+			}
+			return mem
+		}},
+		{"mount", LayoutInPlace, func(t *testing.T) *fs.Mem {
+			mem := memInstallation(t, dest)
+			if err := mem.Mount(dest); err != nil {
+				t.Fatalf("failed to mount destination: %v", err)
+			}
+			return mem
+		}},
+		{"releases", LayoutReleases, func(t *testing.T) *fs.Mem { return memInstallation(t, dest) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := tt.setup(t)
+			opts := Options{DestinationPath: dest, ConfigFilePath: dest + "/config.inc.php", Layout: tt.layout, FS: mem}
+			if err := Rollback(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "no backup recorded") {
+				t.Fatalf("expected error without backups, got %v", err)
+			}
+			if err := Run(context.Background(), opts); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			// Each rollback restores the newest backup and records the
+			// installation it replaces, so a second one switches back.
+			for _, want := range []string{"5.2.1", "5.2.2"} {
+				if err := Rollback(context.Background(), opts); err != nil {
+					t.Fatalf("Rollback to %s failed: %v", want, err)
+				}
+				if got := installedRelease(mem, dest); got != want {
+					t.Errorf("expected %s after rollback, got %s", want, got)
+				}
+				if _, err := mem.Lstat(journal.Path(dest)); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected no journal left, got %v", err)
+				}
+
+				idx, err := backup.Load(mem, backup.IndexPath(dest))
+				if err != nil {
+					t.Fatalf("failed to load backup index: %v", err)
+				}
+				entries := idx.ForSource(dest)
+				if len(entries) == 0 || entries[0].Version == want {
+					t.Fatalf("expected the replaced installation recorded as the newest backup, got %+v", entries)
+				}
+				active, _ := fs.EvalSymlinks(mem, dest)
+				for _, entry := range entries {
+					if entry.Path == active {
+						t.Errorf("expected the restored backup no longer recorded, got %+v", entry)
+					}
+				}
+			}
+
+			opts.Version = "5.0.0"
+			if err := Rollback(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "no backup of phpMyAdmin 5.0.0") {
+				t.Errorf("expected error for a version never backed up, got %v", err)
+			}
+		})
+	}
+}
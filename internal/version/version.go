@@ -4,17 +4,32 @@ package version
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
 )
 
 // VersionURL defines the URL endpoint where phpMyAdmin publishes the latest version information.
 // This file is fetched and parsed to retrieve release version, release date, and download URL.
 var VersionURL = "https://www.phpmyadmin.net/home_page/version.txt"
 
+// ArchiveURLFormat is the download URL of the archive of a given phpMyAdmin
+// release, formatted with the release version as its only operand.
+var ArchiveURLFormat = "https://files.phpmyadmin.net/phpMyAdmin/%[1]s/phpMyAdmin-%[1]s-all-languages.zip"
+
+// ArchiveURL returns the download URL of the archive of the phpMyAdmin release v.
+func ArchiveURL(v string) string {
+	return fmt.Sprintf(ArchiveURLFormat, v)
+}
+
 // PhpMyAdminVersion represents the phpMyAdmin release information
 // fetched from the version.txt endpoint.
 type PhpMyAdminVersion struct {
@@ -28,15 +43,18 @@ type PhpMyAdminVersion struct {
 // It downloads and parses the version.txt file from the phpMyAdmin site,
 // returning the release version, release date, and download URL.
 //
+// Parameters:
+//   - ctx: cancels the request.
+//
 // Returns:
 //   - *PhpMyAdminVersion: parsed release information
 //   - error: non-nil if fetching or parsing fails.
-func FetchLatestVersion() (*PhpMyAdminVersion, error) {
+func FetchLatestVersion(ctx context.Context) (*PhpMyAdminVersion, error) {
 	client := &http.Client{
 		Timeout: 15 * time.Second,
 	}
 
-	req, err := http.NewRequest("GET", VersionURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", VersionURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
@@ -76,3 +94,42 @@ func FetchLatestVersion() (*PhpMyAdminVersion, error) {
 
 	return version, nil
 }
+
+// versionPHPPattern matches the version constant declared in libraries/classes/Version.php.
+var versionPHPPattern = regexp.MustCompile(`VERSION\s*=\s*'([^']+)'`)
+
+// readmeVersionPattern matches the "Version x.y.z" line near the top of the README file.
+var readmeVersionPattern = regexp.MustCompile(`(?m)^Version\s+(\S+)\s*$`)
+
+// DetectInstalled determines the phpMyAdmin version installed in dir on fsys.
+//
+// It inspects, in order, package.json, libraries/classes/Version.php and the
+// README file shipped with every release.
+//
+// Returns:
+//   - string: detected version (e.g. "5.2.1").
+//   - error: non-nil if none of the known files reveal the version.
+func DetectInstalled(fsys fs.FS, dir string) (string, error) {
+	if data, err := fs.ReadFile(fsys, filepath.Join(dir, "package.json")); err == nil {
+		var pkg struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(data, &pkg) == nil && pkg.Version != "" {
+			return pkg.Version, nil
+		}
+	}
+
+	if data, err := fs.ReadFile(fsys, filepath.Join(dir, "libraries", "classes", "Version.php")); err == nil {
+		if match := versionPHPPattern.FindSubmatch(data); match != nil {
+			return string(match[1]), nil
+		}
+	}
+
+	if data, err := fs.ReadFile(fsys, filepath.Join(dir, "README")); err == nil {
+		if match := readmeVersionPattern.FindSubmatch(data); match != nil {
+			return string(match[1]), nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to detect phpMyAdmin version in %s", dir)
+}
@@ -1,11 +1,16 @@
 package version
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/jsas4coding/pma-up/internal/fs"
 )
 
 func TestFetchLatestVersion(t *testing.T) {
@@ -22,7 +27,7 @@ func TestFetchLatestVersion(t *testing.T) {
 	VersionURL = server.URL
 	defer func() { VersionURL = originalVersionURL }()
 
-	got, err := FetchLatestVersion()
+	got, err := FetchLatestVersion(context.Background())
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -47,7 +52,7 @@ func TestFetchLatestVersion_FailureScenarios(t *testing.T) {
 		VersionURL = "http://127.0.0.1:1/non-existent"
 		defer func() { VersionURL = originalURL }()
 
-		_, err := FetchLatestVersion()
+		_, err := FetchLatestVersion(context.Background())
 		if err == nil {
 			t.Errorf("expected error from unreachable version URL, got nil")
 		}
@@ -67,7 +72,7 @@ func TestFetchLatestVersion_FailureScenarios(t *testing.T) {
 		VersionURL = server.URL
 		defer func() { VersionURL = originalURL }()
 
-		_, err := FetchLatestVersion()
+		_, err := FetchLatestVersion(context.Background())
 		if err == nil {
 			t.Errorf("expected error for incomplete response, got nil")
 		}
@@ -83,7 +88,7 @@ func TestFetchLatestVersion_FailureScenarios(t *testing.T) {
 		VersionURL = server.URL
 		defer func() { VersionURL = originalURL }()
 
-		_, err := FetchLatestVersion()
+		_, err := FetchLatestVersion(context.Background())
 		if err == nil {
 			t.Errorf("expected error for empty response, got nil")
 		}
@@ -94,9 +99,56 @@ func TestFetchLatestVersion_FailureScenarios(t *testing.T) {
 		VersionURL = ":/invalid-url"
 		defer func() { VersionURL = originalURL }()
 
-		_, err := FetchLatestVersion()
+		_, err := FetchLatestVersion(context.Background())
 		if err == nil || !strings.Contains(err.Error(), "failed to create HTTP request") {
 			t.Errorf("expected HTTP request creation error, got %v", err)
 		}
 	})
 }
+
+func TestDetectInstalled(t *testing.T) {
+	t.Run("package.json", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"name":"phpmyadmin","version":"5.2.1"}`), 0644); err != nil {
+			t.Fatalf("failed to write package.json: %v", err)
+		}
+		got, err := DetectInstalled(fs.OS{}, dir)
+		if err != nil || got != "5.2.1" {
+			t.Errorf("expected 5.2.1, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("Version.php", func(t *testing.T) {
+		dir := t.TempDir()
+		classes := filepath.Join(dir, "libraries", "classes")
+		if err := os.MkdirAll(classes, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		content := "<?php\nfinal class Version\n{\n    public const VERSION = '5.2.0';\n}\n"
+		if err := os.WriteFile(filepath.Join(classes, "Version.php"), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write Version.php: %v", err)
+		}
+		got, err := DetectInstalled(fs.OS{}, dir)
+		if err != nil || got != "5.2.0" {
+			t.Errorf("expected 5.2.0, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("README", func(t *testing.T) {
+		dir := t.TempDir()
+		content := "phpMyAdmin - Readme\n===================\n\nVersion 4.9.11\n"
+		if err := os.WriteFile(filepath.Join(dir, "README"), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write README: %v", err)
+		}
+		got, err := DetectInstalled(fs.OS{}, dir)
+		if err != nil || got != "4.9.11" {
+			t.Errorf("expected 4.9.11, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := DetectInstalled(fs.OS{}, t.TempDir()); err == nil {
+			t.Errorf("expected error for unknown installation")
+		}
+	})
+}